@@ -1,6 +1,6 @@
 module SqliteDBEngine-Clone
 
-go 1.22.0
+go 1.23
 
 toolchain go1.23.3
 
@@ -0,0 +1,549 @@
+package storage
+
+import (
+	"cmp"
+	"errors"
+	"golang.org/x/exp/constraints"
+	"iter"
+	"sync"
+)
+
+/*
+BPlusTree is the variant of BTree that SQLite uses for tables: interior nodes only hold separator keys
+and every record lives in a leaf. Leaves are linked to their neighbours, so a scan moves from one leaf
+to the next without going back up the tree. In the SQLite format they are not, see SQLiteCodec.go.
+
+For a separator K[i] of an interior node, the keys in C[i] are less than K[i] and the keys in C[i+1]
+are greater than or equal to it. Keys are unique, Put replaces the record of an existing key.
+Nodes have the same order m as the nodes of a BTree with the same page size.
+
+Like a BTree, a BPlusTree is safe for concurrent use: every exported method, those of its cursors included,
+holds a readers-writer lock on the whole tree. Its changes wait for a transaction on the tree, or on its Pager,
+like those of a BTree; the transactions on a BPlusTree are the ones of a Table. It keeps the old versions of its
+nodes like a BTree too (see Snapshot.go), so its iterators read the tree as it was when the loop started.
+*/
+
+type BPlusTree[T any] struct {
+	root       *Node[T] // the root node never moves to another page
+	m          int
+	height     int
+	txHeight   int  // height when the open transaction began
+	inTx       bool // a transaction is open, its changes are committed with it
+	nodes      nodeStore[T]
+	versions   *versionedNodes[T] // the same store as nodes
+	compare    func(a, b T) int
+	txLock     *txLock // of the tree in memory, of the pager on a Pager, see Tx.go
+	unlinked   bool    // the leaves are not linked, as in the SQLite format
+	iterations iterationErr
+	mu         sync.RWMutex
+}
+
+func NewBPlusTree[T constraints.Ordered](pageSize int) (error, *BPlusTree[T]) {
+	return NewBPlusTreeFunc[T](pageSize, cmp.Compare[T])
+}
+
+// NewBPlusTreeFunc creates an in-memory tree that orders its keys with compare
+func NewBPlusTreeFunc[T any](pageSize int, compare func(a, b T) int) (error, *BPlusTree[T]) {
+	m := (pageSize - 8) / 32
+
+	if m < 3 {
+		return errors.New("page size is too small: order of btree must be at least 3"), nil
+	}
+
+	tree := &BPlusTree[T]{
+		m:        m,
+		height:   1,
+		versions: newVersionedNodes[T](newMemNodes[T](m)),
+		compare:  compare,
+		txLock:   newTxLock(),
+	}
+	tree.nodes = tree.versions
+	_, tree.root = tree.nodes.alloc(true)
+	tree.root.bplus = true
+	tree.versions.root = tree.root.pgno
+	return nil, tree
+}
+
+// OpenBPlusTree opens the tree whose root node is stored in page root of pager, see OpenBTree
+func OpenBPlusTree[T constraints.Ordered](pager *Pager, root Pgno) (error, *BPlusTree[T]) {
+	return OpenBPlusTreeFunc[T](pager, root, cmp.Compare[T])
+}
+
+// OpenBPlusTreeFunc is OpenBPlusTree for a tree that orders its keys with compare, see OpenBTreeFunc
+func OpenBPlusTreeFunc[T any](pager *Pager, root Pgno, compare func(a, b T) int) (error, *BPlusTree[T]) {
+	if keyType[T]() == 0 {
+		return errors.New("keys of this type cannot be stored in a page"), nil
+	}
+	if pager.sqlite && keyType[T]() != keyTypeInt {
+		return errors.New("only BPlusTrees with integer keys are stored in the SQLite format"), nil
+	}
+	m := (pager.PageSize() - 8) / 32
+	tree := &BPlusTree[T]{
+		m:        m,
+		height:   1,
+		versions: newVersionedNodes[T](&pagerNodes[T]{m: m, pager: pager}),
+		compare:  compare,
+		txLock:   pager.txLock,
+		unlinked: pager.sqlite,
+	}
+	tree.nodes = tree.versions
+
+	var err error
+	if root == 0 {
+		err, tree.root = tree.nodes.alloc(true)
+		if err != nil {
+			return err, nil
+		}
+		tree.root.bplus = true
+		tree.versions.root = tree.root.pgno
+		if err = tree.nodes.save(tree.root); err != nil {
+			return err, nil
+		}
+		return nil, tree
+	}
+
+	err, tree.root = tree.nodes.load(root)
+	if err != nil {
+		return err, nil
+	}
+	tree.versions.root = root
+	if !tree.root.bplus {
+		return errors.New("page does not hold the root of a BPlusTree"), nil
+	}
+	for node := tree.root; !node.isLeaf; tree.height++ {
+		err, node = tree.nodes.load(node.C[0])
+		if err != nil {
+			return err, nil
+		}
+	}
+	return nil, tree
+}
+
+// Root returns the page number of the root node
+func (tree *BPlusTree[T]) Root() Pgno {
+	tree.mu.RLock()
+	defer tree.mu.RUnlock()
+	return tree.root.pgno
+}
+
+// Put sets the record of key, replacing the old record when key already exists
+func (tree *BPlusTree[T]) Put(key T, record []byte) error {
+	tree.txLock.enter()
+	defer tree.txLock.leave()
+	return tree.insert(key, record, true)
+}
+
+// Insert adds key with its record, failing with ErrDuplicateKey when key already exists
+func (tree *BPlusTree[T]) Insert(key T, record []byte) error {
+	tree.txLock.enter()
+	defer tree.txLock.leave()
+	return tree.insert(key, record, false)
+}
+
+// insert is Put or Insert for a caller that holds the transaction lock
+func (tree *BPlusTree[T]) insert(key T, record []byte, replace bool) error {
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+	tree.beginWrite()
+	defer tree.endWrite()
+	root := tree.root
+	if err := tree.insertRec(root, key, record, replace); err != nil {
+		return err
+	}
+	if !tree.overfull(root) {
+		return nil
+	}
+
+	// the content of the overflowing root moves to a new child, which is then split
+	err, child := tree.alloc(root.isLeaf)
+	if err != nil {
+		return err
+	}
+	child.K, root.K = root.K, child.K
+	child.V, root.V = root.V, child.V
+	child.C, root.C = root.C, child.C
+	child.S, root.S = root.S, child.S
+	child.n, root.n = root.n, 0
+	root.isLeaf = false
+	root.C[0] = child.pgno
+	if err = tree.split(root, 0, child); err != nil {
+		return err
+	}
+	tree.height++
+	return tree.nodes.save(root)
+}
+
+// Get returns the record of key
+func (tree *BPlusTree[T]) Get(key T) ([]byte, bool) {
+	tree.mu.RLock()
+	defer tree.mu.RUnlock()
+	cursor := tree.Cursor()
+	if err := cursor.seek(key); err != nil || !cursor.Valid() || tree.compare(cursor.key(), key) != 0 {
+		return nil, false
+	}
+	return cursor.value(), true
+}
+
+func (tree *BPlusTree[T]) Exists(key T) bool {
+	_, ok := tree.Get(key)
+	return ok
+}
+
+// Delete removes key and returns its record
+func (tree *BPlusTree[T]) Delete(key T) (error, []byte) {
+	tree.txLock.enter()
+	defer tree.txLock.leave()
+	return tree.remove(key)
+}
+
+// remove is Delete for a caller that holds the transaction lock
+func (tree *BPlusTree[T]) remove(key T) (error, []byte) {
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+	if tree.root.n == 0 && tree.root.isLeaf {
+		return ErrKeyNotFound, nil
+	}
+	tree.beginWrite()
+	defer tree.endWrite()
+
+	err, record := tree.deleteRec(tree.root, key)
+	if err != nil {
+		return err, nil
+	}
+
+	if tree.root.n == 0 && !tree.root.isLeaf {
+		// the root lost its last separator, its only child moves up into the root page. Page 1 of a file in
+		// the SQLite format has less room than the child, so it may have to stay without separators.
+		err, child := tree.nodes.load(tree.root.C[0])
+		if err != nil {
+			return err, nil
+		}
+		moved := *child
+		moved.pgno = tree.root.pgno
+		if !tree.nodes.fits(&moved) {
+			return nil, record
+		}
+		root := tree.root
+		child.K, root.K = root.K, child.K
+		child.V, root.V = root.V, child.V
+		child.C, root.C = root.C, child.C
+		child.S, root.S = root.S, child.S
+		root.n = child.n
+		root.isLeaf = child.isLeaf
+		if err = tree.nodes.free(child); err != nil {
+			return err, nil
+		}
+		if err = tree.nodes.save(root); err != nil {
+			return err, nil
+		}
+		tree.height--
+	}
+	return nil, record
+}
+
+// beginWrite is called by every change to the tree before it touches a node, see BTree.beginWrite
+func (tree *BPlusTree[T]) beginWrite() {
+	tree.root = tree.versions.startWrite(tree.root)
+}
+
+// endWrite commits a change that does not belong to a transaction
+func (tree *BPlusTree[T]) endWrite() {
+	if !tree.inTx {
+		tree.versions.mu.Lock()
+		tree.versions.publish()
+		tree.versions.mu.Unlock()
+	}
+}
+
+// begin starts a transaction, the caller holds the transaction lock
+func (tree *BPlusTree[T]) begin() error {
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+	if err := tree.nodes.begin(tree.root); err != nil {
+		return err
+	}
+	tree.txHeight = tree.height
+	tree.inTx = true
+	return nil
+}
+
+func (tree *BPlusTree[T]) commit() error {
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+	tree.inTx = false
+	return tree.nodes.commit()
+}
+
+// rollback undoes the transaction and loads the root again, the root page never moves
+func (tree *BPlusTree[T]) rollback() error {
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+	tree.inTx = false
+	if err := tree.nodes.rollback(); err != nil {
+		return err
+	}
+	err, root := tree.nodes.load(tree.root.pgno)
+	if err != nil {
+		return err
+	}
+	tree.root = root
+	tree.height = tree.txHeight
+	return nil
+}
+
+// BPlusCursor walks the records of a BPlusTree in key order, following the links between leaves
+// or, when there are none, going through the root.
+// Like a Cursor it is invalidated by any change to the tree.
+type BPlusCursor[T any] struct {
+	tree *BPlusTree[T]
+	leaf *Node[T]
+	i    int
+}
+
+// Cursor returns a cursor that is not positioned yet
+func (tree *BPlusTree[T]) Cursor() *BPlusCursor[T] {
+	return &BPlusCursor[T]{tree: tree}
+}
+
+func (cursor *BPlusCursor[T]) Valid() bool {
+	return cursor.leaf != nil
+}
+
+// Key returns the key the cursor is positioned on. It must only be called when Valid is true.
+func (cursor *BPlusCursor[T]) Key() T {
+	cursor.tree.mu.RLock()
+	defer cursor.tree.mu.RUnlock()
+	return cursor.key()
+}
+
+func (cursor *BPlusCursor[T]) key() T {
+	return cursor.leaf.K[cursor.i]
+}
+
+// Value returns the record the cursor is positioned on. It must only be called when Valid is true.
+func (cursor *BPlusCursor[T]) Value() []byte {
+	cursor.tree.mu.RLock()
+	defer cursor.tree.mu.RUnlock()
+	return cursor.value()
+}
+
+func (cursor *BPlusCursor[T]) value() []byte {
+	return cursor.leaf.V[cursor.i]
+}
+
+// First positions the cursor on the smallest key
+func (cursor *BPlusCursor[T]) First() error {
+	cursor.tree.mu.RLock()
+	defer cursor.tree.mu.RUnlock()
+	return cursor.descend(func(node *Node[T]) int { return 0 }, false)
+}
+
+// Last positions the cursor on the largest key
+func (cursor *BPlusCursor[T]) Last() error {
+	cursor.tree.mu.RLock()
+	defer cursor.tree.mu.RUnlock()
+	return cursor.descend(func(node *Node[T]) int { return node.n }, true)
+}
+
+// Seek positions the cursor on the smallest key greater than or equal to key
+func (cursor *BPlusCursor[T]) Seek(key T) error {
+	cursor.tree.mu.RLock()
+	defer cursor.tree.mu.RUnlock()
+	return cursor.seek(key)
+}
+
+func (cursor *BPlusCursor[T]) seek(key T) error {
+	err := cursor.descend(func(node *Node[T]) int {
+		i := 0
+		if node.isLeaf {
+			for i < node.n && cursor.tree.compare(node.K[i], key) < 0 {
+				i++
+			}
+		} else {
+			for i < node.n && cursor.tree.compare(node.K[i], key) <= 0 {
+				i++
+			}
+		}
+		return i
+	}, false)
+	if err != nil || !cursor.Valid() || cursor.i < cursor.leaf.n {
+		return err
+	}
+	cursor.i--
+	return cursor.next()
+}
+
+// descend positions the cursor in the leaf reached by following the child choose picks in every node,
+// at the key choose picks in the leaf, or at the last key when last is true
+func (cursor *BPlusCursor[T]) descend(choose func(node *Node[T]) int, last bool) error {
+	cursor.leaf = nil
+	node := cursor.tree.root
+	for !node.isLeaf {
+		err, child := cursor.tree.nodes.load(node.C[choose(node)])
+		if err != nil {
+			return err
+		}
+		node = child
+	}
+	if node.n == 0 {
+		return nil
+	}
+	cursor.leaf = node
+	if last {
+		cursor.i = node.n - 1
+	} else {
+		cursor.i = choose(node)
+	}
+	return nil
+}
+
+// Next moves the cursor to the next key, the cursor becomes invalid after the largest key
+func (cursor *BPlusCursor[T]) Next() error {
+	cursor.tree.mu.RLock()
+	defer cursor.tree.mu.RUnlock()
+	return cursor.next()
+}
+
+func (cursor *BPlusCursor[T]) next() error {
+	if !cursor.Valid() {
+		return nil
+	}
+	cursor.i++
+	if cursor.i < cursor.leaf.n {
+		return nil
+	}
+	return cursor.step(false)
+}
+
+// Prev moves the cursor to the previous key, the cursor becomes invalid before the smallest key
+func (cursor *BPlusCursor[T]) Prev() error {
+	cursor.tree.mu.RLock()
+	defer cursor.tree.mu.RUnlock()
+	if !cursor.Valid() {
+		return nil
+	}
+	cursor.i--
+	if cursor.i >= 0 {
+		return nil
+	}
+	return cursor.step(true)
+}
+
+// step moves the cursor to the first key of the next leaf, or to the last key of the previous one when back is set
+func (cursor *BPlusCursor[T]) step(back bool) error {
+	leaf := cursor.leaf
+	cursor.leaf = nil
+	var err error
+	var sibling *Node[T]
+	switch {
+	case cursor.tree.unlinked:
+		err, sibling = cursor.tree.siblingLeaf(leaf, back)
+	case back && leaf.prev != 0:
+		err, sibling = cursor.tree.nodes.load(leaf.prev)
+	case !back && leaf.next != 0:
+		err, sibling = cursor.tree.nodes.load(leaf.next)
+	}
+	if err != nil || sibling == nil {
+		return err
+	}
+	cursor.leaf = sibling
+	cursor.i = 0
+	if back {
+		cursor.i = sibling.n - 1
+	}
+	return nil
+}
+
+// siblingLeaf returns the leaf after leaf, or the one before it when back is set, nil when there is none.
+// The path from the root to leaf turns the other way for the last time at some node, the sibling is the
+// first, or last, leaf under the child next to the path there.
+func (tree *BPlusTree[T]) siblingLeaf(leaf *Node[T], back bool) (error, *Node[T]) {
+	key := leaf.K[leaf.n-1]
+	if back {
+		key = leaf.K[0]
+	}
+	var turn Pgno
+	for node := tree.root; !node.isLeaf; {
+		i := tree.childIndex(node, key)
+		if !back && i < node.n {
+			turn = node.C[i+1]
+		} else if back && i > 0 {
+			turn = node.C[i-1]
+		}
+		err, child := tree.nodes.load(node.C[i])
+		if err != nil {
+			return err, nil
+		}
+		node = child
+	}
+	if turn == 0 {
+		return nil, nil
+	}
+	for {
+		err, node := tree.nodes.load(turn)
+		if err != nil || node.isLeaf {
+			return err, node
+		}
+		turn = node.C[0]
+		if back {
+			turn = node.C[node.n]
+		}
+	}
+}
+
+// All returns every key with its record in ascending key order. Like the iterators of a BTree, the iterators
+// of a BPlusTree read the tree as it was at the last commit when the loop started, and an error loading a node
+// ends the loop early, Err returns it afterwards.
+func (tree *BPlusTree[T]) All() iter.Seq2[T, []byte] {
+	return tree.records(func(cursor *BPlusCursor[T]) error { return cursor.First() }, nil)
+}
+
+// Range returns the keys greater than or equal to lo and less than hi with their records,
+// in ascending key order
+func (tree *BPlusTree[T]) Range(lo T, hi T) iter.Seq2[T, []byte] {
+	past := func(key T) bool { return tree.compare(key, hi) >= 0 }
+	return tree.records(func(cursor *BPlusCursor[T]) error { return cursor.Seek(lo) }, past)
+}
+
+// Err returns the error that ended the last loop over an iterator of the tree early, see BTree.Err
+func (tree *BPlusTree[T]) Err() error {
+	return tree.iterations.get()
+}
+
+// records yields the keys and records of a snapshot of the tree that lives as long as the loop, from where
+// position leaves a cursor over it, until past says a key is beyond the end
+func (tree *BPlusTree[T]) records(position func(cursor *BPlusCursor[T]) error, past func(key T) bool) iter.Seq2[T, []byte] {
+	return func(yield func(T, []byte) bool) {
+		version := tree.versions.pin()
+		defer tree.versions.unpin(version)
+		err, snapshot := tree.snapshot(version)
+		if err == nil {
+			cursor := snapshot.Cursor()
+			for err = position(cursor); err == nil && cursor.Valid(); err = cursor.Next() {
+				key := cursor.Key()
+				if past != nil && past(key) || !yield(key, cursor.Value()) {
+					break
+				}
+			}
+		}
+		tree.iterations.set(err)
+	}
+}
+
+// snapshot returns a view of the tree at commit version, which reads the old versions of the nodes
+// while the version is pinned
+func (tree *BPlusTree[T]) snapshot(version uint64) (error, *BPlusTree[T]) {
+	nodes := versionNodes[T]{versions: tree.versions, version: version}
+	err, root := nodes.load(tree.versions.root)
+	if err != nil {
+		return err, nil
+	}
+	return nil, &BPlusTree[T]{
+		root:     root,
+		m:        tree.m,
+		nodes:    nodes,
+		versions: tree.versions,
+		compare:  tree.compare,
+		unlinked: tree.unlinked,
+	}
+}
@@ -0,0 +1,341 @@
+package storage
+
+func (tree *BPlusTree[T]) alloc(leaf bool) (error, *Node[T]) {
+	err, node := tree.nodes.alloc(leaf)
+	if err != nil {
+		return err, nil
+	}
+	node.bplus = true
+	return nil, node
+}
+
+// childIndex returns the index of the child of an interior node whose subtree holds key
+func (tree *BPlusTree[T]) childIndex(node *Node[T], key T) int {
+	i := 0
+	for i < node.n && tree.compare(node.K[i], key) <= 0 {
+		i++
+	}
+	return i
+}
+
+// insertRec adds key to the subtree of node, an existing key gets the new record when replace is set
+func (tree *BPlusTree[T]) insertRec(node *Node[T], key T, record []byte, replace bool) error {
+	if node.isLeaf {
+		i := 0
+		for i < node.n && tree.compare(node.K[i], key) < 0 {
+			i++
+		}
+		if i < node.n && tree.compare(node.K[i], key) == 0 {
+			if !replace {
+				return ErrDuplicateKey
+			}
+			// a longer record may no longer fit in the page
+			node.K[i] = key
+			node.V[i] = record
+		} else {
+			node.reserve()
+			for j := node.n; j > i; j-- {
+				node.K[j] = node.K[j-1]
+				node.V[j] = node.V[j-1]
+			}
+			node.K[i] = key
+			node.V[i] = record
+			node.n++
+		}
+	} else {
+		i := tree.childIndex(node, key)
+		err, child := tree.nodes.load(node.C[i])
+		if err != nil {
+			return err
+		}
+		if err = tree.insertRec(child, key, record, replace); err != nil {
+			return err
+		}
+		if !tree.overfull(child) {
+			return nil
+		}
+		if err = tree.split(node, i, child); err != nil {
+			return err
+		}
+	}
+	if tree.overfull(node) {
+		// an overflowing node is saved by the caller once it has been split
+		return nil
+	}
+	return tree.nodes.save(node)
+}
+
+// overfull tells whether node has to be split: it holds m keys or its cells do not fit in its page
+func (tree *BPlusTree[T]) overfull(node *Node[T]) bool {
+	return node.n >= node.m || !tree.nodes.fits(node)
+}
+
+// fitsWith tells whether the cells of content fit in the page of node
+func (tree *BPlusTree[T]) fitsWith(node *Node[T], content nodeContent[T]) bool {
+	trial := &Node[T]{pgno: node.pgno, m: node.m, isLeaf: node.isLeaf, bplus: true}
+	trial.setContent(content)
+	return tree.nodes.fits(trial)
+}
+
+// fitsWithKey tells whether node still fits in its page with one more key and its record
+func (tree *BPlusTree[T]) fitsWithKey(node *Node[T], key T, record []byte) bool {
+	return tree.fitsWith(node, node.content().join(key, record, nodeContent[T]{C: make([]Pgno, 1), S: make([]int, 1)}))
+}
+
+// split splits the overflowing child at index i into as many nodes as it takes for each of them to fit.
+// The caller saves node.
+func (tree *BPlusTree[T]) split(node *Node[T], i int, child *Node[T]) error {
+	for tree.overfull(child) {
+		err, newChild := tree.splitChild(node, i, child)
+		if err != nil {
+			return err
+		}
+		// the keys that stayed in child fit, the rest may have to be split again
+		i, child = i+1, newChild
+	}
+	return tree.nodes.save(child)
+}
+
+// splitPoint returns how many keys the overflowing child keeps when it is split: half of them, or as many
+// as fit in its page
+func (tree *BPlusTree[T]) splitPoint(child *Node[T]) int {
+	mid := min(child.n/2, child.m-1)
+	for mid > 1 && !tree.fitsWith(child, child.content().slice(0, mid)) {
+		mid--
+	}
+	return mid
+}
+
+// splitChild splits the overflowing child at index i and returns the new node right of it. A leaf keeps its
+// first keys and a copy of the first key of the new leaf becomes the separator in node; an interior node is
+// split around a key, which moves up into node. child is saved, the caller saves node and the new node.
+func (tree *BPlusTree[T]) splitChild(node *Node[T], i int, child *Node[T]) (error, *Node[T]) {
+	err, newChild := tree.alloc(child.isLeaf)
+	if err != nil {
+		return err, nil
+	}
+
+	mid := tree.splitPoint(child)
+	content := child.content()
+	var separator T
+	if child.isLeaf {
+		newChild.setContent(content.slice(mid, child.n))
+		separator = newChild.K[0]
+
+		newChild.prev = child.pgno
+		newChild.next = child.next
+		if child.next != 0 {
+			err, next := tree.nodes.load(child.next)
+			if err != nil {
+				return err, nil
+			}
+			next.prev = newChild.pgno
+			if err = tree.nodes.save(next); err != nil {
+				return err, nil
+			}
+		}
+		child.next = newChild.pgno
+	} else {
+		newChild.setContent(content.slice(mid+1, child.n))
+		separator = content.K[mid]
+	}
+	child.setContent(content.slice(0, mid))
+
+	node.reserve()
+	for j := node.n; j > i; j-- {
+		node.K[j] = node.K[j-1]
+		node.C[j+1] = node.C[j]
+	}
+	node.K[i] = separator
+	node.C[i+1] = newChild.pgno
+	node.n++
+
+	return tree.nodes.save(child), newChild
+}
+
+// reserve makes room for one more key in node, which only lacks it when it was read from a page SQLite wrote
+func (node *Node[T]) reserve() {
+	if node.n < len(node.K) {
+		return
+	}
+	node.K = append(node.K, defaultValue[T]())
+	node.V = append(node.V, nil)
+	node.C = append(node.C, 0)
+	node.S = append(node.S, 0)
+}
+
+// deleteRec removes key from the subtree rooted at node and returns its record. Children left with
+// too few keys are fixed on the way back up, node itself is left for its parent to fix.
+// Separators are not updated when the key they were copied from is deleted, they still divide the keys correctly.
+func (tree *BPlusTree[T]) deleteRec(node *Node[T], key T) (error, []byte) {
+	if node.isLeaf {
+		for i := 0; i < node.n; i++ {
+			if tree.compare(node.K[i], key) == 0 {
+				record := node.V[i]
+				node.deleteFromLeaf(i)
+				return tree.nodes.save(node), record
+			}
+		}
+		return ErrKeyNotFound, nil
+	}
+
+	i := tree.childIndex(node, key)
+	err, child := tree.nodes.load(node.C[i])
+	if err != nil {
+		return err, nil
+	}
+	err, record := tree.deleteRec(child, key)
+	if err != nil {
+		return err, nil
+	}
+	if child.n < child.minKeys() {
+		return tree.fixUnderflow(node, i, child), record
+	}
+	return nil, record
+}
+
+// fixUnderflow refills the child at index i, which has one key less than allowed, from a sibling that
+// can spare a key, or else merges the child with a sibling. In the SQLite format the child is left as it is
+// when neither the key nor the sibling fit in its page; it keeps a key then, see SQLiteCodec.go.
+func (tree *BPlusTree[T]) fixUnderflow(node *Node[T], i int, child *Node[T]) error {
+	var left, right *Node[T]
+	var err error
+	if i > 0 {
+		err, left = tree.nodes.load(node.C[i-1])
+		if err != nil {
+			return err
+		}
+		key, record := node.K[i-1], []byte(nil)
+		if child.isLeaf {
+			key, record = left.K[left.n-1], left.V[left.n-1]
+		}
+		if left.n > left.minKeys() && tree.fitsWithKey(child, key, record) {
+			for j := child.n; j > 0; j-- {
+				child.K[j] = child.K[j-1]
+				child.V[j] = child.V[j-1]
+				child.C[j+1] = child.C[j]
+			}
+			child.C[1] = child.C[0]
+			if child.isLeaf {
+				// the last record of the left leaf moves over and becomes the new separator
+				child.K[0] = left.K[left.n-1]
+				child.V[0] = left.V[left.n-1]
+				node.K[i-1] = child.K[0]
+			} else {
+				child.K[0] = node.K[i-1]
+				child.C[0] = left.C[left.n]
+				node.K[i-1] = left.K[left.n-1]
+			}
+			child.n++
+			left.K[left.n-1] = defaultValue[T]()
+			left.V[left.n-1] = nil
+			left.C[left.n] = 0
+			left.n--
+			return tree.saveAll(left, child, node)
+		}
+	}
+	if i < node.n {
+		err, right = tree.nodes.load(node.C[i+1])
+		if err != nil {
+			return err
+		}
+		key, record := node.K[i], []byte(nil)
+		if child.isLeaf {
+			key, record = right.K[0], right.V[0]
+		}
+		if right.n > right.minKeys() && tree.fitsWithKey(child, key, record) {
+			if child.isLeaf {
+				child.K[child.n] = right.K[0]
+				child.V[child.n] = right.V[0]
+				node.K[i] = right.K[1]
+			} else {
+				child.K[child.n] = node.K[i]
+				child.C[child.n+1] = right.C[0]
+				node.K[i] = right.K[0]
+			}
+			child.n++
+			for j := 0; j < right.n-1; j++ {
+				right.K[j] = right.K[j+1]
+				right.V[j] = right.V[j+1]
+				right.C[j] = right.C[j+1]
+			}
+			right.C[right.n-1] = right.C[right.n]
+			right.K[right.n-1] = defaultValue[T]()
+			right.V[right.n-1] = nil
+			right.C[right.n] = 0
+			right.n--
+			return tree.saveAll(right, child, node)
+		}
+	}
+
+	if left != nil && tree.mergeable(node, i-1, left, child) {
+		return tree.mergeChildren(node, i-1, left, child)
+	}
+	if right != nil && tree.mergeable(node, i, child, right) {
+		return tree.mergeChildren(node, i, child, right)
+	}
+	return nil
+}
+
+// mergeable tells whether right, the child at index i+1, can be merged into left
+func (tree *BPlusTree[T]) mergeable(node *Node[T], i int, left *Node[T], right *Node[T]) bool {
+	merged := left.content().join(node.K[i], nil, right.content())
+	if left.isLeaf {
+		merged = merged.cut(left.n, left.n+1)
+	}
+	return len(merged.K) < left.m && tree.fitsWith(left, merged)
+}
+
+// mergeChildren moves everything in right, the child at index i+1, into left and frees right.
+// Merged leaves drop the separator at index i of node, merged interior nodes take it in between.
+func (tree *BPlusTree[T]) mergeChildren(node *Node[T], i int, left *Node[T], right *Node[T]) error {
+	if left.isLeaf {
+		for j := 0; j < right.n; j++ {
+			left.K[left.n] = right.K[j]
+			left.V[left.n] = right.V[j]
+			left.n++
+		}
+		left.next = right.next
+		if right.next != 0 {
+			err, next := tree.nodes.load(right.next)
+			if err != nil {
+				return err
+			}
+			next.prev = left.pgno
+			if err = tree.nodes.save(next); err != nil {
+				return err
+			}
+		}
+	} else {
+		left.K[left.n] = node.K[i]
+		left.n++
+		for j := 0; j < right.n; j++ {
+			left.K[left.n] = right.K[j]
+			left.C[left.n] = right.C[j]
+			left.n++
+		}
+		left.C[left.n] = right.C[right.n]
+	}
+
+	for j := i; j < node.n-1; j++ {
+		node.K[j] = node.K[j+1]
+		node.C[j+1] = node.C[j+2]
+	}
+	node.K[node.n-1] = defaultValue[T]()
+	node.C[node.n] = 0
+	node.n--
+
+	if err := tree.nodes.free(right); err != nil {
+		return err
+	}
+	return tree.saveAll(left, node)
+}
+
+func (tree *BPlusTree[T]) saveAll(nodes ...*Node[T]) error {
+	for _, node := range nodes {
+		if err := tree.nodes.save(node); err != nil {
+			return err
+		}
+	}
+	return nil
+}
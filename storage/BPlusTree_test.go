@@ -0,0 +1,313 @@
+package storage
+
+import (
+	"math/rand"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// checkBPlusTree verifies separators, key counts, leaf depth and the links between leaves,
+// and returns the keys of the leaves in order
+func checkBPlusTree[T any](t *testing.T, tree *BPlusTree[T]) []T {
+	t.Helper()
+	var keys []T
+	var leaves []*Node[T]
+	depth := 0
+	var walk func(node *Node[T], level int, lo, hi *T)
+	walk = func(node *Node[T], level int, lo, hi *T) {
+		if node != tree.root && (node.n < node.minKeys() || node.n > node.m-1) {
+			t.Fatalf("node %d holds %d keys", node.pgno, node.n)
+		}
+		for i := 0; i < node.n; i++ {
+			if lo != nil && tree.compare(node.K[i], *lo) < 0 || hi != nil && tree.compare(node.K[i], *hi) >= 0 {
+				t.Fatalf("key %v of node %d is outside of its separators", node.K[i], node.pgno)
+			}
+		}
+		if node.isLeaf {
+			keys = append(keys, node.K[:node.n]...)
+			leaves = append(leaves, node)
+			if depth == 0 {
+				depth = level
+			} else if depth != level {
+				t.Fatalf("leaf %d is at depth %d, expected %d", node.pgno, level, depth)
+			}
+			return
+		}
+		for i := 0; i <= node.n; i++ {
+			err, child := tree.nodes.load(node.C[i])
+			if err != nil {
+				t.Fatal(err)
+			}
+			childLo, childHi := lo, hi
+			if i > 0 {
+				childLo = &node.K[i-1]
+			}
+			if i < node.n {
+				childHi = &node.K[i]
+			}
+			walk(child, level+1, childLo, childHi)
+		}
+	}
+	walk(tree.root, 1, nil, nil)
+	if depth != tree.height {
+		t.Fatalf("expected height %d, got %d", depth, tree.height)
+	}
+	for i, leaf := range leaves {
+		var prev, next Pgno
+		if i > 0 {
+			prev = leaves[i-1].pgno
+		}
+		if i < len(leaves)-1 {
+			next = leaves[i+1].pgno
+		}
+		if leaf.prev != prev || leaf.next != next {
+			t.Fatalf("leaf %d links to %d and %d, expected %d and %d", leaf.pgno, leaf.prev, leaf.next, prev, next)
+		}
+	}
+	for i := 1; i < len(keys); i++ {
+		if tree.compare(keys[i], keys[i-1]) <= 0 {
+			t.Fatalf("Keys not in sorted order: %v", keys)
+		}
+	}
+	return keys
+}
+
+func TestBPlusTreeRandomPutDelete(t *testing.T) {
+	for _, deg := range []int{3, 4, 5, 8} {
+		_, tree := NewBPlusTree[int](pageSize(deg))
+		present := make(map[int]string)
+		rng := rand.New(rand.NewSource(int64(deg)))
+
+		for i := 0; i < 3000; i++ {
+			key := rng.Intn(300)
+			if record, ok := present[key]; ok && rng.Intn(4) > 0 {
+				err, deleted := tree.Delete(key)
+				if err != nil {
+					t.Fatalf("Unexpected error deleting key %d: %v", key, err)
+				}
+				if string(deleted) != record {
+					t.Errorf("Expected deleted record %q, got %q", record, deleted)
+				}
+				delete(present, key)
+			} else {
+				record := strconv.Itoa(i)
+				if err := tree.Put(key, []byte(record)); err != nil {
+					t.Fatalf("Unexpected error putting key %d: %v", key, err)
+				}
+				present[key] = record
+			}
+			if i%50 == 0 {
+				if keys := checkBPlusTree(t, tree); len(keys) != len(present) {
+					t.Fatalf("Expected %d keys, got %d", len(present), len(keys))
+				}
+			}
+		}
+		for key, record := range present {
+			if got, ok := tree.Get(key); !ok || string(got) != record {
+				t.Errorf("Expected record %q for key %d, got %q", record, key, got)
+			}
+		}
+		if err, _ := tree.Delete(1000); err != ErrKeyNotFound {
+			t.Errorf("Expected ErrKeyNotFound, got %v", err)
+		}
+	}
+}
+
+func TestBPlusTreeInteriorNodesHoldNoRecords(t *testing.T) {
+	_, tree := NewBPlusTree[int](pageSize(3))
+	for i := 0; i < 100; i++ {
+		tree.Put(i, []byte{byte(i)})
+	}
+	if tree.root.isLeaf {
+		t.Fatal("Expected the root to be an interior node")
+	}
+	for i := 0; i < tree.root.n; i++ {
+		if tree.root.V[i] != nil {
+			t.Errorf("Expected no record for separator %d", tree.root.K[i])
+		}
+	}
+	// every key is found in a leaf, including the ones copied into separators
+	if keys := checkBPlusTree(t, tree); len(keys) != 100 {
+		t.Errorf("Expected 100 keys in the leaves, got %d", len(keys))
+	}
+}
+
+func TestBPlusCursor(t *testing.T) {
+	_, tree := NewBPlusTree[int](pageSize(4))
+	cursor := tree.Cursor()
+	if cursor.First(); cursor.Valid() {
+		t.Error("Expected invalid cursor on empty tree")
+	}
+
+	for i := 0; i < 200; i += 2 {
+		tree.Put(i, []byte(strconv.Itoa(i)))
+	}
+
+	expected := 0
+	for cursor.First(); cursor.Valid(); cursor.Next() {
+		if cursor.Key() != expected || string(cursor.Value()) != strconv.Itoa(expected) {
+			t.Fatalf("Expected key %d, got %d", expected, cursor.Key())
+		}
+		expected += 2
+	}
+	if expected != 200 {
+		t.Errorf("Forward scan stopped at %d", expected)
+	}
+
+	expected = 198
+	for cursor.Last(); cursor.Valid(); cursor.Prev() {
+		if cursor.Key() != expected {
+			t.Fatalf("Expected key %d, got %d", expected, cursor.Key())
+		}
+		expected -= 2
+	}
+	if expected != -2 {
+		t.Errorf("Backward scan stopped at %d", expected)
+	}
+
+	for key := -1; key < 200; key++ {
+		cursor.Seek(key)
+		want := key + 1 - (key+1)%2
+		if key < 0 {
+			want = 0
+		} else if key%2 == 0 {
+			want = key
+		}
+		if want >= 200 {
+			if cursor.Valid() {
+				t.Errorf("Expected invalid cursor after seeking %d, got %d", key, cursor.Key())
+			}
+		} else if !cursor.Valid() || cursor.Key() != want {
+			t.Errorf("Expected seek to %d to find %d", key, want)
+		}
+	}
+}
+
+func TestBPlusTreeIterators(t *testing.T) {
+	_, tree := NewBPlusTree[int](pageSize(3))
+	for i := 0; i < 100; i++ {
+		tree.Put(i, []byte{byte(i)})
+	}
+
+	count := 0
+	for key, record := range tree.All() {
+		if key != count || record[0] != byte(count) {
+			t.Fatalf("Expected key %d, got %d", count, key)
+		}
+		count++
+	}
+	if count != 100 {
+		t.Errorf("Expected 100 records, got %d", count)
+	}
+
+	var keys []int
+	for key := range tree.Range(25, 40) {
+		keys = append(keys, key)
+	}
+	if len(keys) != 15 || keys[0] != 25 || keys[14] != 39 {
+		t.Errorf("Unexpected range %v", keys)
+	}
+	for key := range tree.Range(50, 60) {
+		if key == 52 {
+			break
+		}
+	}
+}
+
+func TestBPlusTreeIteratorsWhileChanging(t *testing.T) {
+	_, tree := NewBPlusTree[int](pageSize(3))
+	for i := 0; i < 300; i++ {
+		tree.Put(i, []byte{byte(i)})
+	}
+	// the loop goes on over the records as they were when it started
+	count := 0
+	for key, record := range tree.All() {
+		if key != count || record[0] != byte(count) {
+			t.Fatalf("Expected key %d, got %d", count, key)
+		}
+		tree.Delete(key)
+		tree.Put(key+1000, nil)
+		count++
+	}
+	if count != 300 || tree.Err() != nil {
+		t.Errorf("Expected the 300 records the loop started with, got %d (%v)", count, tree.Err())
+	}
+	if _, ok := tree.Get(0); ok || len(tree.versions.old) != 0 {
+		t.Errorf("Expected the changes of the loop and no old versions kept, %d are kept", len(tree.versions.old))
+	}
+
+	_, empty := NewBPlusTree[int](pageSize(3))
+	if err, _ := empty.Delete(1); err != ErrKeyNotFound {
+		t.Errorf("Expected ErrKeyNotFound deleting from an empty tree, got %v", err)
+	}
+}
+
+func TestBPlusTreeIteratorsReportErrors(t *testing.T) {
+	_, pager := OpenPager(filepath.Join(t.TempDir(), "bplus.db"), 512)
+	defer pager.Close()
+	_, tree := OpenBPlusTree[int](pager, 0)
+	for i := 0; i < 500; i++ {
+		tree.Put(i, []byte{byte(i)})
+	}
+	// a leaf that does not hold a node anymore, the scan reaches it over the link of the first leaf
+	cursor := tree.Cursor()
+	cursor.First()
+	garbage := make([]byte, 512)
+	garbage[0] = 0xff
+	pager.Write(cursor.leaf.next, garbage)
+
+	count := 0
+	for range tree.All() {
+		count++
+	}
+	if tree.Err() == nil || count == 0 || count >= 500 {
+		t.Errorf("Expected an error after some records, got %v after %d records", tree.Err(), count)
+	}
+	for range tree.Range(0, 5) {
+	}
+	if err := tree.Err(); err != nil {
+		t.Errorf("Expected a loop that ran to the end to clear the error, got %v", err)
+	}
+}
+
+func TestBPlusTreeOnPager(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bplus.db")
+	_, pager := OpenPager(path, 512)
+	_, tree := OpenBPlusTree[string](pager, 0)
+	for i := 0; i < 500; i++ {
+		if err := tree.Put("key"+strconv.Itoa(i), []byte("record"+strconv.Itoa(i))); err != nil {
+			t.Fatalf("Unexpected error putting key %d: %v", i, err)
+		}
+	}
+	for i := 0; i < 500; i += 3 {
+		if err, _ := tree.Delete("key" + strconv.Itoa(i)); err != nil {
+			t.Fatalf("Unexpected error deleting key %d: %v", i, err)
+		}
+	}
+	root := tree.Root()
+	pager.Close()
+
+	_, pager = OpenPager(path, 512)
+	defer pager.Close()
+	err, tree := OpenBPlusTree[string](pager, root)
+	if err != nil {
+		t.Fatalf("Unexpected error opening tree: %v", err)
+	}
+	checkBPlusTree(t, tree)
+	for i := 0; i < 500; i++ {
+		record, ok := tree.Get("key" + strconv.Itoa(i))
+		if ok != (i%3 != 0) || ok && string(record) != "record"+strconv.Itoa(i) {
+			t.Errorf("Unexpected record %q, %v for key %d after reopening", record, ok, i)
+		}
+	}
+
+	// a page holds either kind of tree, opening it as the other kind fails
+	if err, _ := OpenBTree[string](pager, root); err == nil {
+		t.Error("Expected error opening a BPlusTree root as a BTree")
+	}
+	_, btree := OpenBTree[string](pager, 0)
+	if err, _ := OpenBPlusTree[string](pager, btree.Root()); err == nil {
+		t.Error("Expected error opening a BTree root as a BPlusTree")
+	}
+}
@@ -1,14 +1,18 @@
 package storage
 
 import (
+	"cmp"
 	"errors"
 	"golang.org/x/exp/constraints"
+	"sync"
 )
 
 /*
 “We define the order (or minimum degree) m of a B-tree as the maximum number of children any node can have.
 Each node contains between m - 1 and 2*m - 1 keys.”
 
+Here a node holds at most m - 1 keys, and every node other than the root at least ceil(m/2) - 1 keys.
+
 For each node:
 TODO: check if 8 bytes is really needed considering all datatypes
 Needs up to 2*m-1 keys (8 bytes each)
@@ -20,47 +24,182 @@ Plus some fixed metadata (say 16 bytes)
 Total max size: 8*(2*m - 1) + 8*(2*m) + 16 = 16*m - 8 + 16*m + 16 = 32m + 8
 
 So, order m of BTree can be derived from equation: 32m + 8 <= page size of disk
+
+A BTree is safe for concurrent use. Every exported method holds a readers-writer lock on the whole tree:
+lookups share it, changes take it alone. Locking node by node on the way down (latch crabbing) does not fit
+this tree, since Insert and Delete split and merge on the way back up, so a writer needs the whole path
+until it returns, and the root page takes part in every split of the root.
+
+The actual layout of a node in its page is described in NodeCodec.go. Keys and payloads that do not fit
+in this budget spill to overflow pages, so keys of any length can be stored.
 */
 
-type BTree[T constraints.Ordered] struct {
-	root   *Node[T]
-	m      int
-	height int
+var ErrKeyNotFound = errors.New("key does not exist in btree")
+var ErrDuplicateKey = errors.New("key already exists in btree")
+
+// DuplicateMode decides what Insert does with a key that is already in the tree
+type DuplicateMode int
+
+const (
+	// DuplicatesMultiset keeps every copy. Copies of a key are ordered by insertion,
+	// lookups and Delete find the oldest one.
+	DuplicatesMultiset DuplicateMode = iota
+	// DuplicatesUnique rejects the key with ErrDuplicateKey
+	DuplicatesUnique
+	// DuplicatesReplace replaces the stored key and its payload
+	DuplicatesReplace
+)
+
+type BTree[T any] struct {
+	root       *Node[T] // the root node never moves to another page
+	m          int
+	height     int
+	nodes      nodeStore[T]
+	versions   *versionedNodes[T] // the same store as nodes
+	compare    func(a, b T) int   // negative when a < b, zero when a == b, positive when a > b
+	duplicates DuplicateMode
+	tx         *Tx[T]  // open transaction, nil when there is none
+	txLock     *txLock // of the tree in memory, of the pager on a Pager, see Tx.go
+	iterations iterationErr
+	mu         sync.RWMutex
 }
 
+// NewBTree creates an in-memory tree in DuplicatesMultiset mode
 func NewBTree[T constraints.Ordered](pageSize int) (error, *BTree[T]) {
+	return NewBTreeFunc[T](pageSize, cmp.Compare[T], DuplicatesMultiset)
+}
+
+// NewBTreeFunc creates an in-memory tree that orders its keys with compare
+func NewBTreeFunc[T any](pageSize int, compare func(a, b T) int, duplicates DuplicateMode) (error, *BTree[T]) {
 	m := (pageSize - 8) / 32
 
-	// TODO: check if below check is needed
-	//if m < 2 {
-	//	return errors.New("minimum degree must be greater than 2"), nil
-	//}
-
-	return nil, &BTree[T]{
-		root:   nil,
-		m:      m,
-		height: 0,
-	}
-}
-
-func (btree *BTree[T]) Insert(key T) {
-	if btree.root == nil {
-		btree.root = newNode[T](btree.m, true)
-		btree.root.K[0] = key
-		btree.root.n = 1
-	} else if btree.root.n == 2*btree.root.m-1 {
-		oldRoot := btree.root
-		btree.root = newNode[T](btree.m, false)
-		btree.root.C[0] = oldRoot
-		btree.root.splitChild(0, btree.root.C[0])
-		i := 0
-		if btree.root.K[0] < key {
-			i++
+	if m < 3 {
+		return errors.New("page size is too small: order of btree must be at least 3"), nil
+	}
+
+	btree := &BTree[T]{
+		m:          m,
+		height:     1,
+		versions:   newVersionedNodes[T](newMemNodes[T](m)),
+		compare:    compare,
+		duplicates: duplicates,
+		txLock:     newTxLock(),
+	}
+	btree.nodes = btree.versions
+	_, btree.root = btree.nodes.alloc(true)
+	btree.versions.root = btree.root.pgno
+	return nil, btree
+}
+
+// OpenBTree opens the tree whose root node is stored in page root of pager.
+// A root of 0 creates a new, empty tree; its root page number never changes, so it can be recorded once.
+// The tree is in DuplicatesMultiset mode.
+func OpenBTree[T constraints.Ordered](pager *Pager, root Pgno) (error, *BTree[T]) {
+	return OpenBTreeFunc[T](pager, root, cmp.Compare[T], DuplicatesMultiset)
+}
+
+// OpenBTreeFunc is OpenBTree for a tree that orders its keys with compare.
+// Neither the order nor the duplicate mode is stored in the file, a tree has to be opened with the ones
+// it was created with.
+func OpenBTreeFunc[T any](pager *Pager, root Pgno, compare func(a, b T) int, duplicates DuplicateMode) (error, *BTree[T]) {
+	if keyType[T]() == 0 {
+		return errors.New("keys of this type cannot be stored in a page"), nil
+	}
+	if pager.sqlite {
+		return errors.New("a file in the SQLite format only holds BPlusTrees"), nil
+	}
+	m := (pager.PageSize() - 8) / 32
+	btree := &BTree[T]{
+		m:          m,
+		height:     1,
+		versions:   newVersionedNodes[T](&pagerNodes[T]{m: m, pager: pager}),
+		compare:    compare,
+		duplicates: duplicates,
+		txLock:     pager.txLock,
+	}
+	btree.nodes = btree.versions
+
+	var err error
+	if root == 0 {
+		err, btree.root = btree.nodes.alloc(true)
+		if err == nil {
+			err = btree.nodes.save(btree.root)
 		}
-		btree.root.C[i].insertNonFull(key)
-	} else {
-		btree.root.insertNonFull(key)
+		if err != nil {
+			return err, nil
+		}
+		btree.versions.root = btree.root.pgno
+		return nil, btree
+	}
+
+	err, btree.root = btree.nodes.load(root)
+	if err != nil {
+		return err, nil
+	}
+	btree.versions.root = root
+	if btree.root.bplus {
+		return errors.New("page does not hold the root of a BTree"), nil
+	}
+	for node := btree.root; !node.isLeaf; btree.height++ {
+		err, node = btree.nodes.load(node.C[0])
+		if err != nil {
+			return err, nil
+		}
+	}
+	return nil, btree
+}
+
+// Root returns the page number of the root node
+func (btree *BTree[T]) Root() Pgno {
+	btree.mu.RLock()
+	defer btree.mu.RUnlock()
+	return btree.root.pgno
+}
+
+func (btree *BTree[T]) Insert(key T) error {
+	return btree.write(nil, func() error { return btree.add(key) })
+}
+
+// add inserts key the way the duplicate mode of the tree says
+func (btree *BTree[T]) add(key T) error {
+	switch btree.duplicates {
+	case DuplicatesUnique:
+		if btree.exists(key) {
+			return ErrDuplicateKey
+		}
+	case DuplicatesReplace:
+		return btree.put(key, nil)
 	}
+	return btree.insert(key, nil)
+}
+
+// insert adds key with its payload, the tree grows by one level when the root overflows
+func (btree *BTree[T]) insert(key T, value []byte) error {
+	root := btree.root
+	if err := btree.insertNonFull(root, key, value); err != nil {
+		return err
+	}
+	if root.n < root.m {
+		return nil
+	}
+
+	// the content of the overflowing root moves to a new child, which is then split
+	err, child := btree.nodes.alloc(root.isLeaf)
+	if err != nil {
+		return err
+	}
+	child.K, root.K = root.K, child.K
+	child.V, root.V = root.V, child.V
+	child.C, root.C = root.C, child.C
+	child.S, root.S = root.S, child.S
+	child.n, root.n = root.n, 0
+	root.isLeaf = false
+	root.C[0] = child.pgno
+	if err = btree.splitChild(root, 0, child); err != nil {
+		return err
+	}
+	btree.height++
+	return btree.nodes.save(root)
 }
 
 func (btree *BTree[T]) search(key T) (error, *Node[T], int) {
@@ -68,7 +207,7 @@ func (btree *BTree[T]) search(key T) (error, *Node[T], int) {
 		//defaultVal := defaultValue[T]()
 		return errors.New("the btree is empty"), nil, 0
 	}
-	err, node, i := btree.root.searchRec(key)
+	err, node, i := btree.searchRec(btree.root, key)
 	if err != nil {
 		return err, nil, 0
 	} else {
@@ -77,6 +216,12 @@ func (btree *BTree[T]) search(key T) (error, *Node[T], int) {
 }
 
 func (btree *BTree[T]) Exists(key T) bool {
+	btree.mu.RLock()
+	defer btree.mu.RUnlock()
+	return btree.reader(nil).exists(key)
+}
+
+func (btree *BTree[T]) exists(key T) bool {
 	if btree.root.n == 0 && btree.root.isLeaf {
 		return false
 	}
@@ -88,68 +233,114 @@ func (btree *BTree[T]) Exists(key T) bool {
 	}
 }
 
-func (btree *BTree[T]) Delete(key T) (error, bool) {
+// put replaces key and its payload, or inserts key when it does not exist yet
+func (btree *BTree[T]) put(key T, value []byte) error {
 	if btree.root.n == 0 && btree.root.isLeaf {
-		return errors.New("btree is empty"), false
+		return btree.insert(key, value)
+	}
+	err, node, i := btree.search(key)
+	if err == ErrKeyNotFound {
+		return btree.insert(key, value)
+	} else if err != nil {
+		return err
 	}
-	//else if btree.root.n == 0 && !btree.root.isLeaf {
-	//	btree.root = btree.root.C[0]
-	//}
+	node.K[i] = key
+	node.V[i] = value
+	return btree.nodes.save(node)
+}
 
-	err, foundNode, j, _ := btree.root.deleteRec(key)
+// get returns the payload of key
+func (btree *BTree[T]) get(key T) (error, []byte) {
+	err, node, i := btree.search(key)
 	if err != nil {
-		return err, false
-	}
-
-	if foundNode == btree.root {
-		firstChild := btree.root.C[0]
-		secondChild := btree.root.C[1]
-		if btree.root.isLeaf {
-			btree.root.deleteFromLeaf(j)
-		} else if !btree.root.isLeaf && btree.root.n == 1 {
-			if secondChild == nil {
-				btree.root = firstChild
-			} else {
-				nInit := firstChild.n - 1
-				for k := 0; k < secondChild.n; k++ {
-					firstChild.K[nInit+k] = secondChild.K[k]
-				}
-				btree.root = firstChild
-			}
-		} else {
-			var searchIn, P, C *Node[T]
-			var val T
-			var atIndex int
-			if btree.root.C[j] != nil {
-				atIndex = btree.root.C[j].n - 1
-				searchIn = btree.root.C[j]
-			} else if btree.root.C[j+1] != nil {
-				atIndex = 0
-				searchIn = btree.root.C[j+1]
-			}
-			P, C, val = btree.root.findKeyInSubtreeRec(searchIn, atIndex)
-			btree.root.K[j] = val
-			if C.n > C.m-1 {
-				C.deleteFromLeaf(atIndex)
-			} else {
-				P.deleteFromLeafWithTMinus1Keys(atIndex, C, atIndex)
+		return err, nil
+	}
+	return nil, node.V[i]
+}
+
+// Count returns the number of copies of key
+func (btree *BTree[T]) Count(key T) (error, int) {
+	btree.mu.RLock()
+	defer btree.mu.RUnlock()
+	return countKey(btree.reader(nil).Cursor(), key)
+}
+
+// DeleteAll removes every copy of key and returns how many there were
+func (btree *BTree[T]) DeleteAll(key T) (error, int) {
+	count := 0
+	err := btree.write(nil, func() error {
+		for btree.root.n > 0 {
+			err, _ := btree.remove(key)
+			if err == ErrKeyNotFound {
+				break
+			} else if err != nil {
+				return err
 			}
+			count++
+		}
+		return nil
+	})
+	return err, count
+}
+
+func (btree *BTree[T]) Delete(key T) (error, bool) {
+	err := btree.write(nil, func() error {
+		err, _ := btree.remove(key)
+		return err
+	})
+	return err, err == nil
+}
+
+// remove deletes key and returns its payload
+func (btree *BTree[T]) remove(key T) (error, []byte) {
+	if btree.root.n == 0 && btree.root.isLeaf {
+		return errors.New("btree is empty"), nil
+	}
+
+	err, value := btree.deleteRec(btree.root, key)
+	if err != nil {
+		return err, nil
+	}
+
+	if btree.root.n == 0 && !btree.root.isLeaf {
+		// the root lost its last key, its only child moves up into the root page
+		err, child := btree.nodes.load(btree.root.C[0])
+		if err != nil {
+			return err, nil
 		}
+		root := btree.root
+		child.K, root.K = root.K, child.K
+		child.V, root.V = root.V, child.V
+		child.C, root.C = root.C, child.C
+		child.S, root.S = root.S, child.S
+		root.n = child.n
+		root.isLeaf = child.isLeaf
+		if err = btree.nodes.free(child); err != nil {
+			return err, nil
+		}
+		if err = btree.nodes.save(root); err != nil {
+			return err, nil
+		}
+		btree.height--
 	}
-	return nil, true
+	return nil, value
 }
 
 func (btree *BTree[T]) traverse() (error, []T) {
 	if btree.root.n == 0 && btree.root.isLeaf {
 		return errors.New("the btree is empty"), nil
 	}
-	var keys []T
-	btree.root.traverseRec(keys)
+	err, keys := btree.traverseRec(btree.root, nil)
+	if err != nil {
+		return err, nil
+	}
 	return nil, keys
 }
 
 func (btree *BTree[T]) Print() {
-	err, keys := btree.traverse()
+	btree.mu.RLock()
+	defer btree.mu.RUnlock()
+	err, keys := btree.reader(nil).traverse()
 	if err == nil {
 		println(err)
 	} else {
@@ -1,423 +1,497 @@
 package storage
 
-import (
-	"errors"
-	"golang.org/x/exp/constraints"
-	"reflect"
-)
+import "slices"
 
-//func defaultValue[T any]() T {
-//	var defaultVal T
-//	return defaultVal
-//}
+func defaultValue[T any]() T {
+	var defaultVal T
+	return defaultVal
+}
 
 // TODO: check if m is needed here when it already exists in btree
-type Node[T constraints.Ordered] struct {
-	m      int        // order of BTree Node
-	n      int        // Current number of keys
-	K      []T        // A slice of keys
-	C      []*Node[T] // A slice of child pointers
-	isLeaf bool       // Is true when node is isLeaf. Otherwise, false
+type Node[T any] struct {
+	pgno   Pgno     // page the node is stored in
+	m      int      // order of BTree Node
+	n      int      // Current number of keys
+	K      []T      // A slice of keys
+	V      [][]byte // A slice of payloads, V[i] belongs to K[i]
+	C      []Pgno   // A slice of child page numbers
+	S      []int    // S[i] is the number of keys in the subtree of C[i], interior BTree nodes only
+	isLeaf bool     // Is true when node is isLeaf. Otherwise, false
+
+	bplus bool // node of a BPlusTree
+	prev  Pgno // leaf before this one in a BPlusTree
+	next  Pgno // leaf after this one in a BPlusTree
+
+	overflow []Pgno // overflow pages of the cells as they were last loaded or saved
 }
 
-func newNode[T constraints.Ordered](order int, leaf bool) *Node[T] {
+// newNode makes room for one key and child more than the order allows,
+// so a node can overflow during an insert until its parent splits it.
+func newNode[T any](pgno Pgno, order int, leaf bool) *Node[T] {
 	return &Node[T]{
+		pgno:   pgno,
 		m:      order,
-		K:      make([]T, order-1),
+		K:      make([]T, order),
+		V:      make([][]byte, order),
 		n:      0,
-		C:      make([]*Node[T], order),
+		C:      make([]Pgno, order+1),
+		S:      make([]int, order+1),
 		isLeaf: leaf,
 	}
 }
 
-func (node *Node[T]) insertNonFull(key T) {
+// minKeys is the least number of keys a node other than the root may hold
+func (node *Node[T]) minKeys() int {
+	return (node.m+1)/2 - 1
+}
+
+// size returns the number of keys in the subtree of node
+func (node *Node[T]) size() int {
+	size := node.n
+	if !node.isLeaf {
+		for i := 0; i <= node.n; i++ {
+			size += node.S[i]
+		}
+	}
+	return size
+}
+
+// clone returns a copy of node that does not share its slices
+func (node *Node[T]) clone() *Node[T] {
+	copied := *node
+	copied.K = append([]T(nil), node.K...)
+	copied.V = append([][]byte(nil), node.V...)
+	copied.C = append([]Pgno(nil), node.C...)
+	copied.S = append([]int(nil), node.S...)
+	copied.overflow = append([]Pgno(nil), node.overflow...)
+	return &copied
+}
+
+// nodeContent is what a node holds: n keys with their payloads and n + 1 children with the sizes of their
+// subtrees, which are zero in a leaf. Unlike a node it may hold any number of keys.
+type nodeContent[T any] struct {
+	K []T
+	V [][]byte
+	C []Pgno
+	S []int
+}
+
+// content returns a copy of what node holds
+func (node *Node[T]) content() nodeContent[T] {
+	return nodeContent[T]{
+		K: append([]T(nil), node.K[:node.n]...),
+		V: append([][]byte(nil), node.V[:node.n]...),
+		C: append([]Pgno(nil), node.C[:node.n+1]...),
+		S: append([]int(nil), node.S[:node.n+1]...),
+	}
+}
+
+// setContent replaces what node holds. The node grows beyond its order when content holds more keys,
+// such a node has to be split before it is saved.
+func (node *Node[T]) setContent(content nodeContent[T]) {
+	size := max(node.m, len(content.K))
+	node.K = make([]T, size)
+	node.V = make([][]byte, size)
+	node.C = make([]Pgno, size+1)
+	node.S = make([]int, size+1)
+	copy(node.K, content.K)
+	copy(node.V, content.V)
+	copy(node.C, content.C)
+	copy(node.S, content.S)
+	node.n = len(content.K)
+}
+
+// join returns left, then key with its payload, then right
+func (left nodeContent[T]) join(key T, value []byte, right nodeContent[T]) nodeContent[T] {
+	return nodeContent[T]{
+		K: slices.Concat(left.K, []T{key}, right.K),
+		V: slices.Concat(left.V, [][]byte{value}, right.V),
+		C: slices.Concat(left.C, right.C),
+		S: slices.Concat(left.S, right.S),
+	}
+}
+
+// slice returns the keys from i to j and the children from i to j + 1
+func (content nodeContent[T]) slice(i int, j int) nodeContent[T] {
+	return nodeContent[T]{K: content.K[i:j], V: content.V[i:j], C: content.C[i : j+1], S: content.S[i : j+1]}
+}
+
+// cut returns content without the keys from i to j and the children from i + 1 to j + 1
+func (content nodeContent[T]) cut(i int, j int) nodeContent[T] {
+	return nodeContent[T]{
+		K: slices.Concat(content.K[:i], content.K[j:]),
+		V: slices.Concat(content.V[:i], content.V[j:]),
+		C: slices.Concat(content.C[:i+1], content.C[j+1:]),
+		S: slices.Concat(content.S[:i+1], content.S[j+1:]),
+	}
+}
+
+func (btree *BTree[T]) insertNonFull(node *Node[T], key T, value []byte) error {
 	i := node.n - 1
 	if node.isLeaf {
-		for i >= 0 && node.K[i] > key {
+		for i >= 0 && btree.compare(node.K[i], key) > 0 {
 			node.K[i+1] = node.K[i]
+			node.V[i+1] = node.V[i]
 			i--
 		}
 		node.K[i+1] = key
+		node.V[i+1] = value
 		node.n++
 	} else {
-		for i >= 0 && node.K[i] > key {
+		for i >= 0 && btree.compare(node.K[i], key) > 0 {
 			i--
 		}
-		if node.C[i+1].n == node.m-1 {
-			node.splitChild(i+1, node.C[i+1])
-
-			if node.K[i+1] < key {
-				i++
+		err, child := btree.nodes.load(node.C[i+1])
+		if err != nil {
+			return err
+		}
+		if err = btree.insertNonFull(child, key, value); err != nil {
+			return err
+		}
+		node.S[i+1]++
+		if child.n == child.m {
+			if err = btree.splitChild(node, i+1, child); err != nil {
+				return err
 			}
 		}
-		node.C[i+1].insertNonFull(key)
 	}
+	if node.n == node.m {
+		// an overflowing node is saved by the caller once it has been split
+		return nil
+	}
+	return btree.nodes.save(node)
 }
 
-// TODO: check if two params are needed
-func (node *Node[T]) splitChild(i int, child *Node[T]) {
-	newChild := newNode[T](child.m, child.isLeaf)
-	// TODO: update value of n as key in each node is added or deleted to make debugging easier
-	//newChild.n = child.m - 1
+// splitChild splits the overflowing child at index i around its median key, which moves up into node.
+// The caller saves node.
+func (btree *BTree[T]) splitChild(node *Node[T], i int, child *Node[T]) error {
+	err, newChild := btree.nodes.alloc(child.isLeaf)
+	if err != nil {
+		return err
+	}
 
-	for j := 0; j < child.m-1; j++ {
-		// move keys from second half of first child to new child
-		newChild.K[j] = child.K[j+child.m]
+	// move keys and child pointers from second half of child to new child
+	mid := child.n / 2
+	for j := mid + 1; j < child.n; j++ {
+		newChild.K[newChild.n] = child.K[j]
+		newChild.V[newChild.n] = child.V[j]
+		newChild.C[newChild.n] = child.C[j]
+		newChild.S[newChild.n] = child.S[j]
 		newChild.n++
-		child.n--
-
-		// move child pointers from second half of first child to new child
-		newChild.C[j] = child.C[j+child.m]
-		child.C[j+child.m] = nil
+		child.K[j] = defaultValue[T]()
+		child.V[j] = nil
+		child.C[j] = 0
+		child.S[j] = 0
 	}
-
 	// for last child pointer not encountered in above loop
-	newChild.C[child.m-1] = child.C[2*child.m-1]
-	child.C[2*child.m-1] = nil
-
-	//if !child.isLeaf {
-	//	for j := 0; j < child.m; j++ {
-	//		newChild.C[j] = child.C[j+child.m]
-	//		child.C[j+child.m] = nil
-	//	}
-	//}
-
-	// moving forward child pointers after current child index by 1 index
-	j := node.n
-	for j > i {
+	newChild.C[newChild.n] = child.C[child.n]
+	newChild.S[newChild.n] = child.S[child.n]
+	child.C[child.n] = 0
+	child.S[child.n] = 0
+
+	// moving forward keys and child pointers after index i by 1 index
+	for j := node.n; j > i; j-- {
+		node.K[j] = node.K[j-1]
+		node.V[j] = node.V[j-1]
 		node.C[j+1] = node.C[j]
-		j--
+		node.S[j+1] = node.S[j]
 	}
-	// adding address of new child on index next to current child
-	node.C[i+1] = newChild
+	node.K[i] = child.K[mid]
+	node.V[i] = child.V[mid]
+	node.C[i+1] = newChild.pgno
+	node.n++
 
-	// moving forward keys after current child/key index by 1 index
-	j = node.n - 1
-	for j >= i {
-		node.K[j+1] = node.K[j]
-		j--
+	child.K[mid] = defaultValue[T]()
+	child.V[mid] = nil
+	child.n = mid
+	node.S[i] = child.size()
+	node.S[i+1] = newChild.size()
+
+	if err = btree.nodes.save(child); err != nil {
+		return err
 	}
-	// adding new key on index next to current child/key
-	node.K[i] = child.K[child.m-1]
-	node.n++
-	child.n--
-
-	//nInit := child.n
-	//child.n = child.m - 1
-
-	// check if casting is needed for explicitly defined ordered types
-	//for k := child.n; k < nInit; k++ {
-	//	keyDataType := reflect.TypeOf(child.K[k])
-	//	if keyDataType == reflect.TypeOf(0) {
-	//		child.K[k] = any(0).(T) // Cast 0 to type T
-	//	} else if keyDataType == reflect.TypeOf("") {
-	//		child.K[k] = any("").(T) // Cast "" to type T
-	//	}
-	//}
+	return btree.nodes.save(newChild)
 }
 
-func (node *Node[T]) traverseRec(keys []T) {
+func (btree *BTree[T]) traverseRec(node *Node[T], keys []T) (error, []T) {
 	for i := 0; i < node.n; i++ {
 		if !node.isLeaf {
-			node.C[i].traverseRec(keys)
+			err, child := btree.nodes.load(node.C[i])
+			if err != nil {
+				return err, keys
+			}
+			if err, keys = btree.traverseRec(child, keys); err != nil {
+				return err, keys
+			}
 		}
 		keys = append(keys, node.K[i])
 	}
 	if !node.isLeaf {
-		node.C[node.n].traverseRec(keys)
+		err, child := btree.nodes.load(node.C[node.n])
+		if err != nil {
+			return err, keys
+		}
+		return btree.traverseRec(child, keys)
 	}
+	return nil, keys
 }
 
-func (node *Node[T]) searchRec(key T) (error, *Node[T], int) {
+func (btree *BTree[T]) searchRec(node *Node[T], key T) (error, *Node[T], int) {
 	i := 0
 	for i < node.n {
-		if key > node.K[i] {
+		if c := btree.compare(key, node.K[i]); c > 0 {
 			i++
 			continue
-		} else if key == node.K[i] {
+		} else if c == 0 && (node.isLeaf || btree.duplicates != DuplicatesMultiset) {
+			return nil, node, i
+		} else if c == 0 {
+			// older copies of the key can be in the left subtree
+			err, child := btree.nodes.load(node.C[i])
+			if err != nil {
+				return err, nil, -1
+			}
+			if err, found, j := btree.searchRec(child, key); err != ErrKeyNotFound {
+				return err, found, j
+			}
 			return nil, node, i
 		} else {
 			break
 		}
 	}
 	if node.isLeaf {
-		return errors.New("key does not exist in btree"), nil, -1
-	} else {
-		return node.C[i].searchRec(key)
+		return ErrKeyNotFound, nil, -1
+	}
+	err, child := btree.nodes.load(node.C[i])
+	if err != nil {
+		return err, nil, -1
 	}
+	return btree.searchRec(child, key)
 }
 
-func (node *Node[T]) deleteRec(key T) (error, *Node[T], int, bool) {
+// deleteRec removes key from the subtree rooted at node and returns its payload. Children left with
+// too few keys are fixed on the way back up, node itself is left for its parent to fix.
+func (btree *BTree[T]) deleteRec(node *Node[T], key T) (error, []byte) {
 	i := 0
-	for i < node.n {
-		if key > node.K[i] {
-			i++
-			continue
-		} else if key == node.K[i] {
-			return nil, node, i, false
-		} else {
-			break
+	for i < node.n && btree.compare(node.K[i], key) < 0 {
+		i++
+	}
+	found := i < node.n && btree.compare(node.K[i], key) == 0
+	if found && node.isLeaf {
+		value := node.V[i]
+		node.deleteFromLeaf(i)
+		return btree.nodes.save(node), value
+	} else if node.isLeaf {
+		return ErrKeyNotFound, nil
+	}
+
+	err, child := btree.nodes.load(node.C[i])
+	if err != nil {
+		return err, nil
+	}
+	if found {
+		err, pred := btree.findLargestKeyInSubtreeRec(child)
+		if err != nil {
+			return err, nil
 		}
+		// an older copy of the key is in the left subtree, that one goes first
+		found = btree.compare(pred, key) != 0
 	}
-	if node.isLeaf {
-		return errors.New("key does not exist in btree"), nil, -1, false
+	var removed []byte
+	if found {
+		// replace the key with its predecessor, the last entry of the left subtree. It is removed by position,
+		// searching for it would find the oldest copy of the predecessor instead.
+		err, pred, value := btree.deleteLast(child)
+		if err != nil {
+			return err, nil
+		}
+		removed = node.V[i]
+		node.K[i], node.V[i] = pred, value
 	} else {
-		err, child, j, res := node.C[i].deleteRec(key)
+		err, value := btree.deleteRec(child, key)
 		if err != nil {
-			return err, child, j, res
-		} else if res == true {
-			if child.n < child.m-1 {
-				node.fixUnderflow(i, child)
-			}
-			return nil, node, i, true
-		} else {
-			if child.isLeaf {
-				if child.n > child.m-1 {
-					child.deleteFromLeaf(j)
-				} else {
-					node.deleteFromLeafWithTMinus1Keys(i, child, j)
-				}
-			} else {
-				var searchIn, P, C *Node[T]
-				var val T
-				var largest bool
-				if child.C[j] != nil {
-					searchIn = child.C[j]
-					P, C, val = child.findLargestKeyInSubtreeRec(searchIn)
-					largest = true
-				} else if child.C[j+1] != nil {
-					searchIn = child.C[j+1]
-					P, C, val = child.findSmallestKeyInSubtreeRec(searchIn)
-					largest = false
-				}
-				child.K[j] = val
-				if C.n > C.m-1 {
-					if largest == true {
-						C.deleteFromLeaf(C.n - 1)
-					} else {
-						C.deleteFromLeaf(0)
-					}
-				} else {
-					if largest == true {
-						P.deleteFromLeafWithTMinus1Keys(P.n-1, C, C.n-1)
-					} else {
-						P.deleteFromLeafWithTMinus1Keys(0, C, 0)
-					}
-
-				}
-			}
-			return nil, node, i, true
+			return err, nil
 		}
+		removed = value
 	}
-}
-
-func (node *Node[T]) findSmallestKeyInSubtreeRec(child *Node[T]) (*Node[T], *Node[T], T) {
-	if child.C[0] != nil {
-		return child.findSmallestKeyInSubtreeRec(child.C[0])
-	} else {
-		return node, child, child.K[0]
+	node.S[i]--
+	if child.n < child.minKeys() {
+		return btree.fixUnderflow(node, i, child), removed
 	}
+	return btree.nodes.save(node), removed
 }
 
-func (node *Node[T]) findLargestKeyInSubtreeRec(child *Node[T]) (*Node[T], *Node[T], T) {
-	if child.C[child.n-1] != nil {
-		return child.findLargestKeyInSubtreeRec(child.C[child.n-1])
-	} else {
-		return node, child, child.K[child.n-1]
+// deleteLast removes the last key of the subtree rooted at node and returns it with its payload.
+// Like deleteRec it leaves node for its parent to fix.
+func (btree *BTree[T]) deleteLast(node *Node[T]) (error, T, []byte) {
+	if node.isLeaf {
+		key, value := node.K[node.n-1], node.V[node.n-1]
+		node.deleteFromLeaf(node.n - 1)
+		return btree.nodes.save(node), key, value
+	}
+	i := node.n
+	err, child := btree.nodes.load(node.C[i])
+	if err != nil {
+		return err, defaultValue[T](), nil
+	}
+	err, key, value := btree.deleteLast(child)
+	if err != nil {
+		return err, defaultValue[T](), nil
 	}
+	node.S[i]--
+	if child.n < child.minKeys() {
+		return btree.fixUnderflow(node, i, child), key, value
+	}
+	return btree.nodes.save(node), key, value
 }
 
-func (node *Node[T]) deleteFromLeaf(i int) {
-	for j := i; j < node.n-1; j++ {
-		node.K[j] = node.K[j+1]
+func (btree *BTree[T]) findSmallestKeyInSubtreeRec(node *Node[T]) (error, T) {
+	if node.isLeaf {
+		return nil, node.K[0]
 	}
-	keyDataType := reflect.TypeOf(node.K[node.n-1])
-	if keyDataType == reflect.TypeOf(0) {
-		node.K[node.n-1] = any(0).(T) // Cast 0 to type T
-	} else if keyDataType == reflect.TypeOf("") {
-		node.K[node.n-1] = any("").(T) // Cast "" to type T
+	err, child := btree.nodes.load(node.C[0])
+	if err != nil {
+		return err, defaultValue[T]()
 	}
-	node.n--
+	return btree.findSmallestKeyInSubtreeRec(child)
 }
 
-func (node *Node[T]) deleteFromLeafWithTMinus1Keys(i int, foundNode *Node[T], j int) {
-	for k := j; k < foundNode.n-1; k++ {
-		foundNode.K[k] = foundNode.K[k+1]
+func (btree *BTree[T]) findLargestKeyInSubtreeRec(node *Node[T]) (error, T) {
+	if node.isLeaf {
+		return nil, node.K[node.n-1]
 	}
-	keyDataType := reflect.TypeOf(foundNode.K[foundNode.n-1])
-	if keyDataType == reflect.TypeOf(0) {
-		foundNode.K[foundNode.n-1] = any(0).(T) // Cast 0 to type T
-	} else if keyDataType == reflect.TypeOf("") {
-		foundNode.K[foundNode.n-1] = any("").(T) // Cast "" to type T
+	err, child := btree.nodes.load(node.C[node.n])
+	if err != nil {
+		return err, defaultValue[T]()
 	}
-	foundNode.n--
+	return btree.findLargestKeyInSubtreeRec(child)
+}
 
-	node.fixUnderflow(i, foundNode)
+func (node *Node[T]) deleteFromLeaf(i int) {
+	for j := i; j < node.n-1; j++ {
+		node.K[j] = node.K[j+1]
+		node.V[j] = node.V[j+1]
+	}
+	node.K[node.n-1] = defaultValue[T]()
+	node.V[node.n-1] = nil
+	node.n--
 }
 
-func (node *Node[T]) fixUnderflow(i int, child *Node[T]) {
-	if i > 0 && node.C[i-1] != nil {
+// fixUnderflow refills the child at index i, which has one key less than allowed, by borrowing a key
+// through node from a sibling that can spare one, or else by merging the child with a sibling.
+func (btree *BTree[T]) fixUnderflow(node *Node[T], i int, child *Node[T]) error {
+	var left, right *Node[T]
+	var err error
+	if i > 0 {
 		// left sibling exists case
-		for j := child.n - 1; j >= 0; j-- {
-			child.K[j+1] = child.K[j]
+		err, left = btree.nodes.load(node.C[i-1])
+		if err != nil {
+			return err
 		}
-		child.K[0] = node.K[i-1]
-		child.n++
-		sibling := node.C[i-1]
-
-		if sibling.n > sibling.m-1 {
-			node.K[i-1] = sibling.K[sibling.n-1]
-
-			P, C, val := child.findLargestKeyInSubtreeRec(sibling.C[sibling.n-1])
-			sibling.K[sibling.n-1] = val
-			if C.n > C.m-1 {
-				C.deleteFromLeaf(C.n - 1)
-			} else {
-				P.deleteFromLeafWithTMinus1Keys(P.n-1, C, C.n-1)
-			}
-		} else {
-			for k := i - 1; k < node.n-1; k++ {
-				node.K[k] = node.K[k+1]
-				node.C[k] = node.C[k+1]
-			}
-			node.C[node.n-1] = node.C[node.n]
-			node.C[node.n] = nil
-
-			keyDataType := reflect.TypeOf(node.K[node.n-1])
-			if keyDataType == reflect.TypeOf(0) {
-				node.K[node.n-1] = any(0).(T) // Cast 0 to type T
-			} else if keyDataType == reflect.TypeOf("") {
-				node.K[node.n-1] = any("").(T) // Cast "" to type T
-			}
-			node.n--
-
-			nInit := child.n
-			for k := sibling.n - 1; k >= 0; k-- {
-				child.K[k+nInit] = child.K[k]
-				child.C[k+nInit] = child.C[k]
-				child.K[k] = sibling.K[k]
-				child.C[k] = sibling.C[k]
-				child.n++
+		if left.n > left.minKeys() {
+			for j := child.n; j > 0; j-- {
+				child.K[j] = child.K[j-1]
+				child.V[j] = child.V[j-1]
+				child.C[j+1] = child.C[j]
+				child.S[j+1] = child.S[j]
 			}
+			child.C[1] = child.C[0]
+			child.S[1] = child.S[0]
+			child.K[0] = node.K[i-1]
+			child.V[0] = node.V[i-1]
+			child.C[0] = left.C[left.n]
+			child.S[0] = left.S[left.n]
+			child.n++
+
+			node.K[i-1] = left.K[left.n-1]
+			node.V[i-1] = left.V[left.n-1]
+			left.K[left.n-1] = defaultValue[T]()
+			left.V[left.n-1] = nil
+			left.C[left.n] = 0
+			left.S[left.n] = 0
+			left.n--
+			node.S[i-1], node.S[i] = left.size(), child.size()
+			return btree.saveAll(left, child, node)
 		}
-	} else if node.C[i+1] != nil {
+	}
+	if i < node.n {
 		// right sibling exists case
-		child.K[child.n] = node.K[i]
-		child.n++
-		sibling := node.C[i+1]
-
-		if sibling.n > sibling.m-1 {
-			node.K[i] = sibling.K[0]
-			for k := 0; k < sibling.n-1; k++ {
-				sibling.K[k] = sibling.K[k+1]
+		err, right = btree.nodes.load(node.C[i+1])
+		if err != nil {
+			return err
+		}
+		if right.n > right.minKeys() {
+			child.K[child.n] = node.K[i]
+			child.V[child.n] = node.V[i]
+			child.C[child.n+1] = right.C[0]
+			child.S[child.n+1] = right.S[0]
+			child.n++
+
+			node.K[i] = right.K[0]
+			node.V[i] = right.V[0]
+			for j := 0; j < right.n-1; j++ {
+				right.K[j] = right.K[j+1]
+				right.V[j] = right.V[j+1]
+				right.C[j] = right.C[j+1]
+				right.S[j] = right.S[j+1]
 			}
+			right.C[right.n-1] = right.C[right.n]
+			right.S[right.n-1] = right.S[right.n]
+			right.K[right.n-1] = defaultValue[T]()
+			right.V[right.n-1] = nil
+			right.C[right.n] = 0
+			right.S[right.n] = 0
+			right.n--
+			node.S[i], node.S[i+1] = child.size(), right.size()
+			return btree.saveAll(right, child, node)
+		}
+	}
 
-			keyDataType := reflect.TypeOf(sibling.K[sibling.n-1])
-			if keyDataType == reflect.TypeOf(0) {
-				sibling.K[sibling.n-1] = any(0).(T) // Cast 0 to type T
-			} else if keyDataType == reflect.TypeOf("") {
-				sibling.K[sibling.n-1] = any("").(T) // Cast "" to type T
-			}
-			sibling.n--
-		} else {
-			k := i
-			for k < node.n-1 {
-				node.K[k] = node.K[k+1]
-				node.C[k+1] = node.C[k+2]
-				k++
-			}
-			node.C[node.n] = nil
+	if left != nil {
+		return btree.mergeChildren(node, i-1, left, child)
+	}
+	return btree.mergeChildren(node, i, child, right)
+}
 
-			keyDataType := reflect.TypeOf(node.K[k])
-			if keyDataType == reflect.TypeOf(0) {
-				node.K[k] = any(0).(T) // Cast 0 to type T
-			} else if keyDataType == reflect.TypeOf("") {
-				node.K[k] = any("").(T) // Cast "" to type T
-			}
-			node.n--
+// mergeChildren moves the key at index i of node and everything in right into left,
+// then frees right, the child at index i+1.
+func (btree *BTree[T]) mergeChildren(node *Node[T], i int, left *Node[T], right *Node[T]) error {
+	left.K[left.n] = node.K[i]
+	left.V[left.n] = node.V[i]
+	left.n++
+	for j := 0; j < right.n; j++ {
+		left.K[left.n] = right.K[j]
+		left.V[left.n] = right.V[j]
+		left.C[left.n] = right.C[j]
+		left.S[left.n] = right.S[j]
+		left.n++
+	}
+	left.C[left.n] = right.C[right.n]
+	left.S[left.n] = right.S[right.n]
 
-			nInit := child.n
-			for k := 0; k < sibling.n; k++ {
-				child.K[k+nInit] = sibling.K[k]
-				child.n++
-			}
-		}
+	for j := i; j < node.n-1; j++ {
+		node.K[j] = node.K[j+1]
+		node.V[j] = node.V[j+1]
+		node.C[j+1] = node.C[j+2]
+		node.S[j+1] = node.S[j+2]
 	}
+	node.K[node.n-1] = defaultValue[T]()
+	node.V[node.n-1] = nil
+	node.C[node.n] = 0
+	node.S[node.n] = 0
+	node.n--
+	node.S[i] = left.size()
+
+	if err := btree.nodes.free(right); err != nil {
+		return err
+	}
+	return btree.saveAll(left, node)
 }
 
-//func (node *Node[T]) fixChildUnderflow(i int, foundNode *Node[T], sibling *Node[T], rightSibling bool) {
-//	if rightSibling {
-//		foundNode.K[foundNode.n-1] = node.K[i]
-//		if sibling.n > sibling.m-1 {
-//			node.K[i] = sibling.K[0]
-//			for K := 0; K < sibling.n-1; K++ {
-//				sibling.K[K] = sibling.K[K+1]
-//			}
-//
-//			keyDataType := reflect.TypeOf(sibling.K[sibling.n-1])
-//			if keyDataType == reflect.TypeOf(0) {
-//				sibling.K[sibling.n-1] = any(math.MinInt).(T) // Cast MinInt to type T
-//			} else if keyDataType == reflect.TypeOf("") {
-//				sibling.K[sibling.n-1] = any("").(T) // Cast "" to type T
-//			}
-//			sibling.n--
-//		} else {
-//			K := i
-//			for K < node.n-1 {
-//				node.K[K] = node.K[K+1]
-//				node.C[K+1] = node.C[K+2]
-//				K++
-//			}
-//			node.C[node.n] = nil
-//
-//			keyDataType := reflect.TypeOf(node.K[K])
-//			if keyDataType == reflect.TypeOf(0) {
-//				node.K[K] = any(math.MinInt).(T) // Cast MinInt to type T
-//			} else if keyDataType == reflect.TypeOf("") {
-//				node.K[K] = any("").(T) // Cast "" to type T
-//			}
-//			node.n--
-//
-//			nInit := foundNode.n
-//			for K := 0; K < sibling.n; K++ {
-//				foundNode.K[K+nInit] = sibling.K[K]
-//				foundNode.n++
-//			}
-//		}
-//	} else {
-//		foundNode.K[0] = node.K[i-1]
-//		if sibling.n > sibling.m-1 {
-//			node.K[i-1] = sibling.K[sibling.n-1]
-//
-//			keyDataType := reflect.TypeOf(sibling.K[sibling.n-1])
-//			if keyDataType == reflect.TypeOf(0) {
-//				sibling.K[sibling.n-1] = any(math.MinInt).(T) // Cast MinInt to type T
-//			} else if keyDataType == reflect.TypeOf("") {
-//				sibling.K[sibling.n-1] = any("").(T) // Cast "" to type T
-//			}
-//			sibling.n--
-//		} else {
-//			for K := i - 1; K < node.n-1; K++ {
-//				node.K[K] = node.K[K+1]
-//				node.C[K] = node.C[K+1]
-//			}
-//			node.C[node.n-1] = node.C[node.n]
-//			node.C[node.n] = nil
-//
-//			keyDataType := reflect.TypeOf(node.K[node.n-1])
-//			if keyDataType == reflect.TypeOf(0) {
-//				node.K[node.n-1] = any(math.MinInt).(T) // Cast MinInt to type T
-//			} else if keyDataType == reflect.TypeOf("") {
-//				node.K[node.n-1] = any("").(T) // Cast "" to type T
-//			}
-//			node.n--
-//
-//			nInit := foundNode.n
-//			for K := sibling.n - 1; K >= 0; K-- {
-//				foundNode.K[K+nInit] = foundNode.K[K]
-//				foundNode.K[K] = sibling.K[K]
-//				foundNode.n++
-//			}
-//		}
-//	}
-//}
+func (btree *BTree[T]) saveAll(nodes ...*Node[T]) error {
+	for _, node := range nodes {
+		if err := btree.nodes.save(node); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,12 +1,24 @@
 package storage
 
 import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 )
 
+// pageSize returns the page size NewBTree turns into a btree of order m
+func pageSize(m int) int {
+	return 32*m + 8
+}
+
 func TestSearch(t *testing.T) {
 	deg := 3
-	_, btree := NewBTree[int](deg)
+	_, btree := NewBTree[int](pageSize(deg))
 
 	// Test empty tree
 	if btree.Exists(10) {
@@ -37,7 +49,7 @@ func TestSearch(t *testing.T) {
 
 func TestTraversal(t *testing.T) {
 	deg := 3
-	_, btree := NewBTree[int](deg)
+	_, btree := NewBTree[int](pageSize(deg))
 
 	// Test empty tree
 	err, keys := btree.traverse()
@@ -71,7 +83,7 @@ func TestTraversal(t *testing.T) {
 
 func TestStringKeys(t *testing.T) {
 	deg := 3
-	_, btree := NewBTree[string](deg)
+	_, btree := NewBTree[string](pageSize(deg))
 
 	// Test string K
 	strings := []string{"apple", "banana", "cherry", "date", "elderberry"}
@@ -103,7 +115,7 @@ func TestStringKeys(t *testing.T) {
 
 func TestLargeNumberOfKeys(t *testing.T) {
 	deg := 3
-	_, btree := NewBTree[int](deg)
+	_, btree := NewBTree[int](pageSize(deg))
 
 	// Insert 100 K
 	for i := 0; i < 100; i++ {
@@ -142,7 +154,7 @@ func TestMinimumDegreeValidation(t *testing.T) {
 
 func TestNodeFullness(t *testing.T) {
 	deg := 3
-	_, btree := NewBTree[int](deg)
+	_, btree := NewBTree[int](pageSize(deg))
 
 	// Insert 2t-1 K to fill root
 	for i := 0; i < deg-1; i++ {
@@ -163,7 +175,7 @@ func TestNodeFullness(t *testing.T) {
 
 func TestDeleteFromEmptyTree(t *testing.T) {
 	deg := 3
-	_, btree := NewBTree[int](deg)
+	_, btree := NewBTree[int](pageSize(deg))
 
 	err, success := btree.Delete(10)
 	if err == nil {
@@ -176,7 +188,7 @@ func TestDeleteFromEmptyTree(t *testing.T) {
 
 func TestDeleteNonExistentKey(t *testing.T) {
 	deg := 3
-	_, btree := NewBTree[int](deg)
+	_, btree := NewBTree[int](pageSize(deg))
 
 	// Insert some K
 	for i := 1; i <= 5; i++ {
@@ -195,7 +207,7 @@ func TestDeleteNonExistentKey(t *testing.T) {
 
 func TestDeleteFromLeaf(t *testing.T) {
 	deg := 3
-	_, btree := NewBTree[int](deg)
+	_, btree := NewBTree[int](pageSize(deg))
 
 	// Insert K
 	keys := []int{10, 20, 30, 40, 50}
@@ -227,7 +239,7 @@ func TestDeleteFromLeaf(t *testing.T) {
 
 func TestDeleteFromInternalNode(t *testing.T) {
 	deg := 3
-	_, btree := NewBTree[int](deg)
+	_, btree := NewBTree[int](pageSize(deg))
 
 	// Insert enough K to create internal nodes
 	for i := 1; i <= 10; i++ {
@@ -259,7 +271,7 @@ func TestDeleteFromInternalNode(t *testing.T) {
 
 func TestDeleteWithKeyBorrowing(t *testing.T) {
 	deg := 3
-	_, btree := NewBTree[int](deg)
+	_, btree := NewBTree[int](pageSize(deg))
 
 	// Insert K to create a scenario where borrowing will be needed
 	keys := []int{10, 20, 30, 40, 50, 60, 70}
@@ -292,7 +304,7 @@ func TestDeleteWithKeyBorrowing(t *testing.T) {
 
 func TestDeleteWithNodeMerging(t *testing.T) {
 	deg := 3
-	_, btree := NewBTree[int](deg)
+	_, btree := NewBTree[int](pageSize(deg))
 
 	// Insert K to create a scenario where merging will be needed
 	keys := []int{10, 20, 30, 40, 50, 60}
@@ -328,7 +340,7 @@ func TestDeleteWithNodeMerging(t *testing.T) {
 
 func TestSequentialDeletion(t *testing.T) {
 	deg := 3
-	_, btree := NewBTree[int](deg)
+	_, btree := NewBTree[int](pageSize(deg))
 
 	// Insert K
 	for i := 1; i <= 20; i++ {
@@ -360,3 +372,327 @@ func TestSequentialDeletion(t *testing.T) {
 		t.Error("Expected nil K for empty tree")
 	}
 }
+
+// checkTree verifies key order, key counts, subtree sizes and that every leaf is at the same depth
+func checkTree[T any](t *testing.T, btree *BTree[T]) []T {
+	t.Helper()
+	var keys []T
+	depth := 0
+	var walk func(node *Node[T], level int)
+	walk = func(node *Node[T], level int) {
+		if node != btree.root && (node.n < node.minKeys() || node.n > node.m-1) {
+			t.Fatalf("node %d holds %d keys", node.pgno, node.n)
+		}
+		for i := 0; i <= node.n; i++ {
+			if !node.isLeaf {
+				err, child := btree.nodes.load(node.C[i])
+				if err != nil {
+					t.Fatal(err)
+				}
+				before := len(keys)
+				walk(child, level+1)
+				if node.S[i] != len(keys)-before {
+					t.Fatalf("node %d counts %d keys under child %d, found %d", node.pgno, node.S[i], i, len(keys)-before)
+				}
+			}
+			if i < node.n {
+				keys = append(keys, node.K[i])
+			}
+		}
+		if node.isLeaf {
+			if depth == 0 {
+				depth = level
+			} else if depth != level {
+				t.Fatalf("leaf %d is at depth %d, expected %d", node.pgno, level, depth)
+			}
+		}
+	}
+	walk(btree.root, 1)
+	if depth != btree.height {
+		t.Fatalf("expected height %d, got %d", depth, btree.height)
+	}
+	for i := 1; i < len(keys); i++ {
+		if btree.compare(keys[i], keys[i-1]) < 0 {
+			t.Fatalf("Keys not in sorted order: %v", keys)
+		}
+	}
+	return keys
+}
+
+func TestRandomInsertDelete(t *testing.T) {
+	for _, deg := range []int{3, 4, 5, 8} {
+		_, btree := NewBTree[int](pageSize(deg))
+		present := make(map[int]bool)
+		rng := rand.New(rand.NewSource(int64(deg)))
+
+		for i := 0; i < 2000; i++ {
+			key := rng.Intn(300)
+			if present[key] {
+				err, _ := btree.Delete(key)
+				if err != nil {
+					t.Fatalf("Unexpected error deleting key %d: %v", key, err)
+				}
+				delete(present, key)
+			} else {
+				btree.Insert(key)
+				present[key] = true
+			}
+			if i%50 == 0 {
+				if keys := checkTree(t, btree); len(keys) != len(present) {
+					t.Fatalf("Expected %d keys, got %d", len(present), len(keys))
+				}
+			}
+		}
+		for key := range present {
+			if !btree.Exists(key) {
+				t.Errorf("Expected to find key %d", key)
+			}
+		}
+	}
+}
+
+func TestComparatorDescending(t *testing.T) {
+	_, btree := NewBTreeFunc[int](pageSize(3), func(a, b int) int { return b - a }, DuplicatesMultiset)
+	for i := 0; i < 50; i++ {
+		btree.Insert(i)
+	}
+	keys := checkTree(t, btree)
+	for i := range keys {
+		if keys[i] != 49-i {
+			t.Fatalf("Keys not in descending order: %v", keys)
+		}
+	}
+	for i := 0; i < 50; i += 2 {
+		if err, _ := btree.Delete(i); err != nil {
+			t.Errorf("Unexpected error deleting key %d: %v", i, err)
+		}
+	}
+	if btree.Exists(10) || !btree.Exists(11) {
+		t.Error("Unexpected keys after deletion")
+	}
+}
+
+func TestComparatorCaseInsensitive(t *testing.T) {
+	_, btree := NewBTreeFunc[string](pageSize(3), func(a, b string) int {
+		return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+	}, DuplicatesUnique)
+	for _, s := range []string{"banana", "Apple", "cherry", "Date"} {
+		btree.Insert(s)
+	}
+	if !btree.Exists("APPLE") || !btree.Exists("date") {
+		t.Error("Expected case-insensitive lookups to find keys")
+	}
+	if err := btree.Insert("BANANA"); err != ErrDuplicateKey {
+		t.Errorf("Expected ErrDuplicateKey inserting a key equal to an existing one, got %v", err)
+	}
+	_, keys := btree.traverse()
+	if strings.Join(keys, ",") != "Apple,banana,cherry,Date" {
+		t.Errorf("Unexpected key order %v", keys)
+	}
+}
+
+type compositeKey struct {
+	table string
+	id    int
+}
+
+func TestComparatorCompositeKeys(t *testing.T) {
+	compare := func(a, b compositeKey) int {
+		if c := strings.Compare(a.table, b.table); c != 0 {
+			return c
+		}
+		return a.id - b.id
+	}
+	_, btree := NewBTreeFunc[compositeKey](pageSize(4), compare, DuplicatesMultiset)
+	for id := 0; id < 20; id++ {
+		btree.Insert(compositeKey{"users", id})
+		btree.Insert(compositeKey{"orders", id})
+	}
+
+	var ids []int
+	for key := range btree.Range(compositeKey{"users", 5}, compositeKey{"users", 9}) {
+		ids = append(ids, key.id)
+	}
+	if len(ids) != 4 || ids[0] != 5 || ids[3] != 8 {
+		t.Errorf("Unexpected range over composite keys %v", ids)
+	}
+
+	_, pager := OpenPager(filepath.Join(t.TempDir(), "composite.db"), 512)
+	defer pager.Close()
+	if err, _ := OpenBTreeFunc[compositeKey](pager, 0, compare, DuplicatesMultiset); err == nil {
+		t.Error("Expected error storing composite keys in a page")
+	}
+}
+
+func TestComparatorByteKeysOnPager(t *testing.T) {
+	_, pager := OpenPager(filepath.Join(t.TempDir(), "bytes.db"), 512)
+	defer pager.Close()
+	err, btree := OpenBTreeFunc[[]byte](pager, 0, bytes.Compare, DuplicatesUnique)
+	if err != nil {
+		t.Fatalf("Unexpected error creating btree: %v", err)
+	}
+	for i := 0; i < 300; i++ {
+		btree.Insert([]byte{byte(i % 7), byte(i / 7), 0xff})
+	}
+	previous := []byte(nil)
+	count := 0
+	for key := range btree.All() {
+		if previous != nil && bytes.Compare(previous, key) >= 0 {
+			t.Fatalf("Keys not in sorted order: %v after %v", key, previous)
+		}
+		previous = key
+		count++
+	}
+	if count != 300 {
+		t.Errorf("Expected 300 keys, got %d", count)
+	}
+}
+
+func TestDuplicatesUnique(t *testing.T) {
+	_, btree := NewBTreeFunc[int](pageSize(3), cmp.Compare[int], DuplicatesUnique)
+	for i := 0; i < 50; i++ {
+		if err := btree.Insert(i); err != nil {
+			t.Fatalf("Unexpected error inserting key %d: %v", i, err)
+		}
+	}
+	for i := 0; i < 50; i++ {
+		if err := btree.Insert(i); err != ErrDuplicateKey {
+			t.Errorf("Expected ErrDuplicateKey for key %d, got %v", i, err)
+		}
+	}
+	if keys := checkTree(t, btree); len(keys) != 50 {
+		t.Errorf("Expected 50 keys, got %d", len(keys))
+	}
+}
+
+func TestDuplicatesReplace(t *testing.T) {
+	_, btree := NewBTreeFunc[string](pageSize(3), func(a, b string) int {
+		return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+	}, DuplicatesReplace)
+	for _, s := range []string{"a", "b", "c", "d", "e", "B", "D"} {
+		if err := btree.Insert(s); err != nil {
+			t.Fatalf("Unexpected error inserting key %s: %v", s, err)
+		}
+	}
+	if keys := checkTree(t, btree); strings.Join(keys, "") != "aBcDe" {
+		t.Errorf("Expected replaced keys, got %v", keys)
+	}
+}
+
+func TestDuplicatesMultiset(t *testing.T) {
+	for _, deg := range []int{3, 4, 5} {
+		_, btree := NewBTree[int](pageSize(deg))
+		for round := 0; round < 10; round++ {
+			for i := 0; i < 20; i++ {
+				btree.insert(i, []byte{byte(round)})
+			}
+		}
+		checkTree(t, btree)
+		_, count := btree.Count(7)
+		if _, none := btree.Count(100); count != 10 || none != 0 {
+			t.Fatalf("Expected 10 copies of key 7, got %d", count)
+		}
+
+		// copies are deleted oldest first
+		for round := 0; round < 5; round++ {
+			err, value := btree.remove(7)
+			if err != nil || value[0] != byte(round) {
+				t.Fatalf("Expected copy from round %d, got %v, %v", round, value, err)
+			}
+		}
+		if _, value := btree.get(7); value[0] != 5 {
+			t.Errorf("Expected oldest remaining copy, got round %d", value[0])
+		}
+		if _, count = btree.Count(7); count != 5 {
+			t.Errorf("Expected 5 copies of key 7, got %d", count)
+		}
+
+		err, count := btree.DeleteAll(3)
+		if err != nil || count != 10 {
+			t.Errorf("Expected DeleteAll to remove 10 copies, got %d, %v", count, err)
+		}
+		if btree.Exists(3) {
+			t.Error("Expected no copies of key 3 after DeleteAll")
+		}
+		if keys := checkTree(t, btree); len(keys) != 185 {
+			t.Errorf("Expected 185 keys, got %d", len(keys))
+		}
+		if _, count = btree.DeleteAll(1000); count != 0 {
+			t.Errorf("Expected DeleteAll of missing key to remove nothing, got %d", count)
+		}
+	}
+}
+
+func TestDuplicatesMultisetOrder(t *testing.T) {
+	for _, deg := range []int{3, 4, 5, 8} {
+		for seed := int64(0); seed < 5; seed++ {
+			_, btree := NewBTree[int](pageSize(deg))
+			r := rand.New(rand.NewSource(seed))
+			// copies[key] are the copies of key in the tree, oldest first
+			copies := make(map[int][]uint32)
+			next := uint32(0)
+			for step := 0; step < 2000; step++ {
+				key := r.Intn(20)
+				if r.Intn(3) > 0 {
+					btree.insert(key, binary.BigEndian.AppendUint32(nil, next))
+					copies[key] = append(copies[key], next)
+					next++
+					continue
+				}
+				err, value := btree.remove(key)
+				if len(copies[key]) == 0 {
+					if err == nil {
+						t.Fatalf("Expected error deleting %d, which is not in the tree", key)
+					}
+					continue
+				}
+				if err != nil || binary.BigEndian.Uint32(value) != copies[key][0] {
+					t.Fatalf("Order %d, seed %d, step %d: expected Delete(%d) to remove copy %d, got %v (%v)",
+						deg, seed, step, key, copies[key][0], value, err)
+				}
+				copies[key] = copies[key][1:]
+			}
+			checkTree(t, btree)
+		}
+	}
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	_, memTree := NewBTree[int](pageSize(4))
+	_, pager := OpenPager(filepath.Join(t.TempDir(), "tree.db"), 512)
+	defer pager.Close()
+	pager.SetCacheSize(10)
+	_, pagerTree := OpenBTree[int](pager, 0)
+
+	for _, btree := range []*BTree[int]{memTree, pagerTree} {
+		var wg sync.WaitGroup
+		for w := 0; w < 4; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := w; i < 2000; i += 4 {
+					if err := btree.Insert(i); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}()
+		}
+		for r := 0; r < 4; r++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 2000; i++ {
+					btree.Exists(i)
+					btree.Count(i)
+				}
+				for range btree.Range(100, 200) {
+				}
+			}()
+		}
+		wg.Wait()
+		if keys := checkTree(t, btree); len(keys) != 2000 {
+			t.Errorf("Expected 2000 keys, got %d", len(keys))
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package storage
+
+import (
+	"errors"
+	"iter"
+)
+
+/*
+BulkLoad builds a tree from keys in ascending order bottom-up, instead of descending from the root for
+every key. Keys fill a leaf up to the fill factor; the key after a full leaf becomes a separator in the
+level above, which fills the same way, and the tree grows a level whenever the top one fills.
+
+Only the right edge of every level is held in memory: the node keys are added to and the full node before
+it. Once the keys run out, an open node holding fewer keys than a node needs shares the keys of the full
+node before it, or is merged into it, through the separator between them.
+*/
+
+var ErrUnsorted = errors.New("bulk load keys are not in ascending order")
+
+// bulkLevel is the right edge of one level of a tree being built
+type bulkLevel[T any] struct {
+	open *Node[T] // the node keys are added to
+	full *Node[T] // the node before open, nil when open is the first node of the level or full itself
+}
+
+type bulkLoader[T any] struct {
+	btree    *BTree[T]
+	capacity int            // keys per node
+	levels   []bulkLevel[T] // leaves first
+}
+
+// BulkLoad fills an empty tree with the keys of sorted, which have to be in ascending order, and strictly
+// ascending unless the tree keeps duplicates. fillFactor is the share of the m - 1 keys a node can hold
+// that each node is given, in (0, 1]; nodes always get at least as many keys as a node needs.
+// When a key is out of order nothing is loaded and ErrUnsorted is returned.
+func (btree *BTree[T]) BulkLoad(sorted iter.Seq[T], fillFactor float64) error {
+	if fillFactor <= 0 || fillFactor > 1 {
+		return errors.New("fill factor must be greater than 0 and at most 1")
+	}
+	return btree.write(nil, func() error { return btree.bulkLoad(sorted, fillFactor) })
+}
+
+func (btree *BTree[T]) bulkLoad(sorted iter.Seq[T], fillFactor float64) error {
+	if btree.root.n > 0 || !btree.root.isLeaf {
+		return errors.New("bulk load needs an empty btree")
+	}
+	loader := &bulkLoader[T]{
+		btree:    btree,
+		capacity: min(max(int(fillFactor*float64(btree.m-1)), btree.root.minKeys()), btree.m-1),
+	}
+	err, leaf := btree.nodes.alloc(true)
+	if err != nil {
+		return err
+	}
+	loader.levels = []bulkLevel[T]{{open: leaf}}
+
+	var last T
+	count := 0
+	for key := range sorted {
+		if count > 0 {
+			c := btree.compare(last, key)
+			if c > 0 || c == 0 && btree.duplicates != DuplicatesMultiset {
+				err = ErrUnsorted
+				break
+			}
+		}
+		if err = loader.add(key); err != nil {
+			break
+		}
+		last = key
+		count++
+	}
+
+	err2, top := loader.finish()
+	if err2 != nil {
+		return err2
+	}
+	if err != nil || count == 0 {
+		if err2 = btree.freeSubtree(top); err2 != nil {
+			return err2
+		}
+		return err
+	}
+
+	// the top node moves into the root page, which never moves
+	root := btree.root
+	top.K, root.K = root.K, top.K
+	top.V, root.V = root.V, top.V
+	top.C, root.C = root.C, top.C
+	top.S, root.S = root.S, top.S
+	top.overflow, root.overflow = root.overflow, top.overflow
+	root.n = top.n
+	root.isLeaf = top.isLeaf
+	btree.height = len(loader.levels)
+	if err = btree.nodes.free(top); err != nil {
+		return err
+	}
+	return btree.nodes.save(root)
+}
+
+// add appends key to the open leaf, or makes it the separator after the leaf when the leaf is full
+func (loader *bulkLoader[T]) add(key T) error {
+	leaf := loader.levels[0].open
+	if leaf.n < loader.capacity {
+		leaf.K[leaf.n] = key
+		leaf.n++
+		return nil
+	}
+	err, next := loader.btree.nodes.alloc(true)
+	if err != nil {
+		return err
+	}
+	return loader.push(0, key, next)
+}
+
+// push closes the open node of level l and opens next after it, key separating the two goes to the level above
+func (loader *bulkLoader[T]) push(l int, key T, next *Node[T]) error {
+	level := &loader.levels[l]
+	if level.full != nil {
+		if err := loader.btree.nodes.save(level.full); err != nil {
+			return err
+		}
+	}
+	level.full, level.open = level.open, next
+	if l+1 == len(loader.levels) {
+		err, parent := loader.btree.nodes.alloc(false)
+		if err != nil {
+			return err
+		}
+		parent.C[0] = level.full.pgno
+		loader.levels = append(loader.levels, bulkLevel[T]{open: parent})
+	}
+
+	parent := loader.levels[l+1].open
+	parent.S[parent.n] = level.full.size()
+	if parent.n < loader.capacity {
+		parent.K[parent.n] = key
+		parent.n++
+		parent.C[parent.n] = next.pgno
+		return nil
+	}
+	err, sibling := loader.btree.nodes.alloc(false)
+	if err != nil {
+		return err
+	}
+	sibling.C[0] = next.pgno
+	return loader.push(l+1, key, sibling)
+}
+
+// finish fills up the open nodes on the right edge, saves them and returns the top node.
+// A top node left without keys is dropped, its only child becomes the top.
+func (loader *bulkLoader[T]) finish() (error, *Node[T]) {
+	for l := 0; l < len(loader.levels)-1; l++ {
+		if err := loader.fill(l); err != nil {
+			return err, nil
+		}
+	}
+	for len(loader.levels) > 1 && loader.levels[len(loader.levels)-1].open.n == 0 {
+		if err := loader.btree.nodes.free(loader.levels[len(loader.levels)-1].open); err != nil {
+			return err, nil
+		}
+		loader.levels = loader.levels[:len(loader.levels)-1]
+	}
+	// the nodes on the right edge were changed after their parents counted their keys
+	for l := 1; l < len(loader.levels); l++ {
+		below := loader.levels[l-1]
+		for _, node := range []*Node[T]{loader.levels[l].full, loader.levels[l].open} {
+			for i := 0; node != nil && i <= node.n; i++ {
+				for _, child := range []*Node[T]{below.full, below.open} {
+					if child != nil && node.C[i] == child.pgno {
+						node.S[i] = child.size()
+					}
+				}
+			}
+		}
+	}
+	for _, level := range loader.levels {
+		for _, node := range []*Node[T]{level.full, level.open} {
+			if node == nil {
+				continue
+			}
+			if err := loader.btree.nodes.save(node); err != nil {
+				return err, nil
+			}
+		}
+	}
+	return nil, loader.levels[len(loader.levels)-1].open
+}
+
+// fill gives the open node of level l the keys a node needs from the full node before it
+func (loader *bulkLoader[T]) fill(l int) error {
+	level := &loader.levels[l]
+	left, right := level.full, level.open
+	if right.n >= right.minKeys() {
+		return nil
+	}
+
+	// the separator of left and right is the last key of the lowest open node above that has keys,
+	// the open nodes below that one have a single child
+	j := l + 1
+	for loader.levels[j].open.n == 0 {
+		j++
+	}
+	parent := loader.levels[j].open
+	joined := left.content().join(parent.K[parent.n-1], parent.V[parent.n-1], right.content())
+
+	if len(joined.K)-1 >= 2*right.minKeys() {
+		half := (len(joined.K) - 1) / 2
+		left.setContent(joined.slice(0, half))
+		right.setContent(joined.slice(half+1, len(joined.K)))
+		parent.K[parent.n-1] = joined.K[half]
+		parent.V[parent.n-1] = joined.V[half]
+		return nil
+	}
+
+	// too few keys for two nodes, right and the empty open nodes above it are merged away
+	left.setContent(joined)
+	parent.n--
+	parent.K[parent.n] = defaultValue[T]()
+	parent.V[parent.n] = nil
+	parent.C[parent.n+1] = 0
+	parent.S[parent.n+1] = 0
+	if err := loader.btree.nodes.free(right); err != nil {
+		return err
+	}
+	level.open, level.full = left, nil
+	for k := l + 1; k < j; k++ {
+		if err := loader.btree.nodes.free(loader.levels[k].open); err != nil {
+			return err
+		}
+		loader.levels[k].open, loader.levels[k].full = loader.levels[k].full, nil
+	}
+	return nil
+}
+
+// freeSubtree frees node and every node below it
+func (btree *BTree[T]) freeSubtree(node *Node[T]) error {
+	if !node.isLeaf {
+		for i := 0; i <= node.n; i++ {
+			err, child := btree.nodes.load(node.C[i])
+			if err != nil {
+				return err
+			}
+			if err = btree.freeSubtree(child); err != nil {
+				return err
+			}
+		}
+	}
+	return btree.nodes.free(node)
+}
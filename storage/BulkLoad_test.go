@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"cmp"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestBulkLoad(t *testing.T) {
+	for _, deg := range []int{3, 4, 5, 8} {
+		for _, fill := range []float64{0.01, 0.5, 0.7, 1} {
+			for _, n := range []int{0, 1, 2, 3, 7, 10, 64, 100, 1000, 5000} {
+				_, btree := NewBTree[int](pageSize(deg))
+				if err := btree.BulkLoad(slices.Values(seq(n)), fill); err != nil {
+					t.Fatalf("Unexpected error loading %d keys in order %d: %v", n, deg, err)
+				}
+				if keys := checkTree(t, btree); !slices.Equal(keys, seq(n)) {
+					t.Fatalf("Expected %d keys after loading in order %d with fill %v, got %d", n, deg, fill, len(keys))
+				}
+			}
+		}
+	}
+}
+
+func TestBulkLoadThenChange(t *testing.T) {
+	_, btree := NewBTree[int](pageSize(5))
+	btree.BulkLoad(slices.Values(seq(1000)), 1)
+	for i := 1000; i < 1500; i++ {
+		btree.Insert(i)
+	}
+	for i := 0; i < 1500; i += 2 {
+		if err, _ := btree.Delete(i); err != nil {
+			t.Fatalf("Unexpected error deleting %d: %v", i, err)
+		}
+	}
+	if keys := checkTree(t, btree); len(keys) != 750 || keys[0] != 1 {
+		t.Errorf("Expected the 750 odd keys, got %d keys", len(keys))
+	}
+}
+
+func TestBulkLoadOnPager(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tree.db")
+	_, pager := OpenPager(path, 512)
+	pager.SetCacheSize(5)
+	_, btree := OpenBTree[int](pager, 0)
+	if err := btree.BulkLoad(slices.Values(seq(3000)), 0.9); err != nil {
+		t.Fatalf("Unexpected error loading: %v", err)
+	}
+	root := btree.Root()
+	pager.Close()
+
+	_, pager = OpenPager(path, 512)
+	defer pager.Close()
+	_, btree = OpenBTree[int](pager, root)
+	if keys := checkTree(t, btree); !slices.Equal(keys, seq(3000)) {
+		t.Errorf("Expected 3000 keys after reopening, got %d", len(keys))
+	}
+	// only the page the top node was built in before it moved into the root is free
+	if err, free := pager.freePages(); err != nil || len(free) != 1 {
+		t.Errorf("Expected one free page, got %d (%v)", len(free), err)
+	}
+}
+
+func TestBulkLoadUnsorted(t *testing.T) {
+	_, pager := OpenPager(filepath.Join(t.TempDir(), "tree.db"), 512)
+	defer pager.Close()
+	_, btree := OpenBTree[int](pager, 0)
+	pages := pager.PageCount()
+	keys := append(seq(500), 100)
+	if err := btree.BulkLoad(slices.Values(keys), 1); err != ErrUnsorted {
+		t.Fatalf("Expected ErrUnsorted, got %v", err)
+	}
+	if keys := checkTree(t, btree); len(keys) != 0 {
+		t.Errorf("Expected the tree to stay empty, got %d keys", len(keys))
+	}
+	if err, free := pager.freePages(); err != nil || int(pager.PageCount())-len(free) != int(pages) {
+		t.Errorf("Expected the pages of the partial load to be freed, %d of %d are free", len(free), pager.PageCount())
+	}
+
+	btree.Insert(1)
+	if err := btree.BulkLoad(slices.Values(seq(10)), 1); err == nil {
+		t.Error("Expected error loading into a tree that is not empty")
+	}
+	if err := btree.BulkLoad(slices.Values(seq(10)), 0); err == nil {
+		t.Error("Expected error for a fill factor of 0")
+	}
+}
+
+func TestBulkLoadDuplicates(t *testing.T) {
+	keys := []int{1, 1, 1, 2, 3, 3, 3, 3, 4, 5, 5, 5, 5, 5, 5, 6}
+	_, multiset := NewBTreeFunc[int](pageSize(3), cmp.Compare[int], DuplicatesMultiset)
+	if err := multiset.BulkLoad(slices.Values(keys), 1); err != nil {
+		t.Fatalf("Unexpected error loading duplicates: %v", err)
+	}
+	got := checkTree(t, multiset)
+	if _, count := multiset.Count(5); !slices.Equal(got, keys) || count != 6 {
+		t.Errorf("Expected every copy to be loaded, got %v", got)
+	}
+
+	_, unique := NewBTreeFunc[int](pageSize(3), cmp.Compare[int], DuplicatesUnique)
+	if err := unique.BulkLoad(slices.Values(keys), 1); err != ErrUnsorted {
+		t.Errorf("Expected ErrUnsorted loading duplicates into a unique tree, got %v", err)
+	}
+}
+
+// seq returns the keys 0 to n-1
+func seq(n int) []int {
+	keys := make([]int, n)
+	for i := range keys {
+		keys[i] = i
+	}
+	return keys
+}
@@ -0,0 +1,308 @@
+package storage
+
+// Cursor walks the keys of a BTree in order, loading one node at a time.
+// A cursor is invalidated by any change to the tree and has to be positioned again with First, Last or Seek.
+// Each call holds the read lock of the tree, a cursor itself must not be shared between goroutines.
+// A cursor over a Snapshot needs no lock and stays valid while the tree changes.
+// While a transaction is open, a cursor over the tree reads the last commit and one of the transaction
+// reads what the transaction changed.
+type Cursor[T any] struct {
+	btree    *BTree[T]
+	snapshot *Snapshot[T]     // nil for a cursor over the tree itself
+	tx       *Tx[T]           // the transaction of a cursor from Tx.Cursor
+	stack    []cursorFrame[T] // path from the root to the current key
+}
+
+// cursorFrame is a node on the path of a cursor. In the last frame i is the index of the current key,
+// in every other frame it is the index of the child the path continues in.
+type cursorFrame[T any] struct {
+	node *Node[T]
+	i    int
+}
+
+// Cursor returns a cursor that is not positioned yet
+func (btree *BTree[T]) Cursor() *Cursor[T] {
+	return &Cursor[T]{btree: btree}
+}
+
+func (cursor *Cursor[T]) Valid() bool {
+	return len(cursor.stack) > 0
+}
+
+// Key returns the key the cursor is positioned on. It must only be called when Valid is true.
+func (cursor *Cursor[T]) Key() T {
+	cursor.rlock()
+	defer cursor.runlock()
+	return cursor.key()
+}
+
+func (cursor *Cursor[T]) key() T {
+	top := cursor.stack[len(cursor.stack)-1]
+	return top.node.K[top.i]
+}
+
+// First positions the cursor on the smallest key
+func (cursor *Cursor[T]) First() error {
+	cursor.rlock()
+	defer cursor.runlock()
+	cursor.stack = cursor.stack[:0]
+	err, root := cursor.root()
+	if err != nil {
+		return err
+	}
+	if err = cursor.descendLeft(root); err != nil {
+		return err
+	}
+	cursor.skipEmpty()
+	return nil
+}
+
+// Last positions the cursor on the largest key
+func (cursor *Cursor[T]) Last() error {
+	cursor.rlock()
+	defer cursor.runlock()
+	return cursor.last()
+}
+
+func (cursor *Cursor[T]) last() error {
+	cursor.stack = cursor.stack[:0]
+	err, root := cursor.root()
+	if err != nil {
+		return err
+	}
+	if err = cursor.descendRight(root); err != nil {
+		return err
+	}
+	cursor.skipEmpty()
+	return nil
+}
+
+// Seek positions the cursor on the smallest key greater than or equal to key
+func (cursor *Cursor[T]) Seek(key T) error {
+	cursor.rlock()
+	defer cursor.runlock()
+	return cursor.seek(key, false)
+}
+
+// seekFloor positions the cursor on the last copy of the largest key less than or equal to key
+func (cursor *Cursor[T]) seekFloor(key T) error {
+	cursor.rlock()
+	defer cursor.runlock()
+	return cursor.floor(key)
+}
+
+func (cursor *Cursor[T]) floor(key T) error {
+	err := cursor.seek(key, true)
+	if err == nil && cursor.Valid() {
+		return cursor.prev()
+	} else if err == nil {
+		return cursor.last()
+	}
+	return err
+}
+
+// pass positions the cursor after the first seen copies of key, in descending order when backward is set
+func (cursor *Cursor[T]) pass(key T, seen int, backward bool) error {
+	cursor.rlock()
+	defer cursor.runlock()
+	var err error
+	step := cursor.next
+	if backward {
+		err = cursor.floor(key)
+		step = cursor.prev
+	} else {
+		err = cursor.seek(key, false)
+	}
+	for ; err == nil && seen > 0 && cursor.Valid() && cursor.btree.compare(cursor.key(), key) == 0; seen-- {
+		err = step()
+	}
+	return err
+}
+
+// seek positions the cursor on the smallest key greater than key, or equal to key when after is false
+func (cursor *Cursor[T]) seek(key T, after bool) error {
+	cursor.stack = cursor.stack[:0]
+	err, node := cursor.root()
+	if err != nil {
+		return err
+	}
+	for {
+		i := 0
+		for i < node.n {
+			if c := cursor.btree.compare(node.K[i], key); c > 0 || c == 0 && !after {
+				break
+			}
+			i++
+		}
+		cursor.stack = append(cursor.stack, cursorFrame[T]{node, i})
+		if node.isLeaf {
+			break
+		}
+		err, child := cursor.load(node.C[i])
+		if err != nil {
+			cursor.stack = cursor.stack[:0]
+			return err
+		}
+		node = child
+	}
+	if node.n == cursor.stack[len(cursor.stack)-1].i {
+		cursor.ascendNext()
+	}
+	return nil
+}
+
+// Next moves the cursor to the next key, the cursor becomes invalid after the largest key
+func (cursor *Cursor[T]) Next() error {
+	cursor.rlock()
+	defer cursor.runlock()
+	return cursor.next()
+}
+
+func (cursor *Cursor[T]) next() error {
+	if !cursor.Valid() {
+		return nil
+	}
+	top := &cursor.stack[len(cursor.stack)-1]
+	if !top.node.isLeaf {
+		top.i++
+		err, child := cursor.load(top.node.C[top.i])
+		if err != nil {
+			cursor.stack = cursor.stack[:0]
+			return err
+		}
+		return cursor.descendLeft(child)
+	}
+	top.i++
+	if top.i == top.node.n {
+		cursor.ascendNext()
+	}
+	return nil
+}
+
+// Prev moves the cursor to the previous key, the cursor becomes invalid before the smallest key
+func (cursor *Cursor[T]) Prev() error {
+	cursor.rlock()
+	defer cursor.runlock()
+	return cursor.prev()
+}
+
+func (cursor *Cursor[T]) prev() error {
+	if !cursor.Valid() {
+		return nil
+	}
+	top := &cursor.stack[len(cursor.stack)-1]
+	if !top.node.isLeaf {
+		err, child := cursor.load(top.node.C[top.i])
+		if err != nil {
+			cursor.stack = cursor.stack[:0]
+			return err
+		}
+		return cursor.descendRight(child)
+	}
+	top.i--
+	if top.i < 0 {
+		cursor.ascendPrev()
+	}
+	return nil
+}
+
+func (cursor *Cursor[T]) descendLeft(node *Node[T]) error {
+	for {
+		cursor.stack = append(cursor.stack, cursorFrame[T]{node, 0})
+		if node.isLeaf {
+			return nil
+		}
+		err, child := cursor.load(node.C[0])
+		if err != nil {
+			cursor.stack = cursor.stack[:0]
+			return err
+		}
+		node = child
+	}
+}
+
+func (cursor *Cursor[T]) descendRight(node *Node[T]) error {
+	for {
+		if node.isLeaf {
+			cursor.stack = append(cursor.stack, cursorFrame[T]{node, node.n - 1})
+			return nil
+		}
+		cursor.stack = append(cursor.stack, cursorFrame[T]{node, node.n})
+		err, child := cursor.load(node.C[node.n])
+		if err != nil {
+			cursor.stack = cursor.stack[:0]
+			return err
+		}
+		node = child
+	}
+}
+
+// ascendNext leaves a subtree whose keys have all been visited, moving up to the first ancestor key after it
+func (cursor *Cursor[T]) ascendNext() {
+	cursor.stack = cursor.stack[:len(cursor.stack)-1]
+	for len(cursor.stack) > 0 {
+		top := cursor.stack[len(cursor.stack)-1]
+		if top.i < top.node.n {
+			return
+		}
+		cursor.stack = cursor.stack[:len(cursor.stack)-1]
+	}
+}
+
+// ascendPrev is ascendNext in the other direction
+func (cursor *Cursor[T]) ascendPrev() {
+	cursor.stack = cursor.stack[:len(cursor.stack)-1]
+	for len(cursor.stack) > 0 {
+		top := &cursor.stack[len(cursor.stack)-1]
+		if top.i > 0 {
+			top.i--
+			return
+		}
+		cursor.stack = cursor.stack[:len(cursor.stack)-1]
+	}
+}
+
+// skipEmpty invalidates a cursor positioned in the empty root leaf of an empty tree
+func (cursor *Cursor[T]) skipEmpty() {
+	if cursor.stack[0].node.n == 0 {
+		cursor.stack = cursor.stack[:0]
+	}
+}
+
+// rlock takes the read lock of the tree, a cursor over a snapshot needs none
+func (cursor *Cursor[T]) rlock() {
+	if cursor.snapshot == nil {
+		cursor.btree.mu.RLock()
+	}
+}
+
+func (cursor *Cursor[T]) runlock() {
+	if cursor.snapshot == nil {
+		cursor.btree.mu.RUnlock()
+	}
+}
+
+func (cursor *Cursor[T]) root() (error, *Node[T]) {
+	switch {
+	case cursor.snapshot != nil:
+		return cursor.snapshot.load(cursor.btree.versions.root)
+	case cursor.lastCommit():
+		return nil, cursor.btree.versions.committedRoot(cursor.btree.root)
+	}
+	return nil, cursor.btree.root
+}
+
+func (cursor *Cursor[T]) load(pgno Pgno) (error, *Node[T]) {
+	switch {
+	case cursor.snapshot != nil:
+		return cursor.snapshot.load(pgno)
+	case cursor.lastCommit():
+		return cursor.btree.versions.read(pgno, cursor.btree.versions.lastCommit())
+	}
+	return cursor.btree.nodes.load(pgno)
+}
+
+// lastCommit tells whether the cursor reads the last commit: a transaction it does not belong to is open
+func (cursor *Cursor[T]) lastCommit() bool {
+	return cursor.btree.tx != nil && cursor.btree.tx != cursor.tx
+}
@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestCursorEmptyTree(t *testing.T) {
+	_, btree := NewBTree[int](pageSize(3))
+	cursor := btree.Cursor()
+	if cursor.Valid() {
+		t.Error("Expected new cursor to be invalid")
+	}
+	cursor.First()
+	if cursor.Valid() {
+		t.Error("Expected First to be invalid on empty tree")
+	}
+	cursor.Last()
+	if cursor.Valid() {
+		t.Error("Expected Last to be invalid on empty tree")
+	}
+	cursor.Seek(10)
+	if cursor.Valid() {
+		t.Error("Expected Seek to be invalid on empty tree")
+	}
+}
+
+func TestCursorForwardAndBackward(t *testing.T) {
+	for _, deg := range []int{3, 4, 7} {
+		_, btree := NewBTree[int](pageSize(deg))
+		for i := 1; i <= 200; i++ {
+			btree.Insert((i * 37) % 211)
+		}
+		_, keys := btree.traverse()
+		if len(keys) != 200 {
+			t.Fatalf("Expected traversal of 200 keys, got %d", len(keys))
+		}
+
+		cursor := btree.Cursor()
+		var forward []int
+		for cursor.First(); cursor.Valid(); cursor.Next() {
+			forward = append(forward, cursor.Key())
+		}
+		var backward []int
+		for cursor.Last(); cursor.Valid(); cursor.Prev() {
+			backward = append(backward, cursor.Key())
+		}
+		if len(forward) != len(keys) || len(backward) != len(keys) {
+			t.Fatalf("Expected %d keys, got %d forward and %d backward", len(keys), len(forward), len(backward))
+		}
+		for i := range keys {
+			if forward[i] != keys[i] || backward[len(keys)-1-i] != keys[i] {
+				t.Fatalf("Cursor order differs from traversal at %d", i)
+			}
+		}
+	}
+}
+
+func TestCursorSeek(t *testing.T) {
+	_, btree := NewBTree[int](pageSize(3))
+	for i := 0; i < 100; i++ {
+		btree.Insert(i * 10)
+	}
+
+	cursor := btree.Cursor()
+	cursor.Seek(250)
+	if !cursor.Valid() || cursor.Key() != 250 {
+		t.Errorf("Expected Seek to find existing key 250")
+	}
+	cursor.Seek(251)
+	if !cursor.Valid() || cursor.Key() != 260 {
+		t.Errorf("Expected Seek to stop at next key 260")
+	}
+	cursor.Prev()
+	if !cursor.Valid() || cursor.Key() != 250 {
+		t.Errorf("Expected Prev after Seek to return 250")
+	}
+	cursor.Seek(-5)
+	if !cursor.Valid() || cursor.Key() != 0 {
+		t.Errorf("Expected Seek before all keys to find 0")
+	}
+	cursor.Prev()
+	if cursor.Valid() {
+		t.Error("Expected cursor to be invalid before the smallest key")
+	}
+	cursor.Seek(991)
+	if cursor.Valid() {
+		t.Error("Expected Seek past all keys to be invalid")
+	}
+
+	// every key between two stored keys seeks to the larger one
+	for key := 0; key < 990; key++ {
+		cursor.Seek(key)
+		if !cursor.Valid() || cursor.Key() != (key+9)/10*10 {
+			t.Fatalf("Seek(%d) found wrong key", key)
+		}
+	}
+}
+
+func TestCursorOnPager(t *testing.T) {
+	_, pager := OpenPager(filepath.Join(t.TempDir(), "cursor.db"), 512)
+	defer pager.Close()
+	_, btree := OpenBTree[string](pager, 0)
+	words := []string{"pear", "apple", "fig", "kiwi", "banana", "cherry", "date", "grape", "lemon", "mango"}
+	for i := 0; i < 30; i++ {
+		for _, word := range words {
+			btree.Insert(word + string(rune('a'+i)))
+		}
+	}
+
+	cursor := btree.Cursor()
+	count := 0
+	previous := ""
+	for cursor.First(); cursor.Valid(); cursor.Next() {
+		if cursor.Key() <= previous {
+			t.Fatalf("Keys not in sorted order: %q after %q", cursor.Key(), previous)
+		}
+		previous = cursor.Key()
+		count++
+	}
+	if count != 300 {
+		t.Errorf("Expected 300 keys, got %d", count)
+	}
+}
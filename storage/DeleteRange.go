@@ -0,0 +1,274 @@
+package storage
+
+import "slices"
+
+/*
+DeleteRange goes down the paths to lo and to hi at once. A child between the two paths only holds keys in
+the range, so it is freed with its whole subtree without looking at its keys. Where the paths part, the keys
+between them go and the two children on the paths are glued into one: their keys are joined, and so are
+the last child of the left one and the first child of the right one, down to the leaves.
+
+Every subtree keeps its height, but nodes on the paths may end up with any number of keys, even none and a
+single child. Each parent fixes its child on the way back up: a child with too many keys is split, one with
+too few is joined with a sibling and split again when the two hold more than a node can. A child without
+siblings is fixed one level up, together with its parent. A root left without keys is replaced by its only
+child.
+*/
+
+// DeleteRange removes the keys greater than or equal to lo and less than hi and returns how many there were
+func (btree *BTree[T]) DeleteRange(lo T, hi T) (error, int) {
+	if btree.compare(lo, hi) >= 0 {
+		return nil, 0
+	}
+	removed := 0
+	err := btree.write(nil, func() error {
+		var err error
+		err, removed = btree.deleteRange(btree.root, lo, hi, false, false)
+		if err != nil || removed == 0 {
+			return err
+		}
+		return btree.collapseRoot()
+	})
+	return err, removed
+}
+
+// Truncate removes every key. The nodes below the root are freed, the root stays as an empty leaf.
+func (btree *BTree[T]) Truncate() error {
+	return btree.write(nil, btree.truncate)
+}
+
+func (btree *BTree[T]) truncate() error {
+	root := btree.root
+	for i := 0; !root.isLeaf && i <= root.n; i++ {
+		err, child := btree.nodes.load(root.C[i])
+		if err != nil {
+			return err
+		}
+		if err = btree.freeSubtree(child); err != nil {
+			return err
+		}
+	}
+	root.setContent(nodeContent[T]{C: make([]Pgno, 1), S: make([]int, 1)})
+	root.isLeaf = true
+	btree.height = 1
+	return btree.nodes.save(root)
+}
+
+// deleteRange removes the keys in [lo, hi) from the subtree of node and returns how many there were.
+// noLo tells that every key in the subtree is known to be at least lo, noHi that every key is less than hi.
+// The subtree keeps its height, node is left for its parent to fix and save.
+func (btree *BTree[T]) deleteRange(node *Node[T], lo T, hi T, noLo bool, noHi bool) (error, int) {
+	a, b := 0, node.n
+	for !noLo && a < node.n && btree.compare(node.K[a], lo) < 0 {
+		a++
+	}
+	if !noHi {
+		b = a
+		for b < node.n && btree.compare(node.K[b], hi) < 0 {
+			b++
+		}
+	}
+	removed := b - a
+	if node.isLeaf {
+		if removed > 0 {
+			node.setContent(node.content().cut(a, b))
+		}
+		return nil, removed
+	}
+
+	// the children from a to b hold keys in the range, the ones strictly between a and b nothing else
+	var kept []*Node[T]
+	for i := a; i <= b; i++ {
+		err, child := btree.nodes.load(node.C[i])
+		if err != nil {
+			return err, 0
+		}
+		childNoLo, childNoHi := noLo || i > a, noHi || i < b
+		if childNoLo && childNoHi {
+			removed += node.S[i]
+			if err = btree.freeSubtree(child); err != nil {
+				return err, 0
+			}
+			continue
+		}
+		err, count := btree.deleteRange(child, lo, hi, childNoLo, childNoHi)
+		if err != nil {
+			return err, 0
+		}
+		removed += count
+		kept = append(kept, child)
+	}
+	if removed == 0 {
+		return nil, 0
+	}
+
+	child := kept[0]
+	if len(kept) == 2 {
+		if err := btree.glue(kept[0], kept[1]); err != nil {
+			return err, 0
+		}
+	}
+	node.setContent(node.content().cut(a, b))
+	node.C[a] = child.pgno
+	return btree.fixChild(node, a, child), removed
+}
+
+// glue joins right onto the end of left, two nodes at the same height without a key between them,
+// and frees right. The last child of left and the first child of right are glued the same way.
+// left is left for its parent to fix and save.
+func (btree *BTree[T]) glue(left *Node[T], right *Node[T]) error {
+	seam := left.n
+	l, r := left.content(), right.content()
+	left.setContent(nodeContent[T]{
+		K: slices.Concat(l.K, r.K),
+		V: slices.Concat(l.V, r.V),
+		C: slices.Concat(l.C, r.C[1:]),
+		S: slices.Concat(l.S, r.S[1:]),
+	})
+	if !left.isLeaf {
+		err, seamLeft := btree.nodes.load(l.C[seam])
+		if err != nil {
+			return err
+		}
+		err, seamRight := btree.nodes.load(r.C[0])
+		if err != nil {
+			return err
+		}
+		if err = btree.glue(seamLeft, seamRight); err != nil {
+			return err
+		}
+		if err = btree.fixChild(left, seam, seamLeft); err != nil {
+			return err
+		}
+	}
+	return btree.nodes.free(right)
+}
+
+// fixChild brings child, the child at index i of node that may hold any number of keys, back to the
+// number of keys a node holds and saves it. node is left for its caller to save.
+func (btree *BTree[T]) fixChild(node *Node[T], i int, child *Node[T]) error {
+	if child.n > child.m-1 {
+		return btree.splitWide(node, i, child)
+	}
+	if child.n < child.minKeys() && node.n > 0 {
+		return btree.refill(node, i, child)
+	}
+	// a child without siblings is fixed with node, once node has siblings
+	node.S[i] = child.size()
+	return btree.nodes.save(child)
+}
+
+// splitWide splits child, the child at index i of node holding more than m - 1 keys, into as few nodes
+// as its keys fit in. The keys between the nodes move up into node.
+func (btree *BTree[T]) splitWide(node *Node[T], i int, child *Node[T]) error {
+	content := child.content()
+	k := (child.n + child.m) / child.m
+	pieces := []*Node[T]{child}
+	for len(pieces) < k {
+		err, piece := btree.nodes.alloc(child.isLeaf)
+		if err != nil {
+			return err
+		}
+		pieces = append(pieces, piece)
+	}
+
+	var up nodeContent[T]
+	each, extra := (len(content.K)-(k-1))/k, (len(content.K)-(k-1))%k
+	start := 0
+	for j, piece := range pieces {
+		count := each
+		if j < extra {
+			count++
+		}
+		piece.setContent(content.slice(start, start+count))
+		if err := btree.nodes.save(piece); err != nil {
+			return err
+		}
+		if j+1 < k {
+			up.K = append(up.K, content.K[start+count])
+			up.V = append(up.V, content.V[start+count])
+		}
+		up.C = append(up.C, piece.pgno)
+		up.S = append(up.S, piece.size())
+		start += count + 1
+	}
+
+	parent := node.content()
+	node.setContent(nodeContent[T]{
+		K: slices.Concat(parent.K[:i], up.K, parent.K[i:]),
+		V: slices.Concat(parent.V[:i], up.V, parent.V[i:]),
+		C: slices.Concat(parent.C[:i], up.C, parent.C[i+1:]),
+		S: slices.Concat(parent.S[:i], up.S, parent.S[i+1:]),
+	})
+	return nil
+}
+
+// refill gives child, the child at index i of node holding too few keys, the keys of a sibling: the two are
+// joined through the key of node between them, and split again when that is more than a node holds.
+func (btree *BTree[T]) refill(node *Node[T], i int, child *Node[T]) error {
+	l := i
+	if i > 0 {
+		l = i - 1
+	}
+	err, sibling := btree.nodes.load(node.C[l+1-(i-l)])
+	if err != nil {
+		return err
+	}
+	left, right := child, sibling
+	if l < i {
+		left, right = sibling, child
+	}
+	// a child without keys has a single child that could not be fixed yet, it has siblings once joined
+	lone := -1
+	if child.n == 0 && !child.isLeaf {
+		lone = left.n + 1
+		if left == child {
+			lone = 0
+		}
+	}
+	left.setContent(left.content().join(node.K[l], node.V[l], right.content()))
+	if lone >= 0 {
+		err, grandchild := btree.nodes.load(left.C[lone])
+		if err != nil {
+			return err
+		}
+		if err = btree.fixChild(left, lone, grandchild); err != nil {
+			return err
+		}
+	}
+
+	if left.n <= left.m-1 {
+		node.setContent(node.content().cut(l, l+1))
+		node.S[l] = left.size()
+		if err = btree.nodes.free(right); err != nil {
+			return err
+		}
+		return btree.nodes.save(left)
+	}
+	joined := left.content()
+	half := (len(joined.K) - 1) / 2
+	left.setContent(joined.slice(0, half))
+	right.setContent(joined.slice(half+1, len(joined.K)))
+	node.K[l], node.V[l] = joined.K[half], joined.V[half]
+	node.S[l], node.S[l+1] = left.size(), right.size()
+	return btree.saveAll(left, right)
+}
+
+// collapseRoot moves the only child of a root without keys up into the root page, until the root
+// has keys or is a leaf, and saves the root
+func (btree *BTree[T]) collapseRoot() error {
+	root := btree.root
+	for root.n == 0 && !root.isLeaf {
+		err, child := btree.nodes.load(root.C[0])
+		if err != nil {
+			return err
+		}
+		root.setContent(child.content())
+		root.isLeaf = child.isLeaf
+		if err = btree.nodes.free(child); err != nil {
+			return err
+		}
+		btree.height--
+	}
+	return btree.nodes.save(root)
+}
@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"cmp"
+	"math/rand"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// deleteRangeOf removes the keys in [lo, hi) from the sorted keys, returning the rest and how many went
+func deleteRangeOf(keys []int, lo int, hi int) ([]int, int) {
+	i, _ := slices.BinarySearch(keys, lo)
+	j, _ := slices.BinarySearch(keys, hi)
+	if j < i {
+		j = i
+	}
+	return slices.Delete(keys, i, j), j - i
+}
+
+func TestDeleteRange(t *testing.T) {
+	for _, deg := range []int{3, 4, 5, 8} {
+		r := rand.New(rand.NewSource(int64(deg)))
+		for round := 0; round < 20; round++ {
+			_, btree := NewBTreeFunc[int](pageSize(deg), cmp.Compare[int], DuplicatesMultiset)
+			var keys []int
+			for i := 0; i < 1000; i++ {
+				key := r.Intn(2000)
+				btree.Insert(key)
+				keys = append(keys, key)
+			}
+			slices.Sort(keys)
+			for len(keys) > 0 {
+				lo := r.Intn(2100) - 50
+				hi := lo + r.Intn(400)
+				var want int
+				keys, want = deleteRangeOf(keys, lo, hi)
+				if err, removed := btree.DeleteRange(lo, hi); err != nil || removed != want {
+					t.Fatalf("Expected DeleteRange(%d, %d) in order %d to remove %d keys, got %d (%v)", lo, hi, deg, want, removed, err)
+				}
+				if got := checkTree(t, btree); !slices.Equal(got, keys) {
+					t.Fatalf("Expected %d keys after DeleteRange(%d, %d) in order %d, got %d", len(keys), lo, hi, deg, len(got))
+				}
+				if btree.Len() != len(keys) {
+					t.Fatalf("Expected Len %d, got %d", len(keys), btree.Len())
+				}
+			}
+		}
+	}
+}
+
+func TestDeleteRangeEdges(t *testing.T) {
+	_, btree := NewBTree[int](pageSize(4))
+	btree.BulkLoad(slices.Values(seq(500)), 1)
+	if err, removed := btree.DeleteRange(300, 300); err != nil || removed != 0 {
+		t.Errorf("Expected an empty range to remove nothing, got %d (%v)", removed, err)
+	}
+	if err, removed := btree.DeleteRange(300, 100); err != nil || removed != 0 {
+		t.Errorf("Expected a reversed range to remove nothing, got %d (%v)", removed, err)
+	}
+	if err, removed := btree.DeleteRange(1000, 2000); err != nil || removed != 0 {
+		t.Errorf("Expected a range past the keys to remove nothing, got %d (%v)", removed, err)
+	}
+	if err, removed := btree.DeleteRange(-1, 1000); err != nil || removed != 500 {
+		t.Fatalf("Expected every key to be removed, got %d (%v)", removed, err)
+	}
+	if keys := checkTree(t, btree); len(keys) != 0 || btree.height != 1 {
+		t.Fatalf("Expected an empty tree of height 1, got %d keys and height %d", len(keys), btree.height)
+	}
+	for i := 0; i < 100; i++ {
+		btree.Insert(i)
+	}
+	if keys := checkTree(t, btree); !slices.Equal(keys, seq(100)) {
+		t.Errorf("Expected the tree to be usable after removing everything, got %d keys", len(keys))
+	}
+}
+
+func TestDeleteRangeOnPager(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tree.db")
+	_, pager := OpenPager(path, 512)
+	pager.SetCacheSize(5)
+	_, btree := OpenBTree[int](pager, 0)
+	btree.BulkLoad(slices.Values(seq(3000)), 1)
+	pages := pager.PageCount()
+	if err, removed := btree.DeleteRange(100, 2900); err != nil || removed != 2800 {
+		t.Fatalf("Expected 2800 keys to be removed, got %d (%v)", removed, err)
+	}
+	root := btree.Root()
+	pager.Close()
+
+	_, pager = OpenPager(path, 512)
+	defer pager.Close()
+	_, btree = OpenBTree[int](pager, root)
+	keys, _ := deleteRangeOf(seq(3000), 100, 2900)
+	if got := checkTree(t, btree); !slices.Equal(got, keys) {
+		t.Fatalf("Expected %d keys after reopening, got %d", len(keys), len(got))
+	}
+	// most pages of the removed subtrees are free again
+	if err, free := pager.freePages(); err != nil || len(free) < int(pages)*8/10 {
+		t.Errorf("Expected most of the %d pages to be free, got %d (%v)", pages, len(free), err)
+	}
+}
+
+func TestDeleteRangeKeepsSnapshots(t *testing.T) {
+	_, btree := NewBTree[int](pageSize(4))
+	btree.BulkLoad(slices.Values(seq(1000)), 1)
+	before := btree.Snapshot()
+	defer before.Release()
+	btree.DeleteRange(10, 990)
+	if keys := collectKeys(t, before, before.All()); !slices.Equal(keys, seq(1000)) {
+		t.Errorf("Expected the snapshot to hold every key, got %d keys", len(keys))
+	}
+	if keys := collectKeys(t, btree, btree.All()); len(keys) != 20 {
+		t.Errorf("Expected 20 keys left, got %d", len(keys))
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	_, btree := NewBTree[int](pageSize(3))
+	btree.BulkLoad(slices.Values(seq(1000)), 0.5)
+	if err := btree.Truncate(); err != nil {
+		t.Fatalf("Unexpected error truncating: %v", err)
+	}
+	if keys := checkTree(t, btree); len(keys) != 0 || btree.Len() != 0 {
+		t.Fatalf("Expected an empty tree, got %d keys", len(keys))
+	}
+	for i := 0; i < 100; i++ {
+		btree.Insert(i)
+	}
+	if keys := checkTree(t, btree); !slices.Equal(keys, seq(100)) {
+		t.Errorf("Expected the tree to be usable after truncating, got %d keys", len(keys))
+	}
+}
+
+func TestTruncateOnPager(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tree.db")
+	_, pager := OpenPager(path, 512)
+	pager.SetCacheSize(5)
+	_, btree := OpenBTree[int](pager, 0)
+	btree.BulkLoad(slices.Values(seq(3000)), 1)
+	if err := btree.Truncate(); err != nil {
+		t.Fatalf("Unexpected error truncating: %v", err)
+	}
+	root := btree.Root()
+	pager.Close()
+
+	_, pager = OpenPager(path, 512)
+	defer pager.Close()
+	_, btree = OpenBTree[int](pager, root)
+	if keys := checkTree(t, btree); len(keys) != 0 {
+		t.Fatalf("Expected an empty tree after reopening, got %d keys", len(keys))
+	}
+	// every page but the root is free, the first one holds the header of the pager
+	if err, free := pager.freePages(); err != nil || len(free) != int(pager.PageCount())-2 {
+		t.Errorf("Expected %d free pages, got %d (%v)", pager.PageCount()-2, len(free), err)
+	}
+}
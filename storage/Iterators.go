@@ -0,0 +1,198 @@
+package storage
+
+import (
+	"iter"
+	"sync"
+)
+
+// The iterators below walk the tree with a Cursor, so breaking out of a loop early is free.
+// The iterators of a BTree read a Snapshot taken when the loop starts and released when it ends, so the tree
+// may change inside the loop: the loop goes on over the keys of the last commit before it started. Changes of
+// a transaction that is still open are not seen, except by the iterators of the transaction itself.
+// An error loading a node ends the loop early, Err returns it afterwards.
+
+// All returns every key in ascending order
+func (btree *BTree[T]) All() iter.Seq[T] {
+	return btree.snapshotKeys(func(snapshot *Snapshot[T]) iter.Seq2[T, error] { return allKeys(snapshot.Cursor) })
+}
+
+// Ascend returns the keys greater than or equal to from in ascending order
+func (btree *BTree[T]) Ascend(from T) iter.Seq[T] {
+	return btree.snapshotKeys(func(snapshot *Snapshot[T]) iter.Seq2[T, error] { return ascendKeys(snapshot.Cursor, from) })
+}
+
+// Descend returns the keys less than or equal to from in descending order
+func (btree *BTree[T]) Descend(from T) iter.Seq[T] {
+	return btree.snapshotKeys(func(snapshot *Snapshot[T]) iter.Seq2[T, error] { return descendKeys(snapshot.Cursor, from) })
+}
+
+// Range returns the keys greater than or equal to lo and less than hi in ascending order
+func (btree *BTree[T]) Range(lo T, hi T) iter.Seq[T] {
+	return btree.snapshotKeys(func(snapshot *Snapshot[T]) iter.Seq2[T, error] { return rangeKeys(snapshot.Cursor, lo, hi) })
+}
+
+// Err returns the error that ended the last loop over an iterator of the tree early, nil when it ran to
+// the end or was broken off. Loops in other goroutines share it; a Snapshot of its own, or a Cursor, tells
+// a goroutine about the errors of its own loops only.
+func (btree *BTree[T]) Err() error {
+	return btree.iterations.get()
+}
+
+// The iterators of a transaction walk the tree as the transaction changed it. The transaction may change the
+// tree inside the loop, the loop then goes on after the last key it got, over the tree as it is now.
+
+func (tx *Tx[T]) All() iter.Seq[T] {
+	return tx.keys(func(cursor *Cursor[T]) error { return cursor.First() }, false, nil)
+}
+
+func (tx *Tx[T]) Ascend(from T) iter.Seq[T] {
+	return tx.keys(func(cursor *Cursor[T]) error { return cursor.Seek(from) }, false, nil)
+}
+
+func (tx *Tx[T]) Descend(from T) iter.Seq[T] {
+	return tx.keys(func(cursor *Cursor[T]) error { return cursor.seekFloor(from) }, true, nil)
+}
+
+func (tx *Tx[T]) Range(lo T, hi T) iter.Seq[T] {
+	past := func(key T) bool { return tx.btree.compare(key, hi) >= 0 }
+	return tx.keys(func(cursor *Cursor[T]) error { return cursor.Seek(lo) }, false, past)
+}
+
+// Err returns the error that ended the last loop over an iterator of the transaction early, see BTree.Err
+func (tx *Tx[T]) Err() error {
+	return tx.iterations.get()
+}
+
+// keys walks the keys from where position leaves a cursor of the transaction, moving it backward when backward
+// is set, until past says a key is beyond the end. When the transaction changed the tree inside the loop,
+// the cursor is positioned again past as many copies of the last key as the loop got.
+func (tx *Tx[T]) keys(position func(cursor *Cursor[T]) error, backward bool, past func(key T) bool) iter.Seq[T] {
+	walk := func(yield func(T, error) bool) {
+		cursor := tx.Cursor()
+		changes := tx.changeCount()
+		err := position(cursor)
+		var last T
+		seen := 0 // copies of last the loop got
+		for err == nil && cursor.Valid() {
+			key := cursor.Key()
+			if past != nil && past(key) || !yield(key, nil) {
+				return
+			}
+			if seen == 0 || tx.btree.compare(key, last) != 0 {
+				last, seen = key, 0
+			}
+			seen++
+			if count := tx.changeCount(); count != changes {
+				changes = count
+				err = cursor.pass(last, seen, backward)
+			} else if backward {
+				err = cursor.Prev()
+			} else {
+				err = cursor.Next()
+			}
+		}
+		if err != nil {
+			yield(defaultValue[T](), err)
+		}
+	}
+	return checkedKeys(walk, &tx.iterations)
+}
+
+// snapshotKeys returns the keys keys returns from a snapshot that lives as long as the loop
+func (btree *BTree[T]) snapshotKeys(keys func(snapshot *Snapshot[T]) iter.Seq2[T, error]) iter.Seq[T] {
+	return func(yield func(T) bool) {
+		snapshot := btree.Snapshot()
+		defer snapshot.Release()
+		checkedKeys(keys(snapshot), &btree.iterations)(yield)
+	}
+}
+
+// iterationErr keeps the error that ended the last loop over an iterator early
+type iterationErr struct {
+	mu  sync.Mutex
+	err error
+}
+
+func (last *iterationErr) get() error {
+	last.mu.Lock()
+	defer last.mu.Unlock()
+	return last.err
+}
+
+func (last *iterationErr) set(err error) {
+	last.mu.Lock()
+	defer last.mu.Unlock()
+	last.err = err
+}
+
+// checkedKeys yields the keys of keys until it yields an error, which is kept in last
+func checkedKeys[T any](keys iter.Seq2[T, error], last *iterationErr) iter.Seq[T] {
+	return func(yield func(T) bool) {
+		var err error
+		for key, keyErr := range keys {
+			if err = keyErr; err != nil || !yield(key) {
+				break
+			}
+		}
+		last.set(err)
+	}
+}
+
+// The functions below walk the keys of the cursors newCursor returns. Every key comes with a nil error,
+// an error loading a node is yielded once with the zero key and ends the walk.
+
+func allKeys[T any](newCursor func() *Cursor[T]) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		cursor := newCursor()
+		walkKeys(cursor, cursor.First(), cursor.Next, nil, yield)
+	}
+}
+
+func ascendKeys[T any](newCursor func() *Cursor[T], from T) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		cursor := newCursor()
+		walkKeys(cursor, cursor.Seek(from), cursor.Next, nil, yield)
+	}
+}
+
+func descendKeys[T any](newCursor func() *Cursor[T], from T) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		cursor := newCursor()
+		walkKeys(cursor, cursor.seekFloor(from), cursor.Prev, nil, yield)
+	}
+}
+
+func rangeKeys[T any](newCursor func() *Cursor[T], lo T, hi T) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		cursor := newCursor()
+		past := func(key T) bool { return cursor.btree.compare(key, hi) >= 0 }
+		walkKeys(cursor, cursor.Seek(lo), cursor.Next, past, yield)
+	}
+}
+
+// walkKeys yields the keys from where the positioning that returned err left cursor, moving it with step,
+// until past says a key is beyond the end. A failed positioning or step yields its error and ends the walk.
+func walkKeys[T any](cursor *Cursor[T], err error, step func() error, past func(key T) bool, yield func(T, error) bool) {
+	for ; err == nil && cursor.Valid(); err = step() {
+		key := cursor.Key()
+		if past != nil && past(key) || !yield(key, nil) {
+			return
+		}
+	}
+	if err != nil {
+		yield(defaultValue[T](), err)
+	}
+}
+
+// countKey returns the number of copies of key, without taking the lock of the tree
+func countKey[T any](cursor *Cursor[T], key T) (error, int) {
+	count := 0
+	err := cursor.seek(key, false)
+	for ; err == nil && cursor.Valid() && cursor.btree.compare(cursor.key(), key) == 0; err = cursor.next() {
+		count++
+	}
+	if err != nil {
+		return err, 0
+	}
+	return nil, count
+}
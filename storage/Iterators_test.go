@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"errors"
+	"iter"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// collectKeys returns the keys of seq, an iterator of source, reporting the error it ends with
+func collectKeys[T any](t *testing.T, source interface{ Err() error }, seq iter.Seq[T]) []T {
+	t.Helper()
+	keys := slices.Collect(seq)
+	if err := source.Err(); err != nil {
+		t.Errorf("Unexpected error iterating: %v", err)
+	}
+	return keys
+}
+
+func TestIterators(t *testing.T) {
+	_, btree := NewBTree[int](pageSize(3))
+	for i := 10; i >= 1; i-- {
+		btree.Insert(i * 10)
+	}
+
+	if keys := collectKeys(t, btree, btree.All()); !slices.Equal(keys, []int{10, 20, 30, 40, 50, 60, 70, 80, 90, 100}) {
+		t.Errorf("Unexpected keys from All: %v", keys)
+	}
+	if keys := collectKeys(t, btree, btree.Ascend(75)); !slices.Equal(keys, []int{80, 90, 100}) {
+		t.Errorf("Unexpected keys from Ascend: %v", keys)
+	}
+	if keys := collectKeys(t, btree, btree.Ascend(80)); !slices.Equal(keys, []int{80, 90, 100}) {
+		t.Errorf("Expected Ascend to include its start key: %v", keys)
+	}
+	if keys := collectKeys(t, btree, btree.Descend(35)); !slices.Equal(keys, []int{30, 20, 10}) {
+		t.Errorf("Unexpected keys from Descend: %v", keys)
+	}
+	if keys := collectKeys(t, btree, btree.Descend(30)); !slices.Equal(keys, []int{30, 20, 10}) {
+		t.Errorf("Expected Descend to include its start key: %v", keys)
+	}
+	if keys := collectKeys(t, btree, btree.Descend(500)); len(keys) != 10 || keys[0] != 100 {
+		t.Errorf("Expected Descend past the largest key to return all keys: %v", keys)
+	}
+	if keys := collectKeys(t, btree, btree.Range(30, 60)); !slices.Equal(keys, []int{30, 40, 50}) {
+		t.Errorf("Unexpected keys from Range: %v", keys)
+	}
+	if keys := collectKeys(t, btree, btree.Range(60, 30)); len(keys) != 0 {
+		t.Errorf("Expected empty Range, got %v", keys)
+	}
+}
+
+func TestIteratorBreak(t *testing.T) {
+	_, btree := NewBTree[int](pageSize(4))
+	for i := 0; i < 1000; i++ {
+		btree.Insert(i)
+	}
+
+	var keys []int
+	for key := range btree.Range(100, 900) {
+		if key == 105 {
+			break
+		}
+		keys = append(keys, key)
+	}
+	if !slices.Equal(keys, []int{100, 101, 102, 103, 104}) {
+		t.Errorf("Unexpected keys before break: %v", keys)
+	}
+
+	count := 0
+	for range btree.Descend(999) {
+		count++
+		if count == 3 {
+			break
+		}
+	}
+	if count != 3 {
+		t.Errorf("Expected loop to stop after 3 keys, got %d", count)
+	}
+}
+
+func TestIteratorsEmptyTree(t *testing.T) {
+	_, btree := NewBTree[string](pageSize(3))
+	for range btree.All() {
+		t.Error("Expected no keys in empty tree")
+	}
+	for range btree.Descend("z") {
+		t.Error("Expected no keys in empty tree")
+	}
+}
+
+func TestIteratorsWhileChanging(t *testing.T) {
+	_, btree := NewBTree[int](pageSize(3))
+	for i := 0; i < 500; i++ {
+		btree.Insert(i)
+	}
+	// the loop goes on over the keys as they were when it started
+	var keys []int
+	for key := range btree.All() {
+		keys = append(keys, key)
+		btree.Delete(key)
+		btree.Insert(key + 1000)
+	}
+	if !slices.Equal(keys, seq(500)) {
+		t.Errorf("Expected the 500 keys the loop started with, got %d keys", len(keys))
+	}
+	if keys = collectKeys(t, btree, btree.Range(0, 2000)); len(keys) != 500 || keys[0] != 1000 {
+		t.Errorf("Expected the keys inserted in the loop, got %d keys", len(keys))
+	}
+	if got := btree.versions.old; len(got) != 0 {
+		t.Errorf("Expected the snapshot of the loop to be released, %d old versions are kept", len(got))
+	}
+}
+
+func TestIteratorsYieldErrors(t *testing.T) {
+	_, pager := OpenPager(filepath.Join(t.TempDir(), "tree.db"), 512)
+	defer pager.Close()
+	_, btree := OpenBTree[int](pager, 0)
+	for i := 0; i < 500; i++ {
+		btree.Insert(i)
+	}
+	// a page of the tree that does not hold a node anymore
+	garbage := make([]byte, 512)
+	garbage[0] = 0xff
+	pager.Write(btree.root.C[1], garbage)
+
+	count := 0
+	for range btree.All() {
+		count++
+	}
+	if err := btree.Err(); err == nil || count == 0 || count >= 500 {
+		t.Errorf("Expected an error after some keys, got %v after %d keys", err, count)
+	}
+	for range btree.Descend(1000) {
+	}
+	if err := btree.Err(); err == nil || errors.Is(err, ErrSnapshotReleased) {
+		t.Errorf("Expected the error loading the corrupt node, got %v", err)
+	}
+	for range btree.Range(0, 10) {
+	}
+	if err := btree.Err(); err != nil {
+		t.Errorf("Expected a loop that ran to the end to clear the error, got %v", err)
+	}
+	if err, _ := btree.Count(btree.root.K[0] + 1); err == nil {
+		t.Error("Expected Count to fail on the corrupt node")
+	}
+}
@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"os"
+)
+
+/*
+Changes reach the database file through a rollback journal, like SQLite's default journal mode. Before a page
+changes for the first time since the last Sync, its original content is appended to the journal, a file
+next to the database named path + "-journal". Sync then commits in three steps:
+
+ 1. the journal is flushed to stable storage
+ 2. the changed pages are written to the database file, which is flushed as well
+ 3. the journal is deleted, which is the moment the changes are committed
+
+A dirty page that the cache evicts before Sync waits for step 1 as well, so the database file never holds
+a changed page whose original is not safe in the journal. A journal that is still there when the database
+is opened is hot: the process died before it committed, and OpenPager plays it back, copying the original
+pages into the database and cutting the file back to its original size. So either all changes between two
+Syncs reach the database, or none do.
+
+The journal starts with a header:
+
+	offset  size  description
+	0       8     magic string "SDBCjnl\000"
+	8       4     page size in bytes (big-endian)
+	12      4     number of pages in the database before the changes (big-endian)
+
+followed by a record per page:
+
+	offset      size      description
+	0           4         page number (big-endian)
+	4           pageSize  original content of the page
+	4+pageSize  4         CRC-32 of the page number and the content (big-endian)
+
+A record with a wrong checksum was torn by the crash. It was never flushed, so neither its page nor the
+pages of later records were written to the database, and playback stops there.
+*/
+
+const journalMagic = "SDBCjnl\x00"
+
+const journalHeaderSize = 16
+
+type journal struct {
+	file     *os.File
+	pageSize int
+	nPages   Pgno          // number of pages in the database when the journal was started
+	pages    map[Pgno]bool // pages whose original content is in the journal
+	size     int64
+	synced   bool
+}
+
+// createJournal starts the journal at path for a database of nPages pages, replacing a journal that is there
+func createJournal(path string, pageSize int, nPages Pgno) (error, *journal) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err, nil
+	}
+	header := make([]byte, journalHeaderSize)
+	copy(header, journalMagic)
+	binary.BigEndian.PutUint32(header[8:], uint32(pageSize))
+	binary.BigEndian.PutUint32(header[12:], uint32(nPages))
+	if _, err = file.Write(header); err != nil {
+		file.Close()
+		os.Remove(path)
+		return err, nil
+	}
+	return nil, &journal{
+		file:     file,
+		pageSize: pageSize,
+		nPages:   nPages,
+		pages:    make(map[Pgno]bool),
+		size:     journalHeaderSize,
+	}
+}
+
+// needs tells whether the original content of page pgno still has to be saved. Pages appended after the
+// journal was started have no original content, the file is cut off before them on playback.
+func (journal *journal) needs(pgno Pgno) bool {
+	return pgno <= journal.nPages && !journal.pages[pgno]
+}
+
+// add appends the original content of page pgno
+func (journal *journal) add(pgno Pgno, data []byte) error {
+	record := make([]byte, 4, 8+journal.pageSize)
+	binary.BigEndian.PutUint32(record, uint32(pgno))
+	record = append(record, data...)
+	record = binary.BigEndian.AppendUint32(record, crc32.ChecksumIEEE(record))
+	if _, err := journal.file.WriteAt(record, journal.size); err != nil {
+		return err
+	}
+	journal.size += int64(len(record))
+	journal.pages[pgno] = true
+	journal.synced = false
+	return nil
+}
+
+// sync flushes the journal to stable storage, unless nothing was added since the last time
+func (journal *journal) sync() error {
+	if journal.synced {
+		return nil
+	}
+	if err := journal.file.Sync(); err != nil {
+		return err
+	}
+	journal.synced = true
+	return nil
+}
+
+// delete removes the journal, which commits the changes it was started for
+func (journal *journal) delete() error {
+	err := journal.file.Close()
+	if removeErr := os.Remove(journal.file.Name()); removeErr != nil {
+		return removeErr
+	}
+	return err
+}
+
+// playbackJournal restores the database file from the hot journal at path, if there is one, and deletes it
+func playbackJournal(path string, file *os.File) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if len(data) < journalHeaderSize || string(data[:len(journalMagic)]) != journalMagic {
+		// the crash came before the header was flushed, so the database file is still untouched
+		return os.Remove(path)
+	}
+	pageSize := int(binary.BigEndian.Uint32(data[8:]))
+	nPages := Pgno(binary.BigEndian.Uint32(data[12:]))
+	if !validPageSize(pageSize) {
+		return errors.New("journal is corrupt: invalid page size")
+	}
+
+	for record := data[journalHeaderSize:]; len(record) >= 8+pageSize; record = record[8+pageSize:] {
+		checksum := binary.BigEndian.Uint32(record[4+pageSize:])
+		if crc32.ChecksumIEEE(record[:4+pageSize]) != checksum {
+			break
+		}
+		pgno := Pgno(binary.BigEndian.Uint32(record))
+		if pgno == 0 || pgno > nPages {
+			return errors.New("journal is corrupt: page number out of range")
+		}
+		if _, err = file.WriteAt(record[4:4+pageSize], int64(pgno-1)*int64(pageSize)); err != nil {
+			return err
+		}
+	}
+	if err = file.Truncate(int64(nPages) * int64(pageSize)); err != nil {
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
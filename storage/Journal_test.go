@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// crash drops pager the way a dying process would: nothing more reaches the files and the journal stays
+func crash(pager *Pager) {
+	if pager.journal != nil {
+		pager.journal.file.Close()
+	}
+	pager.file.Close()
+}
+
+func TestSyncDeletesJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	_, pager := OpenPager(path, 512)
+	defer pager.Close()
+	if _, err := os.Stat(journalPath(path)); !os.IsNotExist(err) {
+		t.Fatal("Expected no journal for a new database")
+	}
+
+	_, pgno := pager.Allocate()
+	if _, err := os.Stat(journalPath(path)); err != nil {
+		t.Fatalf("Expected a journal once the database changes: %v", err)
+	}
+	pager.Write(pgno, make([]byte, 512))
+	if err := pager.Sync(); err != nil {
+		t.Fatalf("Unexpected error syncing: %v", err)
+	}
+	if _, err := os.Stat(journalPath(path)); !os.IsNotExist(err) {
+		t.Error("Expected Sync to delete the journal")
+	}
+}
+
+func TestHotJournalRollsBackBTree(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tree.db")
+	_, pager := OpenPager(path, 512)
+	_, btree := OpenBTree[int](pager, 0)
+	for i := 0; i < 500; i++ {
+		btree.Insert(i)
+	}
+	root := btree.Root()
+	if err := pager.Sync(); err != nil {
+		t.Fatalf("Unexpected error syncing: %v", err)
+	}
+	info, _ := os.Stat(path)
+	committedSize := info.Size()
+
+	// splits and merges that are written back halfway, then the process dies
+	pager.SetCacheSize(3)
+	for i := 500; i < 1500; i++ {
+		btree.Insert(i)
+	}
+	for i := 0; i < 400; i++ {
+		btree.Delete(i)
+	}
+	if pager.CacheStats().WriteBacks == 0 {
+		t.Fatal("Expected changed pages to reach the file before the crash")
+	}
+	crash(pager)
+
+	err, pager := OpenPager(path, 512)
+	if err != nil {
+		t.Fatalf("Unexpected error recovering: %v", err)
+	}
+	defer pager.Close()
+	if _, err = os.Stat(journalPath(path)); !os.IsNotExist(err) {
+		t.Error("Expected the hot journal to be deleted after playback")
+	}
+	info, _ = os.Stat(path)
+	if info.Size() != committedSize {
+		t.Errorf("Expected the file to be cut back to %d bytes, got %d", committedSize, info.Size())
+	}
+	err, btree = OpenBTree[int](pager, root)
+	if err != nil {
+		t.Fatalf("Unexpected error opening tree: %v", err)
+	}
+	keys := checkTree(t, btree)
+	if len(keys) != 500 || keys[0] != 0 || keys[499] != 499 {
+		t.Errorf("Expected the 500 committed keys, got %d", len(keys))
+	}
+}
+
+func TestHotJournalRollsBackFreelist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	_, pager := OpenPager(path, 512)
+	for i := 0; i < 10; i++ {
+		pager.Allocate()
+	}
+	pager.Free(5)
+	pager.Free(7)
+	pager.Sync()
+
+	pager.Allocate()
+	pager.Allocate()
+	pager.Allocate()
+	pager.Free(3)
+	pager.Sync()
+	pager.Free(9)
+	crash(pager)
+
+	_, pager = OpenPager(path, 512)
+	defer pager.Close()
+	if pager.PageCount() != 12 || pager.FreePageCount() != 1 {
+		t.Errorf("Expected 12 pages with 1 free, got %d pages with %d free", pager.PageCount(), pager.FreePageCount())
+	}
+	if _, pgno := pager.Allocate(); pgno != 3 {
+		t.Errorf("Expected page 3 from the freelist, got %d", pgno)
+	}
+}
+
+func TestTornJournalHeaderIsIgnored(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	_, pager := OpenPager(path, 512)
+	pager.Allocate()
+	pager.Close()
+	os.WriteFile(journalPath(path), []byte("SDBC"), 0644)
+
+	err, pager := OpenPager(path, 512)
+	if err != nil {
+		t.Fatalf("Unexpected error opening database: %v", err)
+	}
+	defer pager.Close()
+	if pager.PageCount() != 2 {
+		t.Errorf("Expected 2 pages, got %d", pager.PageCount())
+	}
+	if _, err = os.Stat(journalPath(path)); !os.IsNotExist(err) {
+		t.Error("Expected the torn journal to be deleted")
+	}
+}
@@ -0,0 +1,224 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"golang.org/x/exp/constraints"
+	"math"
+	"reflect"
+)
+
+/*
+KVTree maps keys to values. Every key of the underlying BTree carries its value as payload, encoded as:
+
+	value                      payload
+	bool                       1 byte, 0 or 1
+	int, int8, ..., int64      8-byte two's complement integer
+	uint, uint8, ..., uintptr  8-byte unsigned integer
+	float32, float64           8-byte IEEE 754 binary64
+	string, []byte             the bytes as they are
+
+Integers are big-endian. Values of other types have to be encoded to []byte by the caller.
+*/
+
+type KVTree[K constraints.Ordered, V any] struct {
+	tree *BTree[K]
+}
+
+func NewKVTree[K constraints.Ordered, V any](pageSize int) (error, *KVTree[K, V]) {
+	if err := checkValueType[V](); err != nil {
+		return err, nil
+	}
+	err, tree := NewBTree[K](pageSize)
+	if err != nil {
+		return err, nil
+	}
+	return nil, &KVTree[K, V]{tree: tree}
+}
+
+// OpenKVTree opens the tree whose root node is stored in page root of pager, see OpenBTree
+func OpenKVTree[K constraints.Ordered, V any](pager *Pager, root Pgno) (error, *KVTree[K, V]) {
+	if err := checkValueType[V](); err != nil {
+		return err, nil
+	}
+	err, tree := OpenBTree[K](pager, root)
+	if err != nil {
+		return err, nil
+	}
+	return nil, &KVTree[K, V]{tree: tree}
+}
+
+func (kv *KVTree[K, V]) Root() Pgno {
+	return kv.tree.Root()
+}
+
+// Put sets the value of key, replacing the old value when key already exists
+func (kv *KVTree[K, V]) Put(key K, value V) error {
+	return kv.put(nil, key, value)
+}
+
+func (kv *KVTree[K, V]) put(tx *Tx[K], key K, value V) error {
+	return kv.tree.write(tx, func() error { return kv.tree.put(key, encodeValue(value)) })
+}
+
+func (kv *KVTree[K, V]) Get(key K) (V, bool) {
+	return kv.get(nil, key)
+}
+
+// get is Get for the reads of tx, see BTree.reader
+func (kv *KVTree[K, V]) get(tx *Tx[K], key K) (V, bool) {
+	kv.tree.mu.RLock()
+	defer kv.tree.mu.RUnlock()
+	err, payload := kv.tree.reader(tx).get(key)
+	if err != nil {
+		return defaultValue[V](), false
+	}
+	err, value := decodeValue[V](payload)
+	if err != nil {
+		return defaultValue[V](), false
+	}
+	return value, true
+}
+
+// Delete removes key and returns the value it had
+func (kv *KVTree[K, V]) Delete(key K) (error, V) {
+	return kv.delete(nil, key)
+}
+
+func (kv *KVTree[K, V]) delete(tx *Tx[K], key K) (error, V) {
+	var payload []byte
+	err := kv.tree.write(tx, func() error {
+		var err error
+		err, payload = kv.tree.remove(key)
+		return err
+	})
+	if err != nil {
+		return err, defaultValue[V]()
+	}
+	return decodeValue[V](payload)
+}
+
+func (kv *KVTree[K, V]) Exists(key K) bool {
+	return kv.tree.Exists(key)
+}
+
+// KVTx is a transaction on a KVTree, it works like a Tx
+type KVTx[K constraints.Ordered, V any] struct {
+	kv *KVTree[K, V]
+	tx *Tx[K]
+}
+
+// Begin starts a transaction, see BTree.Begin
+func (kv *KVTree[K, V]) Begin() (error, *KVTx[K, V]) {
+	err, tx := kv.tree.Begin()
+	if err != nil {
+		return err, nil
+	}
+	return nil, &KVTx[K, V]{kv: kv, tx: tx}
+}
+
+func (tx *KVTx[K, V]) Put(key K, value V) error {
+	return tx.kv.put(tx.tx, key, value)
+}
+
+func (tx *KVTx[K, V]) Delete(key K) (error, V) {
+	return tx.kv.delete(tx.tx, key)
+}
+
+// Get returns the value of key with the changes of the transaction
+func (tx *KVTx[K, V]) Get(key K) (V, bool) {
+	return tx.kv.get(tx.tx, key)
+}
+
+func (tx *KVTx[K, V]) Exists(key K) bool {
+	return tx.tx.Exists(key)
+}
+
+func (tx *KVTx[K, V]) Commit() error {
+	return tx.tx.Commit()
+}
+
+func (tx *KVTx[K, V]) Rollback() error {
+	return tx.tx.Rollback()
+}
+
+func (tx *KVTx[K, V]) Savepoint(name string) error {
+	return tx.tx.Savepoint(name)
+}
+
+func (tx *KVTx[K, V]) Release(name string) error {
+	return tx.tx.Release(name)
+}
+
+func (tx *KVTx[K, V]) RollbackTo(name string) error {
+	return tx.tx.RollbackTo(name)
+}
+
+func checkValueType[V any]() error {
+	valueType := reflect.TypeFor[V]()
+	switch valueType.Kind() {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return nil
+	case reflect.Slice:
+		if valueType.Elem().Kind() == reflect.Uint8 {
+			return nil
+		}
+	}
+	return errors.New("unsupported value type: " + valueType.String())
+}
+
+func encodeValue[V any](value V) []byte {
+	v := reflect.ValueOf(&value).Elem()
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return []byte{1}
+		}
+		return []byte{0}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return binary.BigEndian.AppendUint64(nil, uint64(v.Int()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return binary.BigEndian.AppendUint64(nil, v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return binary.BigEndian.AppendUint64(nil, math.Float64bits(v.Float()))
+	case reflect.String:
+		return []byte(v.String())
+	default:
+		return append([]byte(nil), v.Bytes()...)
+	}
+}
+
+func decodeValue[V any](payload []byte) (error, V) {
+	var value V
+	v := reflect.ValueOf(&value).Elem()
+	switch v.Kind() {
+	case reflect.Bool:
+		if len(payload) != 1 {
+			return errors.New("payload does not hold a bool"), value
+		}
+		v.SetBool(payload[0] != 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if len(payload) != 8 {
+			return errors.New("payload does not hold an integer"), value
+		}
+		v.SetInt(int64(binary.BigEndian.Uint64(payload)))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if len(payload) != 8 {
+			return errors.New("payload does not hold an integer"), value
+		}
+		v.SetUint(binary.BigEndian.Uint64(payload))
+	case reflect.Float32, reflect.Float64:
+		if len(payload) != 8 {
+			return errors.New("payload does not hold a float"), value
+		}
+		v.SetFloat(math.Float64frombits(binary.BigEndian.Uint64(payload)))
+	case reflect.String:
+		v.SetString(string(payload))
+	default:
+		v.SetBytes(append([]byte(nil), payload...))
+	}
+	return nil, value
+}
@@ -0,0 +1,201 @@
+package storage
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestKVTreePutGet(t *testing.T) {
+	_, kv := NewKVTree[int, string](pageSize(3))
+
+	if _, ok := kv.Get(1); ok {
+		t.Error("Expected no value in empty tree")
+	}
+	for i := 0; i < 100; i++ {
+		if err := kv.Put(i, "value "+strconv.Itoa(i)); err != nil {
+			t.Fatalf("Unexpected error putting key %d: %v", i, err)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		value, ok := kv.Get(i)
+		if !ok || value != "value "+strconv.Itoa(i) {
+			t.Errorf("Expected value for key %d, got %q", i, value)
+		}
+	}
+
+	// Put on an existing key replaces its value
+	kv.Put(42, "answer")
+	if value, _ := kv.Get(42); value != "answer" {
+		t.Errorf("Expected replaced value, got %q", value)
+	}
+	if keys := checkTree(t, kv.tree); len(keys) != 100 {
+		t.Errorf("Put of an existing key added a key")
+	}
+}
+
+func TestKVTreeDeleteReturnsValue(t *testing.T) {
+	_, kv := NewKVTree[int, int](pageSize(4))
+	for i := 0; i < 200; i++ {
+		kv.Put(i, i*i)
+	}
+
+	// deleting keys of internal nodes moves values of predecessors up
+	for i := 0; i < 200; i += 3 {
+		err, value := kv.Delete(i)
+		if err != nil {
+			t.Fatalf("Unexpected error deleting key %d: %v", i, err)
+		}
+		if value != i*i {
+			t.Errorf("Expected deleted value %d, got %d", i*i, value)
+		}
+	}
+	checkTree(t, kv.tree)
+	for i := 0; i < 200; i++ {
+		value, ok := kv.Get(i)
+		if ok != (i%3 != 0) || (ok && value != i*i) {
+			t.Errorf("Unexpected value %d, %v for key %d", value, ok, i)
+		}
+	}
+
+	if err, _ := kv.Delete(0); err == nil {
+		t.Error("Expected error deleting a missing key")
+	}
+}
+
+func TestKVTreeValueTypes(t *testing.T) {
+	if err, _ := NewKVTree[int, map[int]int](pageSize(3)); err == nil {
+		t.Error("Expected error for unsupported value type")
+	}
+
+	_, bytesTree := NewKVTree[string, []byte](pageSize(3))
+	bytesTree.Put("a", []byte{1, 2, 3})
+	if value, _ := bytesTree.Get("a"); len(value) != 3 || value[2] != 3 {
+		t.Errorf("Unexpected []byte value %v", value)
+	}
+
+	_, floatTree := NewKVTree[string, float32](pageSize(3))
+	floatTree.Put("pi", 3.25)
+	if value, _ := floatTree.Get("pi"); value != 3.25 {
+		t.Errorf("Unexpected float value %v", value)
+	}
+
+	_, boolTree := NewKVTree[uint8, bool](pageSize(3))
+	boolTree.Put(1, true)
+	boolTree.Put(2, false)
+	if value, ok := boolTree.Get(1); !ok || !value {
+		t.Error("Expected true value")
+	}
+	if value, ok := boolTree.Get(2); !ok || value {
+		t.Error("Expected false value")
+	}
+}
+
+func TestKVTreeOnPager(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kv.db")
+	_, pager := OpenPager(path, 512)
+	_, kv := OpenKVTree[string, string](pager, 0)
+	for i := 0; i < 300; i++ {
+		if err := kv.Put("key"+strconv.Itoa(i), "value"+strconv.Itoa(i)); err != nil {
+			t.Fatalf("Unexpected error putting key %d: %v", i, err)
+		}
+	}
+	root := kv.Root()
+	pager.Close()
+
+	_, pager = OpenPager(path, 512)
+	defer pager.Close()
+	err, kv := OpenKVTree[string, string](pager, root)
+	if err != nil {
+		t.Fatalf("Unexpected error opening tree: %v", err)
+	}
+	for i := 0; i < 300; i++ {
+		if value, ok := kv.Get("key" + strconv.Itoa(i)); !ok || value != "value"+strconv.Itoa(i) {
+			t.Errorf("Expected value for key %d after reopening, got %q", i, value)
+		}
+	}
+}
+
+func TestKVTreeTx(t *testing.T) {
+	_, pager := OpenPager(filepath.Join(t.TempDir(), "kv.db"), 512)
+	defer pager.Close()
+	_, onPager := OpenKVTree[int, string](pager, 0)
+	_, inMemory := NewKVTree[int, string](pageSize(4))
+
+	for _, kv := range []*KVTree[int, string]{inMemory, onPager} {
+		for i := 0; i < 100; i++ {
+			kv.Put(i, "old")
+		}
+		_, tx := kv.Begin()
+		for i := 0; i < 200; i++ {
+			tx.Put(i, "new")
+		}
+		tx.Savepoint("a")
+		if err, value := tx.Delete(5); err != nil || value != "new" {
+			t.Errorf("Expected to delete the new value of 5, got %q (%v)", value, err)
+		}
+		tx.RollbackTo("a")
+		if value, _ := tx.Get(5); value != "new" || !tx.Exists(150) {
+			t.Errorf("Expected the transaction to read its own values, got %q for 5", value)
+		}
+		if value, _ := kv.Get(5); value != "old" || kv.Exists(150) {
+			t.Errorf("Expected the committed values outside of the transaction, got %q for 5", value)
+		}
+		if err := tx.Rollback(); err != nil {
+			t.Fatalf("Unexpected error rolling back: %v", err)
+		}
+		if err := tx.Put(1, "late"); err != ErrTxDone {
+			t.Errorf("Expected ErrTxDone after rollback, got %v", err)
+		}
+		for i := 0; i < 200; i++ {
+			if value, ok := kv.Get(i); i < 100 && value != "old" || i >= 100 && ok {
+				t.Fatalf("Expected the old value of %d after rollback, got %q", i, value)
+			}
+		}
+
+		_, tx = kv.Begin()
+		tx.Put(7, "committed")
+		tx.Delete(8)
+		tx.Commit()
+		if value, _ := kv.Get(7); value != "committed" || kv.Exists(8) {
+			t.Errorf("Expected the committed changes, got %q for 7", value)
+		}
+	}
+}
+
+func TestKVTreeLargeValuesOnPager(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kv.db")
+	_, pager := OpenPager(path, 512)
+	_, kv := OpenKVTree[int, string](pager, 0)
+	value := func(key int, round int) string {
+		return strings.Repeat(strconv.Itoa(key+round), 1000+key*10)
+	}
+	for key := 0; key < 50; key++ {
+		if err := kv.Put(key, value(key, 0)); err != nil {
+			t.Fatalf("Unexpected error putting key %d: %v", key, err)
+		}
+	}
+
+	// replacing values reuses the overflow pages of the old ones
+	pages := pager.PageCount()
+	for round := 1; round < 5; round++ {
+		for key := 0; key < 50; key++ {
+			kv.Put(key, value(key, round))
+		}
+	}
+	if pager.PageCount() > pages+pages/10 {
+		t.Errorf("Replacing values grew the file from %d to %d pages", pages, pager.PageCount())
+	}
+	root := kv.Root()
+	pager.Close()
+
+	_, pager = OpenPager(path, 512)
+	defer pager.Close()
+	_, kv = OpenKVTree[int, string](pager, root)
+	for key := 0; key < 50; key++ {
+		if got, ok := kv.Get(key); !ok || got != value(key, 4) {
+			t.Errorf("Unexpected value for key %d after reopening", key)
+		}
+	}
+}
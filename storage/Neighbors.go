@@ -0,0 +1,80 @@
+package storage
+
+// The lookups below find the key nearest to a given one. They return false when there is no such key,
+// or when a node cannot be loaded. While a transaction is open they look at the last commit, see Tx.go.
+
+// Min returns the smallest key
+func (btree *BTree[T]) Min() (T, bool) {
+	btree.mu.RLock()
+	defer btree.mu.RUnlock()
+	reader := btree.reader(nil)
+	if reader.root.n == 0 {
+		return defaultValue[T](), false
+	}
+	err, key := reader.findSmallestKeyInSubtreeRec(reader.root)
+	return key, err == nil
+}
+
+// Max returns the largest key
+func (btree *BTree[T]) Max() (T, bool) {
+	btree.mu.RLock()
+	defer btree.mu.RUnlock()
+	reader := btree.reader(nil)
+	if reader.root.n == 0 {
+		return defaultValue[T](), false
+	}
+	err, key := reader.findLargestKeyInSubtreeRec(reader.root)
+	return key, err == nil
+}
+
+// Floor returns the largest key less than or equal to key
+func (btree *BTree[T]) Floor(key T) (T, bool) {
+	btree.mu.RLock()
+	defer btree.mu.RUnlock()
+	return btree.reader(nil).before(key, true)
+}
+
+// Ceiling returns the smallest key greater than or equal to key
+func (btree *BTree[T]) Ceiling(key T) (T, bool) {
+	btree.mu.RLock()
+	defer btree.mu.RUnlock()
+	return btree.reader(nil).after(key, true)
+}
+
+// Lower returns the largest key less than key
+func (btree *BTree[T]) Lower(key T) (T, bool) {
+	btree.mu.RLock()
+	defer btree.mu.RUnlock()
+	return btree.reader(nil).before(key, false)
+}
+
+// Higher returns the smallest key greater than key
+func (btree *BTree[T]) Higher(key T) (T, bool) {
+	btree.mu.RLock()
+	defer btree.mu.RUnlock()
+	return btree.reader(nil).after(key, false)
+}
+
+// before returns the largest key less than key, or equal to it when orEqual is true
+func (btree *BTree[T]) before(key T, orEqual bool) (T, bool) {
+	cursor := btree.Cursor()
+	err := cursor.seek(key, orEqual)
+	if err == nil && cursor.Valid() {
+		err = cursor.prev()
+	} else if err == nil {
+		err = cursor.last()
+	}
+	if err != nil || !cursor.Valid() {
+		return defaultValue[T](), false
+	}
+	return cursor.key(), true
+}
+
+// after returns the smallest key greater than key, or equal to it when orEqual is true
+func (btree *BTree[T]) after(key T, orEqual bool) (T, bool) {
+	cursor := btree.Cursor()
+	if err := cursor.seek(key, !orEqual); err != nil || !cursor.Valid() {
+		return defaultValue[T](), false
+	}
+	return cursor.key(), true
+}
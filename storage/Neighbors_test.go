@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// checkNeighbors compares the lookups of btree with a scan of keys, the keys it holds in ascending order
+func checkNeighbors(t *testing.T, btree *BTree[int], keys []int) {
+	t.Helper()
+	if key, ok := btree.Min(); !ok || key != keys[0] {
+		t.Errorf("Expected Min %d, got %d %v", keys[0], key, ok)
+	}
+	if key, ok := btree.Max(); !ok || key != keys[len(keys)-1] {
+		t.Errorf("Expected Max %d, got %d %v", keys[len(keys)-1], key, ok)
+	}
+	lookups := []struct {
+		name   string
+		lookup func(int) (int, bool)
+		before bool
+		match  func(a, b int) bool
+	}{
+		{"Floor", btree.Floor, true, func(a, b int) bool { return a <= b }},
+		{"Lower", btree.Lower, true, func(a, b int) bool { return a < b }},
+		{"Ceiling", btree.Ceiling, false, func(a, b int) bool { return a >= b }},
+		{"Higher", btree.Higher, false, func(a, b int) bool { return a > b }},
+	}
+	for key := keys[0] - 2; key <= keys[len(keys)-1]+2; key++ {
+		for _, l := range lookups {
+			want, has := 0, false
+			for _, k := range keys {
+				if l.match(k, key) && (!has || l.before) {
+					want, has = k, true
+				}
+			}
+			if got, ok := l.lookup(key); ok != has || ok && got != want {
+				t.Fatalf("Expected %s(%d) to be %d %v, got %d %v", l.name, key, want, has, got, ok)
+			}
+		}
+	}
+}
+
+// fillNeighbors inserts the multiples of 3 from 0 to 297 and a second 150, returning them in order
+func fillNeighbors(btree *BTree[int]) []int {
+	var keys []int
+	for i := 99; i >= 0; i-- {
+		btree.Insert(3 * i)
+		keys = append([]int{3 * i}, keys...)
+	}
+	btree.Insert(150)
+	return slices.Insert(keys, 50, 150)
+}
+
+func TestNeighbors(t *testing.T) {
+	for _, deg := range []int{3, 4, 5, 8} {
+		_, btree := NewBTree[int](pageSize(deg))
+		checkNeighbors(t, btree, fillNeighbors(btree))
+	}
+}
+
+func TestNeighborsOnPager(t *testing.T) {
+	_, pager := OpenPager(filepath.Join(t.TempDir(), "tree.db"), 512)
+	defer pager.Close()
+	pager.SetCacheSize(5)
+	_, btree := OpenBTree[int](pager, 0)
+	checkNeighbors(t, btree, fillNeighbors(btree))
+}
+
+func TestNeighborsOfEmptyTree(t *testing.T) {
+	_, btree := NewBTree[int](pageSize(4))
+	if _, ok := btree.Min(); ok {
+		t.Error("Expected no Min in an empty tree")
+	}
+	if _, ok := btree.Max(); ok {
+		t.Error("Expected no Max in an empty tree")
+	}
+	if _, ok := btree.Floor(1); ok {
+		t.Error("Expected no Floor in an empty tree")
+	}
+	if _, ok := btree.Lower(1); ok {
+		t.Error("Expected no Lower in an empty tree")
+	}
+	if _, ok := btree.Ceiling(1); ok {
+		t.Error("Expected no Ceiling in an empty tree")
+	}
+	if _, ok := btree.Higher(1); ok {
+		t.Error("Expected no Higher in an empty tree")
+	}
+}
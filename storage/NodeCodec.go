@@ -0,0 +1,444 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"reflect"
+)
+
+/*
+Every node is stored in a page of its own, laid out like a SQLite b-tree page:
+
+	| page header | cell pointer array | unallocated space | cell content area |
+
+The page header is 12 bytes long, 16 bytes for BPlusTree leaves and BTree interior nodes:
+
+	offset  size  description
+	0       1     page type, see below
+	1       1     format version of the page, currently 4
+	2       1     key type, see below
+	3       1     reserved, always 0
+	4       2     number of keys n
+	6       2     offset of the first byte of the cell content area
+	8       4     right-most child pointer C[n] on interior pages, next leaf on BPlusTree leaves, else 0
+	12      4     previous leaf on BPlusTree leaves, number of keys under C[n] on BTree interior pages
+
+The page types are those SQLite uses for index b-trees, which keep keys in every node like BTree,
+and for table b-trees, which keep their data in the leaves like BPlusTree:
+
+	0x02  BTree interior node
+	0x0a  BTree leaf node
+	0x05  BPlusTree interior node
+	0x0d  BPlusTree leaf node
+
+The cell pointer array follows the header and holds n 2-byte offsets to the cells, in key order.
+Cells are written from the end of the page backwards. The body of a cell is the key K[i], the varint length
+of its payload V[i] and the payload itself. Interior nodes of a BPlusTree hold separator keys only,
+so their payloads are always empty. A cell is laid out as:
+
+	size  description
+	4     page number of the child left of the key, C[i], interior pages only
+	4     number of keys in the subtree of C[i], BTree interior pages only
+	var   length of the body
+	var   number of bytes of the body stored in the cell
+	      the first bytes of the body
+	4     first overflow page, only when the body does not fit in the cell
+
+Like in SQLite, bodies longer than maxLocal bytes spill to a chain of overflow pages, and so do the longest
+bodies of a node whose cells do not fit in its page otherwise. Every cell keeps at least minLocal bytes,
+which leaves room for the m - 1 keys of a full node. An overflow page starts with the 4-byte number of the
+next page in the chain, 0 on the last one, and holds the next bytes of the body in the rest of the page.
+
+Version 1 pages had no payload, version 2 pages no overflow pages, version 3 pages no subtree sizes.
+A subtree holds at most 2^32 - 1 keys.
+
+Keys are encoded according to the key type:
+
+	type  keys                       encoding
+	1     int, int8, ..., int64      8-byte two's complement integer
+	2     uint, uint8, ..., uintptr  8-byte unsigned integer
+	3     float32, float64           8-byte IEEE 754 binary64
+	4     string                     varint byte length followed by the bytes
+	5     []byte                     varint byte length followed by the bytes
+
+Trees with keys of any other type can only be kept in memory.
+
+Integers are big-endian, varints are the unsigned varints of encoding/binary.
+*/
+
+const (
+	pageTypeInterior      = 0x02
+	pageTypeLeaf          = 0x0a
+	pageTypeBPlusInterior = 0x05
+	pageTypeBPlusLeaf     = 0x0d
+
+	pageFormatVersion   = 4
+	pageHeaderSize      = 12
+	bplusLeafHeaderSize = 16
+
+	// cellOverhead is the most a cell with a minLocal body takes besides the body: child page number,
+	// subtree size, two varints, overflow page number and cell pointer
+	cellOverhead = 4 + 4 + binary.MaxVarintLen32 + 3 + 4 + 2
+
+	overflowHeaderSize = 4
+
+	keyTypeInt    = 1
+	keyTypeUint   = 2
+	keyTypeFloat  = 3
+	keyTypeString = 4
+	keyTypeBytes  = 5
+)
+
+// keyType returns 0 for keys that cannot be stored in a page
+func keyType[T any]() byte {
+	keyType := reflect.TypeFor[T]()
+	switch keyType.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return keyTypeInt
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return keyTypeUint
+	case reflect.Float32, reflect.Float64:
+		return keyTypeFloat
+	case reflect.String:
+		return keyTypeString
+	case reflect.Slice:
+		if keyType.Elem().Kind() == reflect.Uint8 {
+			return keyTypeBytes
+		}
+	}
+	return 0
+}
+
+func appendKey[T any](buf []byte, key T) []byte {
+	value := reflect.ValueOf(key)
+	switch keyType[T]() {
+	case keyTypeInt:
+		return binary.BigEndian.AppendUint64(buf, uint64(value.Int()))
+	case keyTypeUint:
+		return binary.BigEndian.AppendUint64(buf, value.Uint())
+	case keyTypeFloat:
+		return binary.BigEndian.AppendUint64(buf, math.Float64bits(value.Float()))
+	case keyTypeString:
+		buf = binary.AppendUvarint(buf, uint64(value.Len()))
+		return append(buf, value.String()...)
+	default:
+		buf = binary.AppendUvarint(buf, uint64(value.Len()))
+		return append(buf, value.Bytes()...)
+	}
+}
+
+// readKey decodes the key at the start of buf and returns it with its encoded length
+func readKey[T any](buf []byte) (error, T, int) {
+	var key T
+	value := reflect.ValueOf(&key).Elem()
+	kind := keyType[T]()
+	if kind == keyTypeString || kind == keyTypeBytes {
+		length, size := binary.Uvarint(buf)
+		if size <= 0 || uint64(len(buf)-size) < length {
+			return errors.New("key runs past the end of the page"), key, 0
+		}
+		if kind == keyTypeString {
+			value.SetString(string(buf[size : size+int(length)]))
+		} else {
+			value.SetBytes(append([]byte(nil), buf[size:size+int(length)]...))
+		}
+		return nil, key, size + int(length)
+	}
+
+	if len(buf) < 8 {
+		return errors.New("key runs past the end of the page"), key, 0
+	}
+	bits := binary.BigEndian.Uint64(buf)
+	switch kind {
+	case keyTypeInt:
+		value.SetInt(int64(bits))
+	case keyTypeUint:
+		value.SetUint(bits)
+	case keyTypeFloat:
+		value.SetFloat(math.Float64frombits(bits))
+	}
+	return nil, key, 8
+}
+
+// maxLocal is the longest body kept in its cell, the same fraction of the page SQLite keeps in index cells
+func maxLocal(pageSize int) int {
+	return (pageSize-12)*64/255 - 23
+}
+
+// minLocal is the number of bytes of its body every cell keeps
+func minLocal(pageSize int, m int) int {
+	return min((pageSize-bplusLeafHeaderSize)/(m-1)-cellOverhead, maxLocal(pageSize))
+}
+
+// localSize returns how many bytes of a body of the given size a cell keeps when the page has room.
+// A body that spills leaves as few bytes for its last overflow page as SQLite does.
+func localSize(size int, pageSize int, m int) int {
+	if size <= maxLocal(pageSize) {
+		return size
+	}
+	local := minLocal(pageSize, m) + (size-minLocal(pageSize, m))%(pageSize-overflowHeaderSize)
+	if local > maxLocal(pageSize) {
+		return minLocal(pageSize, m)
+	}
+	return local
+}
+
+// cellSize returns the space a cell keeping local bytes of its body takes, including its cell pointer.
+// prefix is the number of bytes in front of the body length.
+func cellSize(size int, local int, prefix int) int {
+	n := 2 + prefix + uvarintLen(uint64(size)) + uvarintLen(uint64(local)) + local
+	if local < size {
+		n += 4
+	}
+	return n
+}
+
+// cellPrefix returns the number of bytes in front of the body length in the cells of node:
+// the child page number on interior pages, followed by the subtree size in a BTree
+func (node *Node[T]) cellPrefix() int {
+	switch {
+	case node.isLeaf:
+		return 0
+	case node.bplus:
+		return 4
+	default:
+		return 8
+	}
+}
+
+func uvarintLen(v uint64) int {
+	n := 1
+	for ; v >= 0x80; v >>= 7 {
+		n++
+	}
+	return n
+}
+
+// Encode lays the node out in a page of pageSize bytes. Nodes whose cells need overflow pages are only
+// encoded by encode.
+func (node *Node[T]) Encode(pageSize int) (error, []byte) {
+	return node.encode(pageSize, nil)
+}
+
+// encode lays the node out in a page of pageSize bytes, spill writes the part of a body that does not fit
+// in its cell to overflow pages and returns the first one
+func (node *Node[T]) encode(pageSize int, spill func(data []byte) (error, Pgno)) (error, []byte) {
+	if keyType[T]() == 0 {
+		return errors.New("keys of this type cannot be stored in a page"), nil
+	}
+	page := make([]byte, pageSize)
+	headerSize := pageHeaderSize
+	switch {
+	case node.bplus && node.isLeaf:
+		page[0] = pageTypeBPlusLeaf
+		headerSize = bplusLeafHeaderSize
+		binary.BigEndian.PutUint32(page[8:], uint32(node.next))
+		binary.BigEndian.PutUint32(page[12:], uint32(node.prev))
+	case node.bplus:
+		page[0] = pageTypeBPlusInterior
+		binary.BigEndian.PutUint32(page[8:], uint32(node.C[node.n]))
+	case node.isLeaf:
+		page[0] = pageTypeLeaf
+	default:
+		page[0] = pageTypeInterior
+		headerSize = bplusLeafHeaderSize
+		binary.BigEndian.PutUint32(page[8:], uint32(node.C[node.n]))
+		if uint64(node.S[node.n]) > math.MaxUint32 {
+			return errors.New("subtree holds too many keys"), nil
+		}
+		binary.BigEndian.PutUint32(page[12:], uint32(node.S[node.n]))
+	}
+	page[1] = pageFormatVersion
+	page[2] = keyType[T]()
+	binary.BigEndian.PutUint16(page[4:], uint16(node.n))
+
+	bodies := make([][]byte, node.n)
+	locals := make([]int, node.n)
+	used := headerSize
+	for i := 0; i < node.n; i++ {
+		body := appendKey(nil, node.K[i])
+		body = binary.AppendUvarint(body, uint64(len(node.V[i])))
+		bodies[i] = append(body, node.V[i]...)
+		locals[i] = localSize(len(bodies[i]), pageSize, node.m)
+		used += cellSize(len(bodies[i]), locals[i], node.cellPrefix())
+	}
+	// the longest bodies spill until the cells fit
+	for used > pageSize {
+		longest := -1
+		for i := range locals {
+			if locals[i] > minLocal(pageSize, node.m) && (longest < 0 || locals[i] > locals[longest]) {
+				longest = i
+			}
+		}
+		if longest < 0 {
+			return errors.New("node does not fit in a page"), nil
+		}
+		used -= cellSize(len(bodies[longest]), locals[longest], node.cellPrefix())
+		locals[longest] = minLocal(pageSize, node.m)
+		used += cellSize(len(bodies[longest]), locals[longest], node.cellPrefix())
+	}
+
+	content := pageSize
+	var cell []byte
+	for i := 0; i < node.n; i++ {
+		cell = cell[:0]
+		if !node.isLeaf {
+			cell = binary.BigEndian.AppendUint32(cell, uint32(node.C[i]))
+		}
+		if !node.isLeaf && !node.bplus {
+			if uint64(node.S[i]) > math.MaxUint32 {
+				return errors.New("subtree holds too many keys"), nil
+			}
+			cell = binary.BigEndian.AppendUint32(cell, uint32(node.S[i]))
+		}
+		cell = binary.AppendUvarint(cell, uint64(len(bodies[i])))
+		cell = binary.AppendUvarint(cell, uint64(locals[i]))
+		cell = append(cell, bodies[i][:locals[i]]...)
+		if locals[i] < len(bodies[i]) {
+			if spill == nil {
+				return errors.New("node does not fit in a page"), nil
+			}
+			err, overflow := spill(bodies[i][locals[i]:])
+			if err != nil {
+				return err, nil
+			}
+			cell = binary.BigEndian.AppendUint32(cell, uint32(overflow))
+		}
+
+		content -= len(cell)
+		copy(page[content:], cell)
+		binary.BigEndian.PutUint16(page[headerSize+2*i:], uint16(content))
+	}
+	binary.BigEndian.PutUint16(page[6:], uint16(content))
+	return nil, page
+}
+
+// DecodeNode reads the node stored in page pgno of a btree of order m. Nodes whose cells continue
+// in overflow pages are only decoded by decodeNode.
+func DecodeNode[T any](page []byte, pgno Pgno, m int) (error, *Node[T]) {
+	return decodeNode[T](page, pgno, m, 0, nil)
+}
+
+// decodeNode reads the node stored in page pgno of a btree of order m, read returns the overflow pages
+// its bodies continue in, out of the pages of the file
+func decodeNode[T any](page []byte, pgno Pgno, m int, pages Pgno, read func(pgno Pgno) (error, []byte)) (error, *Node[T]) {
+	if len(page) < bplusLeafHeaderSize {
+		return errors.New("page is too small to hold a btree node"), nil
+	}
+	headerSize := pageHeaderSize
+	switch page[0] {
+	case pageTypeLeaf, pageTypeBPlusInterior:
+	case pageTypeInterior, pageTypeBPlusLeaf:
+		headerSize = bplusLeafHeaderSize
+	default:
+		return errors.New("page does not hold a btree node"), nil
+	}
+	if page[1] != pageFormatVersion {
+		return errors.New("unsupported page format version"), nil
+	}
+	if page[2] != keyType[T]() {
+		return errors.New("page holds keys of another type"), nil
+	}
+	n := int(binary.BigEndian.Uint16(page[4:]))
+	if n >= m || headerSize+2*n > len(page) {
+		return errors.New("page holds too many keys"), nil
+	}
+
+	node := newNode[T](pgno, m, page[0] == pageTypeLeaf || page[0] == pageTypeBPlusLeaf)
+	node.bplus = page[0] == pageTypeBPlusInterior || page[0] == pageTypeBPlusLeaf
+	if node.bplus && node.isLeaf {
+		node.next = Pgno(binary.BigEndian.Uint32(page[8:]))
+		node.prev = Pgno(binary.BigEndian.Uint32(page[12:]))
+	} else if !node.isLeaf {
+		node.C[n] = Pgno(binary.BigEndian.Uint32(page[8:]))
+	}
+	if !node.isLeaf && !node.bplus {
+		node.S[n] = int(binary.BigEndian.Uint32(page[12:]))
+	}
+	// an overflow chain takes at most every page of the file
+	capacity := uint64(pages) * uint64(len(page)-overflowHeaderSize)
+	for i := 0; i < n; i++ {
+		offset := int(binary.BigEndian.Uint16(page[headerSize+2*i:]))
+		if offset < headerSize+2*n || offset >= len(page) {
+			return errors.New("cell pointer out of range"), nil
+		}
+		cell := page[offset:]
+		if len(cell) < node.cellPrefix() {
+			return errors.New("cell runs past the end of the page"), nil
+		}
+		if !node.isLeaf {
+			node.C[i] = Pgno(binary.BigEndian.Uint32(cell))
+		}
+		if !node.isLeaf && !node.bplus {
+			node.S[i] = int(binary.BigEndian.Uint32(cell[4:]))
+		}
+		cell = cell[node.cellPrefix():]
+		err, body := node.readBody(cell, capacity, read)
+		if err != nil {
+			return err, nil
+		}
+
+		err, key, size := readKey[T](body)
+		if err != nil {
+			return err, nil
+		}
+		node.K[i] = key
+		body = body[size:]
+		length, size := binary.Uvarint(body)
+		if size <= 0 || uint64(len(body)-size) != length {
+			return errors.New("payload does not match the length of the body"), nil
+		}
+		if length > 0 {
+			node.V[i] = append([]byte(nil), body[size:]...)
+		}
+	}
+	node.n = n
+	return nil, node
+}
+
+// readBody returns the body of the cell at the start of buf, reassembled from its overflow pages when it spilled.
+// The overflow pages are added to node.overflow, which hold no more than capacity bytes together.
+func (node *Node[T]) readBody(buf []byte, capacity uint64, read func(pgno Pgno) (error, []byte)) (error, []byte) {
+	size, n := binary.Uvarint(buf)
+	if n <= 0 {
+		return errors.New("cell runs past the end of the page"), nil
+	}
+	buf = buf[n:]
+	local, n := binary.Uvarint(buf)
+	if n <= 0 || local > size || uint64(len(buf)-n) < local {
+		return errors.New("cell runs past the end of the page"), nil
+	}
+	buf = buf[n:]
+	if local == size {
+		return nil, buf[:local]
+	}
+	if len(buf) < int(local)+4 {
+		return errors.New("cell runs past the end of the page"), nil
+	}
+	if read == nil {
+		return errors.New("cell continues in overflow pages"), nil
+	}
+	// a corrupt size is not trusted with the allocation
+	if size-local > capacity {
+		return errors.New("cell is longer than its overflow pages can hold"), nil
+	}
+
+	body := make([]byte, 0, size)
+	body = append(body, buf[:local]...)
+	overflow := Pgno(binary.BigEndian.Uint32(buf[local:]))
+	for uint64(len(body)) < size {
+		if overflow == 0 {
+			return errors.New("overflow chain ends before the body"), nil
+		}
+		err, page := read(overflow)
+		if err != nil {
+			return err, nil
+		}
+		node.overflow = append(node.overflow, overflow)
+		chunk := min(int(size)-len(body), len(page)-overflowHeaderSize)
+		body = append(body, page[overflowHeaderSize:overflowHeaderSize+chunk]...)
+		overflow = Pgno(binary.BigEndian.Uint32(page))
+	}
+	return nil, body
+}
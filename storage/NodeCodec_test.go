@@ -0,0 +1,244 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+)
+
+func roundTrip[T int | int8 | uint16 | uint64 | float32 | float64 | string](t *testing.T, keys []T) {
+	t.Helper()
+	for _, leaf := range []bool{true, false} {
+		node := newNode[T](7, 16, leaf)
+		for i, key := range keys {
+			node.K[i] = key
+			node.V[i] = []byte{byte(i), 1, 2}
+			node.C[i] = Pgno(100 + i)
+		}
+		node.n = len(keys)
+		if !leaf {
+			node.C[node.n] = 999
+		}
+
+		err, page := node.Encode(512)
+		if err != nil {
+			t.Fatalf("Unexpected error encoding node: %v", err)
+		}
+		err, decoded := DecodeNode[T](page, 7, 16)
+		if err != nil {
+			t.Fatalf("Unexpected error decoding node: %v", err)
+		}
+		if decoded.pgno != 7 || decoded.n != node.n || decoded.isLeaf != leaf {
+			t.Fatalf("Decoded node header differs: %+v", decoded)
+		}
+		for i := 0; i < node.n; i++ {
+			if decoded.K[i] != node.K[i] {
+				t.Errorf("Expected key %v, got %v", node.K[i], decoded.K[i])
+			}
+			if string(decoded.V[i]) != string(node.V[i]) {
+				t.Errorf("Expected payload %v, got %v", node.V[i], decoded.V[i])
+			}
+		}
+		if !leaf {
+			for i := 0; i <= node.n; i++ {
+				if decoded.C[i] != node.C[i] {
+					t.Errorf("Expected child %d, got %d", node.C[i], decoded.C[i])
+				}
+			}
+		}
+	}
+}
+
+type userID int32
+
+func TestNodeRoundTrip(t *testing.T) {
+	roundTrip(t, []int{math.MinInt64, -1, 0, 1, math.MaxInt64})
+	roundTrip(t, []int8{-128, 0, 127})
+	roundTrip(t, []uint16{0, 1, math.MaxUint16})
+	roundTrip(t, []uint64{0, math.MaxUint64})
+	roundTrip(t, []float32{-1.5, 0, 3.25})
+	roundTrip(t, []float64{math.Inf(-1), -0.5, math.SmallestNonzeroFloat64, math.MaxFloat64})
+	roundTrip(t, []string{"", "apple", "banana", "日本語"})
+
+	node := newNode[userID](3, 4, true)
+	node.K[0], node.K[1] = -7, 42
+	node.n = 2
+	_, page := node.Encode(512)
+	err, decoded := DecodeNode[userID](page, 3, 4)
+	if err != nil || decoded.K[0] != -7 || decoded.K[1] != 42 {
+		t.Errorf("Named key type did not round-trip: %v %v", err, decoded)
+	}
+}
+
+func TestNodePageLayout(t *testing.T) {
+	node := newNode[int](2, 4, false)
+	node.K[0], node.K[1] = 10, 20
+	node.C[0], node.C[1], node.C[2] = 3, 4, 5
+	node.S[0], node.S[1], node.S[2] = 6, 7, 8
+	node.n = 2
+
+	_, page := node.Encode(512)
+	if page[0] != pageTypeInterior || page[1] != pageFormatVersion || page[2] != keyTypeInt {
+		t.Errorf("Unexpected page header %v", page[:4])
+	}
+	if binary.BigEndian.Uint16(page[4:]) != 2 {
+		t.Error("Expected key count of 2 in page header")
+	}
+	if binary.BigEndian.Uint32(page[8:]) != 5 || binary.BigEndian.Uint32(page[12:]) != 8 {
+		t.Error("Expected right-most child pointer 5 with 8 keys under it in page header")
+	}
+	// each cell is a 4-byte child pointer, the 4-byte size of its subtree, the body length, the local length
+	// and a body of an 8-byte key and an empty payload, written from the end of the page
+	if binary.BigEndian.Uint16(page[6:]) != 512-38 {
+		t.Errorf("Unexpected start of cell content area %d", binary.BigEndian.Uint16(page[6:]))
+	}
+	first := binary.BigEndian.Uint16(page[16:])
+	if first != 512-19 || binary.BigEndian.Uint32(page[first:]) != 3 || binary.BigEndian.Uint32(page[first+4:]) != 6 ||
+		page[first+8] != 9 || page[first+9] != 9 || binary.BigEndian.Uint64(page[first+10:]) != 10 {
+		t.Errorf("Unexpected first cell at offset %d", first)
+	}
+	err, decoded := DecodeNode[int](page, 2, 4)
+	if err != nil || decoded.S[0] != 6 || decoded.S[1] != 7 || decoded.S[2] != 8 {
+		t.Errorf("Subtree sizes did not round-trip: %v %v", err, decoded.S)
+	}
+}
+
+func TestDecodeNodeErrors(t *testing.T) {
+	node := newNode[int](2, 4, true)
+	node.K[0] = 1
+	node.n = 1
+	_, page := node.Encode(512)
+
+	if err, _ := DecodeNode[string](page, 2, 4); err == nil {
+		t.Error("Expected error decoding keys of another type")
+	}
+	if err, _ := DecodeNode[int](make([]byte, 512), 2, 4); err == nil {
+		t.Error("Expected error decoding an empty page")
+	}
+	page[1] = pageFormatVersion + 1
+	if err, _ := DecodeNode[int](page, 2, 4); err == nil {
+		t.Error("Expected error decoding an unknown format version")
+	}
+
+	big := newNode[string](2, 4, true)
+	big.K[0] = string(make([]byte, 600))
+	big.n = 1
+	if err, _ := big.Encode(512); err == nil {
+		t.Error("Expected error encoding a node larger than a page")
+	}
+}
+
+func TestBPlusLeafRoundTrip(t *testing.T) {
+	node := newNode[int](5, 4, true)
+	node.bplus = true
+	node.prev, node.next = 3, 9
+	node.K[0], node.V[0] = 7, []byte("seven")
+	node.n = 1
+	err, page := node.Encode(512)
+	if err != nil {
+		t.Fatalf("Unexpected error encoding leaf: %v", err)
+	}
+	if page[0] != pageTypeBPlusLeaf {
+		t.Errorf("Expected page type %#x, got %#x", pageTypeBPlusLeaf, page[0])
+	}
+
+	err, decoded := DecodeNode[int](page, 5, 4)
+	if err != nil {
+		t.Fatalf("Unexpected error decoding leaf: %v", err)
+	}
+	if !decoded.bplus || !decoded.isLeaf || decoded.prev != 3 || decoded.next != 9 {
+		t.Errorf("Leaf header not preserved: bplus %v, prev %d, next %d", decoded.bplus, decoded.prev, decoded.next)
+	}
+	if decoded.n != 1 || decoded.K[0] != 7 || string(decoded.V[0]) != "seven" {
+		t.Errorf("Leaf record not preserved")
+	}
+}
+
+func TestNodeOverflowRoundTrip(t *testing.T) {
+	// pages written by spill, overflow page numbers start at 100
+	pages := make(map[Pgno][]byte)
+	spill := func(data []byte) (error, Pgno) {
+		first := Pgno(100 + len(pages))
+		for len(data) > 0 {
+			pgno := Pgno(100 + len(pages))
+			page := make([]byte, 512)
+			n := copy(page[overflowHeaderSize:], data)
+			data = data[n:]
+			if len(data) > 0 {
+				binary.BigEndian.PutUint32(page, uint32(pgno+1))
+			}
+			pages[pgno] = page
+		}
+		return nil, first
+	}
+	read := func(pgno Pgno) (error, []byte) {
+		return nil, pages[pgno]
+	}
+
+	m := (512 - 8) / 32
+	node := newNode[string](2, m, true)
+	node.K[0], node.V[0] = "small", []byte("value")
+	node.K[1], node.V[1] = strings.Repeat("k", 2000), bytes.Repeat([]byte("v"), 3000)
+	node.K[2], node.V[2] = "medium", bytes.Repeat([]byte("m"), 90)
+	node.n = 3
+	if err, _ := node.Encode(512); err == nil {
+		t.Error("Expected error encoding a node that needs overflow pages without spill")
+	}
+	err, page := node.encode(512, spill)
+	if err != nil {
+		t.Fatalf("Unexpected error encoding node: %v", err)
+	}
+	if len(pages) != 10 {
+		t.Errorf("Expected the long cell to fill 10 overflow pages, got %d", len(pages))
+	}
+
+	if err, _ = DecodeNode[string](page, 2, m); err == nil {
+		t.Error("Expected error decoding a node with overflow pages without read")
+	}
+	err, decoded := decodeNode[string](page, 2, m, 110, read)
+	if err != nil {
+		t.Fatalf("Unexpected error decoding node: %v", err)
+	}
+	for i := 0; i < node.n; i++ {
+		if decoded.K[i] != node.K[i] || !bytes.Equal(decoded.V[i], node.V[i]) {
+			t.Errorf("Cell %d not preserved", i)
+		}
+	}
+	if len(decoded.overflow) != 10 {
+		t.Errorf("Expected the decoded node to know its 10 overflow pages, got %v", decoded.overflow)
+	}
+
+	// a corrupt body size longer than every page of the file is rejected before it is allocated
+	cell := binary.AppendUvarint(nil, 1<<60)
+	cell = binary.AppendUvarint(cell, 4)
+	cell = binary.BigEndian.AppendUint32(append(cell, "body"...), 100)
+	if err, _ := newNode[string](2, m, true).readBody(cell, 110*(512-overflowHeaderSize), read); err == nil {
+		t.Error("Expected error reading a body longer than its overflow pages can hold")
+	}
+}
+
+func TestNodeSpillsToFit(t *testing.T) {
+	// m - 1 cells below maxLocal that do not fit in the page together
+	m := (512 - 8) / 32
+	node := newNode[int](2, m, true)
+	for i := 0; i < m-1; i++ {
+		node.K[i], node.V[i] = i, bytes.Repeat([]byte{byte(i)}, 80)
+	}
+	node.n = m - 1
+	spilled := 0
+	err, page := node.encode(512, func(data []byte) (error, Pgno) {
+		spilled++
+		return nil, Pgno(spilled)
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error encoding node: %v", err)
+	}
+	if spilled == 0 || spilled == m-1 {
+		t.Errorf("Expected some cells to spill, %d of %d did", spilled, m-1)
+	}
+	if content := int(binary.BigEndian.Uint16(page[6:])); content < pageHeaderSize+2*(m-1) {
+		t.Errorf("Cells overlap the cell pointer array")
+	}
+}
@@ -0,0 +1,334 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+)
+
+// nodeStore is where a BTree keeps its nodes. Nodes refer to their children by page number,
+// so the same tree code runs in memory and on top of a Pager.
+// A node changed by the tree is handed back to save before the operation returns.
+//
+// Between begin and commit the store can undo every change with rollback, and the changes since a savepoint
+// with rollbackTo. Savepoints nest, their level is the number of savepoints open once they are made.
+// The nodes the tree holds on to are stale after a rollback and have to be loaded again.
+//
+// fits tells whether the cells of a node fit in its page besides its order, which only matters for the
+// SQLite format, where a page holds as many cells as fit in it.
+type nodeStore[T any] interface {
+	load(pgno Pgno) (error, *Node[T])
+	save(node *Node[T]) error
+	alloc(leaf bool) (error, *Node[T])
+	free(node *Node[T]) error
+	fits(node *Node[T]) bool
+
+	begin(root *Node[T]) error
+	commit() error
+	rollback() error
+	savepoint(root *Node[T]) (error, int)
+	release(level int) error
+	rollbackTo(level int) error
+}
+
+// memNodes keeps the nodes of an in-memory tree. Page numbers are only used as map keys.
+// Nodes are changed in place, so during a transaction a node is copied to the undo log before the tree
+// gets hold of it: when it is loaded, allocated or, for the root, when the transaction or savepoint begins.
+// The transaction and each savepoint have their own undo log, a node is copied to the newest one.
+type memNodes[T any] struct {
+	m     int
+	nodes map[Pgno]*Node[T]
+	last  Pgno
+
+	undo   []*undoLog[T] // the transaction first, then its savepoints; empty without a transaction
+	undoMu sync.Mutex    // readers of the tree load nodes concurrently
+}
+
+type undoLog[T any] struct {
+	nodes map[Pgno]*Node[T] // nodes as they were when the log was started, nil for new ones
+	last  Pgno
+}
+
+func newMemNodes[T any](m int) *memNodes[T] {
+	return &memNodes[T]{
+		m:     m,
+		nodes: make(map[Pgno]*Node[T]),
+	}
+}
+
+func (store *memNodes[T]) load(pgno Pgno) (error, *Node[T]) {
+	node, ok := store.nodes[pgno]
+	if !ok {
+		return errors.New("node does not exist"), nil
+	}
+	store.remember(node.pgno)
+	return nil, node
+}
+
+func (store *memNodes[T]) save(node *Node[T]) error {
+	return nil
+}
+
+func (store *memNodes[T]) alloc(leaf bool) (error, *Node[T]) {
+	store.last++
+	store.remember(store.last)
+	node := newNode[T](store.last, store.m, leaf)
+	store.nodes[node.pgno] = node
+	return nil, node
+}
+
+func (store *memNodes[T]) free(node *Node[T]) error {
+	store.remember(node.pgno)
+	delete(store.nodes, node.pgno)
+	return nil
+}
+
+func (store *memNodes[T]) fits(node *Node[T]) bool {
+	return true
+}
+
+// remember copies node pgno to the newest undo log, unless there is no transaction or it is there already
+func (store *memNodes[T]) remember(pgno Pgno) {
+	store.undoMu.Lock()
+	defer store.undoMu.Unlock()
+	if len(store.undo) == 0 {
+		return
+	}
+	log := store.undo[len(store.undo)-1]
+	if _, ok := log.nodes[pgno]; ok {
+		return
+	}
+	if node, ok := store.nodes[pgno]; ok {
+		log.nodes[pgno] = node.clone()
+	} else {
+		log.nodes[pgno] = nil
+	}
+}
+
+// startUndo starts a new undo log, the root is copied to it right away
+func (store *memNodes[T]) startUndo(root *Node[T]) {
+	store.undo = append(store.undo, &undoLog[T]{nodes: make(map[Pgno]*Node[T]), last: store.last})
+	store.remember(root.pgno)
+}
+
+// undoTo restores the nodes from the undo logs from the newest down to the one at level
+func (store *memNodes[T]) undoTo(level int) {
+	for i := len(store.undo) - 1; i >= level; i-- {
+		for pgno, node := range store.undo[i].nodes {
+			if node == nil {
+				delete(store.nodes, pgno)
+			} else {
+				store.nodes[pgno] = node
+			}
+		}
+	}
+	store.last = store.undo[level].last
+}
+
+func (store *memNodes[T]) begin(root *Node[T]) error {
+	store.undo = nil
+	store.startUndo(root)
+	return nil
+}
+
+func (store *memNodes[T]) commit() error {
+	store.undo = nil
+	return nil
+}
+
+func (store *memNodes[T]) rollback() error {
+	if len(store.undo) > 0 {
+		store.undoTo(0)
+	}
+	store.undo = nil
+	return nil
+}
+
+func (store *memNodes[T]) savepoint(root *Node[T]) (error, int) {
+	if len(store.undo) == 0 {
+		return errors.New("savepoints need a transaction"), 0
+	}
+	store.startUndo(root)
+	return nil, len(store.undo) - 1
+}
+
+// release hands the nodes in the undo logs of the released savepoints to the log before them,
+// which keeps the copies it has
+func (store *memNodes[T]) release(level int) error {
+	if level < 1 || level >= len(store.undo) {
+		return errors.New("savepoint does not exist")
+	}
+	parent := store.undo[level-1]
+	for _, log := range store.undo[level:] {
+		for pgno, node := range log.nodes {
+			if _, ok := parent.nodes[pgno]; !ok {
+				parent.nodes[pgno] = node
+			}
+		}
+	}
+	store.undo = store.undo[:level]
+	return nil
+}
+
+func (store *memNodes[T]) rollbackTo(level int) error {
+	if level < 1 || level >= len(store.undo) {
+		return errors.New("savepoint does not exist")
+	}
+	store.undoTo(level)
+	store.undo[level].nodes = make(map[Pgno]*Node[T])
+	store.undo = store.undo[:level+1]
+	return nil
+}
+
+// pagerNodes keeps every node in its own page of a Pager.
+// Loaded nodes are decoded copies, so changes only reach the page through save.
+// The savepoints belong to the pager, which drops them when it is synced: they are only used as long as the
+// pager has not been synced since the transaction began.
+type pagerNodes[T any] struct {
+	m     int
+	pager *Pager
+	syncs uint64 // Syncs of the pager when the transaction began
+}
+
+func (store *pagerNodes[T]) load(pgno Pgno) (error, *Node[T]) {
+	err, page := store.pager.Read(pgno)
+	if err != nil {
+		return err, nil
+	}
+	if store.pager.sqlite {
+		return decodeSQLiteNode[T](page, pgno, store.m, store.pager.PageCount(), store.pager.Read)
+	}
+	return decodeNode[T](page, pgno, store.m, store.pager.PageCount(), store.pager.Read)
+}
+
+// save writes node to its page. The overflow pages the node had are reused for the bodies that spill now,
+// the ones left over are freed.
+func (store *pagerNodes[T]) save(node *Node[T]) error {
+	unused := node.overflow
+	node.overflow = nil
+	spill := func(data []byte) (error, Pgno) {
+		return store.writeOverflow(node, &unused, data)
+	}
+	var err error
+	var page []byte
+	if store.pager.sqlite {
+		if err, page = node.encodeSQLite(store.pager.PageSize(), spill); err == nil {
+			err = store.pager.writeTreePage(node.pgno, page)
+		}
+	} else if err, page = node.encode(store.pager.PageSize(), spill); err == nil {
+		err = store.pager.Write(node.pgno, page)
+	}
+	if err != nil {
+		return err
+	}
+	for _, pgno := range unused {
+		if err = store.freePage(pgno); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeOverflow writes data to a chain of overflow pages of node, taking pages from unused before
+// allocating new ones, and returns the first page of the chain
+func (store *pagerNodes[T]) writeOverflow(node *Node[T], unused *[]Pgno, data []byte) (error, Pgno) {
+	chunk := store.pager.PageSize() - overflowHeaderSize
+	pages := make([]Pgno, (len(data)+chunk-1)/chunk)
+	for i := range pages {
+		if len(*unused) > 0 {
+			pages[i] = (*unused)[0]
+			*unused = (*unused)[1:]
+			continue
+		}
+		var err error
+		err, pages[i] = store.pager.Allocate()
+		if err != nil {
+			return err, 0
+		}
+	}
+
+	page := make([]byte, store.pager.PageSize())
+	for i, pgno := range pages {
+		clear(page)
+		if i+1 < len(pages) {
+			binary.BigEndian.PutUint32(page, uint32(pages[i+1]))
+		}
+		copy(page[overflowHeaderSize:], data[i*chunk:])
+		if err := store.pager.Write(pgno, page); err != nil {
+			return err, 0
+		}
+	}
+	node.overflow = append(node.overflow, pages...)
+	return nil, pages[0]
+}
+
+func (store *pagerNodes[T]) alloc(leaf bool) (error, *Node[T]) {
+	err, pgno := store.pager.Allocate()
+	if err != nil {
+		return err, nil
+	}
+	return nil, newNode[T](pgno, store.m, leaf)
+}
+
+func (store *pagerNodes[T]) free(node *Node[T]) error {
+	for _, pgno := range node.overflow {
+		if err := store.freePage(pgno); err != nil {
+			return err
+		}
+	}
+	node.overflow = nil
+	return store.freePage(node.pgno)
+}
+
+func (store *pagerNodes[T]) fits(node *Node[T]) bool {
+	return !store.pager.sqlite || node.sqliteFits(store.pager.PageSize())
+}
+
+func (store *pagerNodes[T]) freePage(pgno Pgno) error {
+	return store.pager.Free(pgno)
+}
+
+// begin commits what changed in the pager before, so a rollback only undoes the transaction
+func (store *pagerNodes[T]) begin(root *Node[T]) error {
+	if err := store.pager.commit(); err != nil {
+		return err
+	}
+	store.syncs = store.pager.syncCount()
+	return nil
+}
+
+func (store *pagerNodes[T]) commit() error {
+	return store.pager.commit()
+}
+
+func (store *pagerNodes[T]) rollback() error {
+	return store.pager.rollback()
+}
+
+func (store *pagerNodes[T]) savepoint(root *Node[T]) (error, int) {
+	if err := store.checkSyncs(); err != nil {
+		return err, 0
+	}
+	return nil, store.pager.savepoint()
+}
+
+func (store *pagerNodes[T]) release(level int) error {
+	if err := store.checkSyncs(); err != nil {
+		return err
+	}
+	return store.pager.releaseSavepoint(level)
+}
+
+func (store *pagerNodes[T]) rollbackTo(level int) error {
+	if err := store.checkSyncs(); err != nil {
+		return err
+	}
+	return store.pager.rollbackToSavepoint(level)
+}
+
+func (store *pagerNodes[T]) checkSyncs() error {
+	if store.pager.syncCount() != store.syncs {
+		return errors.New("the pager was synced during the transaction, its savepoints are gone")
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package storage
+
+import "errors"
+
+/*
+Every interior node counts the keys in the subtree of each of its children in S, so the position of a key
+is found on the way down from the root: the keys left of the path are the keys of the node before the child
+taken plus the sizes of the subtrees left of it. Insert, splitChild, deleteRec and fixUnderflow keep the
+counts up to date on the path they change.
+*/
+
+// Len returns the number of keys in the tree
+func (btree *BTree[T]) Len() int {
+	btree.mu.RLock()
+	defer btree.mu.RUnlock()
+	return btree.reader(nil).root.size()
+}
+
+// Rank returns the number of keys less than key, which is the position Select finds key at
+// when it is in the tree
+func (btree *BTree[T]) Rank(key T) (error, int) {
+	btree.mu.RLock()
+	defer btree.mu.RUnlock()
+	return btree.reader(nil).rank(key)
+}
+
+func (btree *BTree[T]) rank(key T) (error, int) {
+	rank := 0
+	node := btree.root
+	for {
+		i := 0
+		for i < node.n && btree.compare(node.K[i], key) < 0 {
+			if !node.isLeaf {
+				rank += node.S[i]
+			}
+			rank++
+			i++
+		}
+		if node.isLeaf {
+			return nil, rank
+		}
+		err, child := btree.nodes.load(node.C[i])
+		if err != nil {
+			return err, 0
+		}
+		node = child
+	}
+}
+
+// Select returns the key at position k in ascending order, counting from 0
+func (btree *BTree[T]) Select(k int) (error, T) {
+	btree.mu.RLock()
+	defer btree.mu.RUnlock()
+	return btree.reader(nil).selectKey(k)
+}
+
+func (btree *BTree[T]) selectKey(k int) (error, T) {
+	if k < 0 || k >= btree.root.size() {
+		return errors.New("position is out of range"), defaultValue[T]()
+	}
+	node := btree.root
+	for !node.isLeaf {
+		i := 0
+		for ; i < node.n && k >= node.S[i]; i++ {
+			k -= node.S[i]
+			if k == 0 {
+				return nil, node.K[i]
+			}
+			k--
+		}
+		err, child := btree.nodes.load(node.C[i])
+		if err != nil {
+			return err, defaultValue[T]()
+		}
+		node = child
+	}
+	return nil, node.K[k]
+}
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"math/rand"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// checkOrderStatistics compares Len, Rank and Select of btree with the sorted keys it should hold
+func checkOrderStatistics(t *testing.T, btree *BTree[int], keys []int) {
+	t.Helper()
+	if btree.Len() != len(keys) {
+		t.Fatalf("Expected Len %d, got %d", len(keys), btree.Len())
+	}
+	for k, key := range keys {
+		if err, got := btree.Select(k); err != nil || got != key {
+			t.Fatalf("Expected Select(%d) to be %d, got %d (%v)", k, key, got, err)
+		}
+		rank, _ := slices.BinarySearch(keys, key)
+		if err, got := btree.Rank(key); err != nil || got != rank {
+			t.Fatalf("Expected Rank(%d) to be %d, got %d (%v)", key, rank, got, err)
+		}
+	}
+	if err, _ := btree.Select(len(keys)); err == nil {
+		t.Error("Expected error selecting past the last key")
+	}
+	if err, _ := btree.Select(-1); err == nil {
+		t.Error("Expected error selecting a negative position")
+	}
+}
+
+func TestOrderStatistics(t *testing.T) {
+	for _, deg := range []int{3, 4, 5, 8} {
+		_, btree := NewBTree[int](pageSize(deg))
+		r := rand.New(rand.NewSource(int64(deg)))
+		var keys []int
+		for i := 0; i < 500; i++ {
+			key := r.Intn(300)
+			btree.Insert(key)
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		checkOrderStatistics(t, btree, keys)
+
+		for i := 0; i < 400; i++ {
+			key := r.Intn(300)
+			if err, _ := btree.Delete(key); err == nil {
+				j, _ := slices.BinarySearch(keys, key)
+				keys = slices.Delete(keys, j, j+1)
+			}
+		}
+		checkTree(t, btree)
+		checkOrderStatistics(t, btree, keys)
+	}
+}
+
+func TestRankOfMissingKeys(t *testing.T) {
+	_, btree := NewBTree[int](pageSize(4))
+	if err, rank := btree.Rank(5); err != nil || rank != 0 || btree.Len() != 0 {
+		t.Errorf("Expected rank 0 in an empty tree, got %d (%v)", rank, err)
+	}
+	for i := 0; i < 100; i++ {
+		btree.Insert(2 * i)
+	}
+	for _, c := range []struct{ key, rank int }{{-1, 0}, {1, 1}, {99, 50}, {1000, 100}} {
+		if err, rank := btree.Rank(c.key); err != nil || rank != c.rank {
+			t.Errorf("Expected Rank(%d) to be %d, got %d (%v)", c.key, c.rank, rank, err)
+		}
+	}
+}
+
+func TestOrderStatisticsOnPager(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tree.db")
+	_, pager := OpenPager(path, 512)
+	pager.SetCacheSize(5)
+	_, btree := OpenBTree[int](pager, 0)
+	keys := seq(2000)
+	btree.BulkLoad(slices.Values(keys[:1000]), 0.8)
+	for _, key := range keys[1000:] {
+		btree.Insert(key)
+	}
+	for i := 0; i < 2000; i += 3 {
+		btree.Delete(i)
+	}
+	keys = slices.DeleteFunc(keys, func(key int) bool { return key%3 == 0 })
+	root := btree.Root()
+	pager.Close()
+
+	_, pager = OpenPager(path, 512)
+	defer pager.Close()
+	_, btree = OpenBTree[int](pager, root)
+	checkTree(t, btree)
+	checkOrderStatistics(t, btree, keys)
+}
@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"container/list"
+)
+
+// DefaultCacheSize is the number of pages a Pager caches unless SetCacheSize or SetCacheBytes say otherwise
+const DefaultCacheSize = 2000
+
+// CacheStats counts what happened in the page cache of a Pager since it was opened
+type CacheStats struct {
+	Hits       int // reads of a page that was in the cache
+	Misses     int // reads of a page that had to come from the file
+	Evictions  int // pages dropped to make room for others
+	WriteBacks int // dirty pages written to the file when they were evicted
+}
+
+// pageCache keeps the most recently used pages of a Pager. Pages that are pinned or dirty are never
+// dropped without being written: a dirty page is written back when it is evicted, a pinned one stays
+// even when the cache holds more than its capacity.
+type pageCache struct {
+	capacity int
+	entries  map[Pgno]*list.Element
+	lru      list.List // front is the most recently used page
+	stats    CacheStats
+}
+
+type cachedPage struct {
+	pgno  Pgno
+	data  []byte
+	dirty bool
+	pins  int
+}
+
+func newPageCache(capacity int) *pageCache {
+	return &pageCache{
+		capacity: capacity,
+		entries:  make(map[Pgno]*list.Element),
+	}
+}
+
+// get returns the cached page pgno for a read, counting a hit or a miss, and marks it as most recently used
+func (cache *pageCache) get(pgno Pgno) *cachedPage {
+	page := cache.use(pgno)
+	if page == nil {
+		cache.stats.Misses++
+	} else {
+		cache.stats.Hits++
+	}
+	return page
+}
+
+// use returns the cached page pgno and marks it as most recently used, without counting a hit or a miss
+func (cache *pageCache) use(pgno Pgno) *cachedPage {
+	element, ok := cache.entries[pgno]
+	if !ok {
+		return nil
+	}
+	cache.lru.MoveToFront(element)
+	return element.Value.(*cachedPage)
+}
+
+// peek returns the cached page pgno without counting a hit or a miss
+func (cache *pageCache) peek(pgno Pgno) *cachedPage {
+	if element, ok := cache.entries[pgno]; ok {
+		return element.Value.(*cachedPage)
+	}
+	return nil
+}
+
+// add caches page pgno and evicts other pages beyond the capacity, writeBack writes an evicted dirty page
+func (cache *pageCache) add(pgno Pgno, data []byte, dirty bool, writeBack func(page *cachedPage) error) (error, *cachedPage) {
+	// the new page is pinned while the cache shrinks, so it is not the one evicted
+	page := &cachedPage{pgno: pgno, data: data, dirty: dirty, pins: 1}
+	cache.entries[pgno] = cache.lru.PushFront(page)
+	err := cache.shrink(writeBack)
+	page.pins--
+	return err, page
+}
+
+// shrink evicts the least recently used pages that are not pinned until the cache fits its capacity
+func (cache *pageCache) shrink(writeBack func(page *cachedPage) error) error {
+	element := cache.lru.Back()
+	for len(cache.entries) > cache.capacity && element != nil {
+		page := element.Value.(*cachedPage)
+		prev := element.Prev()
+		if page.pins == 0 {
+			if page.dirty {
+				if err := writeBack(page); err != nil {
+					return err
+				}
+				cache.stats.WriteBacks++
+			}
+			cache.lru.Remove(element)
+			delete(cache.entries, page.pgno)
+			cache.stats.Evictions++
+		}
+		element = prev
+	}
+	return nil
+}
+
+func (cache *pageCache) remove(pgno Pgno) {
+	if element, ok := cache.entries[pgno]; ok {
+		cache.lru.Remove(element)
+		delete(cache.entries, pgno)
+	}
+}
+
+// clear drops every page, the dirty ones too
+func (cache *pageCache) clear() {
+	cache.entries = make(map[Pgno]*list.Element)
+	cache.lru.Init()
+}
+
+// dirtyPages returns the pages that changed since they were last written
+func (cache *pageCache) dirtyPages() []*cachedPage {
+	var pages []*cachedPage
+	for element := cache.lru.Front(); element != nil; element = element.Next() {
+		if page := element.Value.(*cachedPage); page.dirty {
+			pages = append(pages, page)
+		}
+	}
+	return pages
+}
@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+func TestPageCacheStats(t *testing.T) {
+	_, pager := OpenPager(filepath.Join(t.TempDir(), "test.db"), 512)
+	defer pager.Close()
+	for i := 0; i < 10; i++ {
+		pager.Allocate()
+	}
+	pager.Sync()
+	pager.SetCacheSize(4)
+	if stats := pager.CacheStats(); stats.Evictions != 7 || stats.WriteBacks != 0 {
+		t.Errorf("Expected 7 clean pages evicted, got %+v", stats)
+	}
+
+	before := pager.CacheStats()
+	pager.Read(11)
+	pager.Read(11)
+	pager.Read(2)
+	stats := pager.CacheStats()
+	if stats.Hits-before.Hits != 2 || stats.Misses-before.Misses != 1 {
+		t.Errorf("Expected 2 hits and 1 miss, got %+v", stats)
+	}
+
+	// dirty pages are written back when they are evicted, writes count neither as hits nor as misses
+	before = pager.CacheStats()
+	page := make([]byte, 512)
+	for pgno := Pgno(2); pgno <= 11; pgno++ {
+		page[0] = byte(pgno)
+		pager.Write(pgno, page)
+	}
+	if stats = pager.CacheStats(); stats.WriteBacks != 6 {
+		t.Errorf("Expected 6 write-backs, got %+v", stats)
+	}
+	if stats.Hits != before.Hits || stats.Misses != before.Misses {
+		t.Errorf("Expected writes to leave hits and misses alone, got %+v after %+v", stats, before)
+	}
+	for pgno := Pgno(2); pgno <= 11; pgno++ {
+		if _, data := pager.Read(pgno); data[0] != byte(pgno) {
+			t.Errorf("Page %d lost its content after eviction", pgno)
+		}
+	}
+}
+
+func TestPageCachePinning(t *testing.T) {
+	_, pager := OpenPager(filepath.Join(t.TempDir(), "test.db"), 512)
+	defer pager.Close()
+	for i := 0; i < 20; i++ {
+		pager.Allocate()
+	}
+	pager.SetCacheBytes(3 * 512)
+	pager.Pin(2)
+	pager.Pin(2)
+	for pgno := Pgno(3); pgno <= 21; pgno++ {
+		pager.Read(pgno)
+	}
+	before := pager.CacheStats()
+	pager.Read(2)
+	if pager.CacheStats().Hits != before.Hits+1 {
+		t.Error("Expected pinned page to stay in the cache")
+	}
+
+	// the page is evicted once it is unpinned as often as it was pinned
+	pager.Unpin(2)
+	pager.Unpin(2)
+	for pgno := Pgno(3); pgno <= 21; pgno++ {
+		pager.Read(pgno)
+	}
+	before = pager.CacheStats()
+	pager.Read(2)
+	if pager.CacheStats().Misses != before.Misses+1 {
+		t.Error("Expected unpinned page to be evicted")
+	}
+}
+
+func TestBTreeWithSmallCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tree.db")
+	_, pager := OpenPager(path, 512)
+	pager.SetCacheSize(3)
+	_, btree := OpenBTree[int](pager, 0)
+	present := make(map[int]bool)
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 3000; i++ {
+		key := rng.Intn(1000)
+		if present[key] {
+			btree.Delete(key)
+			delete(present, key)
+		} else {
+			btree.Insert(key)
+			present[key] = true
+		}
+	}
+	checkTree(t, btree)
+	if stats := pager.CacheStats(); stats.WriteBacks == 0 {
+		t.Errorf("Expected dirty pages to be written back, got %+v", stats)
+	}
+	root := btree.Root()
+	pager.Close()
+
+	_, pager = OpenPager(path, 512)
+	defer pager.Close()
+	_, btree = OpenBTree[int](pager, root)
+	if keys := checkTree(t, btree); len(keys) != len(present) {
+		t.Errorf("Expected %d keys after reopening, got %d", len(present), len(keys))
+	}
+}
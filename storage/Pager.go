@@ -0,0 +1,850 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+)
+
+/*
+The database file is a sequence of pages of exactly pageSize bytes. Pages are numbered from 1, so page p
+starts at file offset (p-1)*pageSize, the same way SQLite numbers them.
+
+Page 1 starts with the database header, the rest of the file holds BTree nodes:
+
+	offset  size  description
+	0       16    magic string "SqliteDBClone 1\000"
+	16      4     page size in bytes (big-endian)
+	20      4     number of pages in the database (big-endian)
+	24      4     first freelist trunk page, 0 when no page is free (big-endian)
+	28      4     number of free pages (big-endian)
+	32      4     journal mode: 0 for the rollback journal, 1 for WAL (big-endian)
+	36      4     root page of the sequence table, 0 before a table uses autoincrement (big-endian)
+
+Freed pages are kept on a freelist like SQLite's and handed out again by Allocate. The freelist is a chain
+of trunk pages, each listing free leaf pages:
+
+	offset  size  description
+	0       4     next trunk page, 0 on the last one
+	4       4     number of leaf pages L listed in this trunk
+	8       4*L   leaf page numbers
+
+The file only shrinks when Vacuum cuts off the free pages at its end.
+
+Changes are committed either through a rollback journal (Journal.go) or through a write-ahead log (WAL.go),
+depending on the journal mode.
+
+A Pager opened with OpenSQLitePager, or on a file SQLite wrote, keeps the file in the SQLite 3 format
+instead: page 1 starts with SQLite's 100-byte header (SQLiteFile.go), which Sync keeps up to date, and
+BPlusTrees are stored as SQLite table b-trees (SQLiteCodec.go). The freelist is the same. Such a file only
+uses the rollback journal, which is not SQLite's, so SQLite must not open the file while the Pager has it
+open. Files with reserved bytes, in WAL mode, in UTF-16 or with auto-vacuum are not supported.
+*/
+
+const pagerMagic = "SqliteDBClone 1\x00"
+
+const (
+	minPageSize = 512
+	maxPageSize = 65536
+
+	headerPageSizeOffset      = 16
+	headerPageCountOffset     = 20
+	headerFreelistTrunkOffset = 24
+	headerFreelistCountOffset = 28
+	headerJournalModeOffset   = 32
+	headerSequenceRootOffset  = 36
+	headerSize                = 40
+
+	sqliteChangeCounterOffset = 24
+	sqliteSchemaCookieOffset  = 40
+	sqliteVersionValidOffset  = 92
+	sqliteVersionOffset       = 96
+
+	// sqliteVersionNumber is the SQLite version the header claims wrote the file last
+	sqliteVersionNumber = 3045000
+)
+
+// JournalMode decides how a Pager commits its changes
+type JournalMode int
+
+const (
+	// JournalRollback saves the original pages in a rollback journal and writes the changes to the database file
+	JournalRollback JournalMode = iota
+	// JournalWAL appends the changes to a write-ahead log, Checkpoint copies them to the database file
+	JournalWAL
+)
+
+// Pgno is the number of a page in the database file. Page numbers start at 1, 0 means "no page".
+type Pgno uint32
+
+// Pager reads and writes the pages of a database file through a page cache. It is safe for concurrent use.
+type Pager struct {
+	file          *os.File
+	journal       *journal // changes since the last Sync, nil when there are none
+	wal           *wal     // write-ahead log, nil unless the journal mode is JournalWAL
+	journalMode   JournalMode
+	savepoints    []*savepoint // open savepoints, the newest last
+	mu            sync.Mutex   // held by every exported method
+	pageSize      int
+	nPages        Pgno // number of pages in the database, including the header page
+	cache         *pageCache
+	freelistTrunk Pgno // first freelist trunk page
+	freeCount     int  // number of pages on the freelist, trunks included
+	sqlite        bool // the file is in the SQLite 3 format
+
+	syncs      uint64     // number of Syncs so far, a transaction's savepoints are gone once it changes
+	txLock     *txLock    // shared by the trees on the pager, see Tx.go
+	sequenceMu sync.Mutex // held while the sequence table is used, see Sequence.go
+}
+
+// OpenPager opens the database file at path, creating it when it does not exist.
+// pageSize is only used for new files, existing files keep the page size stored in their header.
+func OpenPager(path string, pageSize int) (error, *Pager) {
+	return openPager(path, pageSize, false)
+}
+
+// OpenSQLitePager is OpenPager for a file in the SQLite 3 format, it creates new files in that format
+func OpenSQLitePager(path string, pageSize int) (error, *Pager) {
+	return openPager(path, pageSize, true)
+}
+
+func openPager(path string, pageSize int, sqlite bool) (error, *Pager) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err, nil
+	}
+	if err = playbackJournal(journalPath(path), file); err != nil {
+		file.Close()
+		return err, nil
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err, nil
+	}
+
+	pager := &Pager{
+		file:   file,
+		cache:  newPageCache(DefaultCacheSize),
+		txLock: newTxLock(),
+		sqlite: sqlite,
+	}
+	if info.Size() == 0 {
+		err = pager.create(pageSize)
+	} else {
+		err = pager.readHeader()
+	}
+	if err != nil {
+		file.Close()
+		return err, nil
+	}
+	return nil, pager
+}
+
+func journalPath(path string) string {
+	return path + "-journal"
+}
+
+func walPath(path string) string {
+	return path + "-wal"
+}
+
+func validPageSize(pageSize int) bool {
+	return pageSize >= minPageSize && pageSize <= maxPageSize && pageSize&(pageSize-1) == 0
+}
+
+func (pager *Pager) create(pageSize int) error {
+	if !validPageSize(pageSize) {
+		return errors.New("page size must be a power of two between 512 and 65536")
+	}
+	pager.pageSize = pageSize
+	pager.nPages = 1
+	header := make([]byte, pageSize)
+	if pager.sqlite {
+		initSQLiteHeader(header)
+	} else {
+		copy(header, pagerMagic)
+	}
+	if err, _ := pager.cache.add(1, header, true, pager.writeBack); err != nil {
+		return err
+	}
+	return pager.sync()
+}
+
+func (pager *Pager) readHeader() error {
+	header := make([]byte, sqliteHeaderSize)
+	if _, err := pager.file.ReadAt(header, 0); err != nil {
+		if err == io.EOF {
+			return errors.New("file is not a database: header is truncated")
+		}
+		return err
+	}
+	pager.sqlite = string(header[:len(sqliteMagic)]) == sqliteMagic
+	if err := pager.parseHeader(header); err != nil {
+		return err
+	}
+	if pager.journalMode != JournalWAL {
+		return nil
+	}
+
+	// the newest header may be in the log
+	err, log := openWAL(walPath(pager.file.Name()), pager.pageSize)
+	if err != nil {
+		return err
+	}
+	pager.wal = log
+	if frame := log.find(1, log.mxFrame); frame != 0 {
+		if err, header = log.read(frame); err != nil {
+			return err
+		}
+		return pager.parseHeader(header)
+	}
+	return nil
+}
+
+func (pager *Pager) parseHeader(header []byte) error {
+	if pager.sqlite {
+		return pager.parseSQLiteHeader(header)
+	}
+	if string(header[:len(pagerMagic)]) != pagerMagic {
+		return errors.New("file is not a database: bad magic string")
+	}
+	pageSize := int(binary.BigEndian.Uint32(header[headerPageSizeOffset:]))
+	if !validPageSize(pageSize) {
+		return errors.New("file is not a database: invalid page size")
+	}
+	pager.pageSize = pageSize
+	pager.nPages = Pgno(binary.BigEndian.Uint32(header[headerPageCountOffset:]))
+	pager.freelistTrunk = Pgno(binary.BigEndian.Uint32(header[headerFreelistTrunkOffset:]))
+	pager.freeCount = int(binary.BigEndian.Uint32(header[headerFreelistCountOffset:]))
+	pager.journalMode = JournalMode(binary.BigEndian.Uint32(header[headerJournalModeOffset:]))
+	if pager.journalMode != JournalRollback && pager.journalMode != JournalWAL {
+		return errors.New("file is not a database: invalid journal mode")
+	}
+	if pager.freelistTrunk > pager.nPages || Pgno(pager.freeCount) >= pager.nPages {
+		return errors.New("file is not a database: invalid freelist")
+	}
+	return nil
+}
+
+// initSQLiteHeader writes the header of a new file in the SQLite format to page 1, followed by the empty
+// leaf of the sqlite_schema table. Sync fills in the page count and the freelist.
+func initSQLiteHeader(page []byte) {
+	copy(page, sqliteMagic)
+	// a page size of 65536 is stored as 1, and so is a content area starting at 65536 as 0
+	binary.BigEndian.PutUint16(page[16:], uint16(len(page)))
+	if len(page) == maxPageSize {
+		binary.BigEndian.PutUint16(page[16:], 1)
+	}
+	page[18], page[19] = 1, 1 // rollback journal file format
+	page[21], page[22], page[23] = 64, 32, 32
+	binary.BigEndian.PutUint32(page[44:], 4) // schema format
+	binary.BigEndian.PutUint32(page[56:], 1) // UTF-8
+	binary.BigEndian.PutUint32(page[sqliteVersionOffset:], sqliteVersionNumber)
+
+	page[sqliteHeaderSize] = sqlitePageTableLeaf
+	binary.BigEndian.PutUint16(page[sqliteHeaderSize+5:], uint16(len(page)))
+}
+
+func (pager *Pager) parseSQLiteHeader(header []byte) error {
+	if string(header[:len(sqliteMagic)]) != sqliteMagic {
+		return errors.New("file is not a database: bad magic string")
+	}
+	pageSize := int(binary.BigEndian.Uint16(header[16:]))
+	if pageSize == 1 {
+		pageSize = maxPageSize
+	}
+	if !validPageSize(pageSize) {
+		return errors.New("file is not a database: invalid page size")
+	}
+	switch {
+	case header[18] == 2 || header[19] == 2:
+		return errors.New("databases in WAL mode are not supported")
+	case header[18] != 1 || header[19] != 1:
+		return errors.New("unsupported file format version")
+	case header[20] != 0:
+		return errors.New("databases with reserved bytes are not supported")
+	case header[21] != 64 || header[22] != 32 || header[23] != 32:
+		return errors.New("file is not a database: invalid payload fractions")
+	case binary.BigEndian.Uint32(header[52:]) != 0:
+		return errors.New("auto-vacuum databases are not supported")
+	case binary.BigEndian.Uint32(header[56:]) > 1:
+		return errors.New("unsupported text encoding, only UTF-8 databases can be written")
+	}
+	pager.pageSize = pageSize
+	pager.nPages = Pgno(binary.BigEndian.Uint32(header[28:]))
+	counter := binary.BigEndian.Uint32(header[sqliteChangeCounterOffset:])
+	if pager.nPages == 0 || counter != binary.BigEndian.Uint32(header[sqliteVersionValidOffset:]) {
+		// files of old versions may not keep the page count up to date
+		info, err := pager.file.Stat()
+		if err != nil {
+			return err
+		}
+		pager.nPages = Pgno(info.Size() / int64(pageSize))
+	}
+	pager.freelistTrunk = Pgno(binary.BigEndian.Uint32(header[32:]))
+	pager.freeCount = int(binary.BigEndian.Uint32(header[36:]))
+	pager.journalMode = JournalRollback
+	if pager.freelistTrunk > pager.nPages || Pgno(pager.freeCount) >= pager.nPages {
+		return errors.New("file is not a database: invalid freelist")
+	}
+	return nil
+}
+
+func (pager *Pager) PageSize() int {
+	return pager.pageSize
+}
+
+func (pager *Pager) PageCount() Pgno {
+	pager.mu.Lock()
+	defer pager.mu.Unlock()
+	return pager.nPages
+}
+
+func (pager *Pager) JournalMode() JournalMode {
+	pager.mu.Lock()
+	defer pager.mu.Unlock()
+	return pager.journalMode
+}
+
+// SetJournalMode switches to another journal mode, committing the changes since the last Sync first.
+// Leaving WAL mode checkpoints the log and deletes it. The journal mode is stored in the database header.
+func (pager *Pager) SetJournalMode(mode JournalMode) error {
+	pager.mu.Lock()
+	defer pager.mu.Unlock()
+	if mode != JournalRollback && mode != JournalWAL {
+		return errors.New("unknown journal mode")
+	}
+	if pager.sqlite && mode == JournalWAL {
+		return errors.New("files in the SQLite format only use the rollback journal")
+	}
+	if mode == pager.journalMode {
+		return pager.sync()
+	}
+	if mode == JournalWAL {
+		// the header is committed through the rollback journal, then the log takes over.
+		// A log that is still there is left over from an earlier time in WAL mode.
+		pager.journalMode = mode
+		if err := pager.sync(); err != nil {
+			return err
+		}
+		if err := os.Remove(walPath(pager.file.Name())); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		err, log := openWAL(walPath(pager.file.Name()), pager.pageSize)
+		if err != nil {
+			return err
+		}
+		pager.wal = log
+		return nil
+	}
+
+	if err, _, _ := pager.checkpoint(CheckpointTruncate); err != nil {
+		return err
+	}
+	if err := pager.closeWAL(); err != nil {
+		return err
+	}
+	pager.journalMode = mode
+	return pager.sync()
+}
+
+// FreePageCount returns the number of pages on the freelist
+func (pager *Pager) FreePageCount() int {
+	pager.mu.Lock()
+	defer pager.mu.Unlock()
+	return pager.freeCount
+}
+
+// SetCacheSize limits the page cache to the given number of pages, evicting the least recently used ones.
+// Pinned pages stay in the cache even when there are more of them.
+func (pager *Pager) SetCacheSize(pages int) error {
+	pager.mu.Lock()
+	defer pager.mu.Unlock()
+	pager.cache.capacity = max(pages, 1)
+	return pager.cache.shrink(pager.writeBack)
+}
+
+// SetCacheBytes limits the page cache to the number of pages that fit in the given number of bytes
+func (pager *Pager) SetCacheBytes(bytes int) error {
+	return pager.SetCacheSize(bytes / pager.pageSize)
+}
+
+func (pager *Pager) CacheStats() CacheStats {
+	pager.mu.Lock()
+	defer pager.mu.Unlock()
+	return pager.cache.stats
+}
+
+// Read returns the content of page pgno. The returned slice belongs to the pager and must not be modified,
+// use Write to change a page. Unless the page is pinned, later calls may evict it from the cache,
+// so the slice must not be kept around.
+func (pager *Pager) Read(pgno Pgno) (error, []byte) {
+	pager.mu.Lock()
+	defer pager.mu.Unlock()
+	return pager.read(pgno)
+}
+
+func (pager *Pager) read(pgno Pgno) (error, []byte) {
+	err, page := pager.load(pgno)
+	if err != nil {
+		return err, nil
+	}
+	return nil, page.data
+}
+
+// Pin reads page pgno like Read and keeps it in the cache until Unpin is called as often as Pin
+func (pager *Pager) Pin(pgno Pgno) (error, []byte) {
+	pager.mu.Lock()
+	defer pager.mu.Unlock()
+	err, page := pager.load(pgno)
+	if err != nil {
+		return err, nil
+	}
+	page.pins++
+	return nil, page.data
+}
+
+func (pager *Pager) Unpin(pgno Pgno) {
+	pager.mu.Lock()
+	defer pager.mu.Unlock()
+	if page := pager.cache.peek(pgno); page != nil && page.pins > 0 {
+		page.pins--
+	}
+}
+
+// load returns the cached page pgno, reading it from the file when it is not in the cache
+func (pager *Pager) load(pgno Pgno) (error, *cachedPage) {
+	if pgno == 0 || pgno > pager.nPages {
+		return errors.New("page number out of range"), nil
+	}
+	if page := pager.cache.get(pgno); page != nil {
+		return nil, page
+	}
+	err, data := pager.readFile(pgno)
+	if err != nil {
+		return err, nil
+	}
+	return pager.cache.add(pgno, data, false, pager.writeBack)
+}
+
+// readFile reads page pgno from the log or else from the database file, bypassing the cache.
+// A page beyond the end of the file reads as zeros.
+func (pager *Pager) readFile(pgno Pgno) (error, []byte) {
+	if pager.wal != nil {
+		// the writer sees its own frames that are not committed yet
+		if frame := pager.wal.find(pgno, pager.wal.nFrames); frame != 0 {
+			return pager.wal.read(frame)
+		}
+	}
+	data := make([]byte, pager.pageSize)
+	_, err := pager.file.ReadAt(data, int64(pgno-1)*int64(pager.pageSize))
+	if err != nil && err != io.EOF {
+		return err, nil
+	}
+	return nil, data
+}
+
+// begin starts the journal for the changes up to the next Sync, unless it is already started or the log
+// is used instead. The header page is saved right away, Sync changes it.
+func (pager *Pager) begin() error {
+	if pager.journal != nil || pager.wal != nil {
+		return nil
+	}
+	err, journal := createJournal(journalPath(pager.file.Name()), pager.pageSize, pager.nPages)
+	if err != nil {
+		return err
+	}
+	pager.journal = journal
+	return pager.journalPage(1)
+}
+
+// journalPage saves the original content of page pgno in the journal before it changes for the first time
+func (pager *Pager) journalPage(pgno Pgno) error {
+	if pager.journal == nil || !pager.journal.needs(pgno) {
+		return nil
+	}
+	// a cached page that is not in the journal yet has not changed
+	if page := pager.cache.peek(pgno); page != nil {
+		return pager.journal.add(pgno, page.data)
+	}
+	err, data := pager.readFile(pgno)
+	if err != nil {
+		return err
+	}
+	return pager.journal.add(pgno, data)
+}
+
+// writeBack writes a dirty page to the file, once the originals of the pages it changes are safe in the journal.
+// In WAL mode the page is appended to the log instead, Sync commits it.
+func (pager *Pager) writeBack(page *cachedPage) error {
+	if pager.wal != nil {
+		if err := pager.wal.append(page.pgno, page.data, 0); err != nil {
+			return err
+		}
+		page.dirty = false
+		return nil
+	}
+	if pager.journal != nil {
+		if err := pager.journal.sync(); err != nil {
+			return err
+		}
+	}
+	if _, err := pager.file.WriteAt(page.data, int64(page.pgno-1)*int64(pager.pageSize)); err != nil {
+		return err
+	}
+	page.dirty = false
+	return nil
+}
+
+// Write replaces the content of page pgno. The page reaches the file on the next Sync, or earlier
+// when it is evicted from the cache.
+func (pager *Pager) Write(pgno Pgno, data []byte) error {
+	pager.mu.Lock()
+	defer pager.mu.Unlock()
+	return pager.write(pgno, data)
+}
+
+func (pager *Pager) write(pgno Pgno, data []byte) error {
+	if pgno == 0 || pgno > pager.nPages {
+		return errors.New("page number out of range")
+	}
+	if len(data) != pager.pageSize {
+		return errors.New("page data must be exactly one page long")
+	}
+	if err := pager.begin(); err != nil {
+		return err
+	}
+	if err := pager.journalPage(pgno); err != nil {
+		return err
+	}
+	if err := pager.rememberPage(pgno); err != nil {
+		return err
+	}
+	return pager.put(pgno, data)
+}
+
+// put copies data into the cached page pgno and marks it dirty
+func (pager *Pager) put(pgno Pgno, data []byte) error {
+	// a write is not a read, it counts neither as a hit nor as a miss
+	page := pager.cache.use(pgno)
+	if page == nil {
+		err, added := pager.cache.add(pgno, make([]byte, pager.pageSize), true, pager.writeBack)
+		if err != nil {
+			return err
+		}
+		page = added
+	}
+	copy(page.data, data)
+	page.dirty = true
+	return nil
+}
+
+// Allocate returns the number of a zeroed page, taken from the freelist or else appended to the database.
+func (pager *Pager) Allocate() (error, Pgno) {
+	pager.mu.Lock()
+	defer pager.mu.Unlock()
+	return pager.allocate()
+}
+
+func (pager *Pager) allocate() (error, Pgno) {
+	if err := pager.begin(); err != nil {
+		return err, 0
+	}
+	if pager.freeCount == 0 {
+		pager.nPages++
+		if pager.sqlite && pager.nPages == pendingBytePage(pager.pageSize) {
+			// SQLite locks the file at offset 1 GiB, it never uses the page there
+			pager.nPages++
+		}
+		if err := pager.journalPage(pager.nPages); err != nil {
+			return err, 0
+		}
+		if err, _ := pager.cache.add(pager.nPages, make([]byte, pager.pageSize), true, pager.writeBack); err != nil {
+			return err, 0
+		}
+		return nil, pager.nPages
+	}
+
+	err, trunk := pager.read(pager.freelistTrunk)
+	if err != nil {
+		return err, 0
+	}
+	trunk = append([]byte(nil), trunk...)
+	pgno := pager.freelistTrunk
+	if leaves := binary.BigEndian.Uint32(trunk[4:]); leaves > 0 {
+		// the last leaf of the trunk is taken first
+		pgno = Pgno(binary.BigEndian.Uint32(trunk[4+4*leaves:]))
+		binary.BigEndian.PutUint32(trunk[4:], leaves-1)
+		if err = pager.write(pager.freelistTrunk, trunk); err != nil {
+			return err, 0
+		}
+	} else {
+		pager.freelistTrunk = Pgno(binary.BigEndian.Uint32(trunk))
+	}
+	if pgno < 2 || pgno > pager.nPages {
+		return errors.New("freelist holds a page number out of range"), 0
+	}
+	pager.freeCount--
+	return pager.write(pgno, make([]byte, pager.pageSize)), pgno
+}
+
+// Free puts page pgno on the freelist, a later Allocate can return it again.
+// The page must not be used anymore.
+func (pager *Pager) Free(pgno Pgno) error {
+	pager.mu.Lock()
+	defer pager.mu.Unlock()
+	return pager.free(pgno)
+}
+
+func (pager *Pager) free(pgno Pgno) error {
+	if pgno < 2 || pgno > pager.nPages {
+		return errors.New("page number out of range")
+	}
+	if err := pager.begin(); err != nil {
+		return err
+	}
+	if pager.freelistTrunk != 0 {
+		err, trunk := pager.read(pager.freelistTrunk)
+		if err != nil {
+			return err
+		}
+		leaves := binary.BigEndian.Uint32(trunk[4:])
+		if int(leaves) < pager.trunkCapacity() {
+			trunk = append([]byte(nil), trunk...)
+			binary.BigEndian.PutUint32(trunk[8+4*leaves:], uint32(pgno))
+			binary.BigEndian.PutUint32(trunk[4:], leaves+1)
+			if err = pager.write(pager.freelistTrunk, trunk); err != nil {
+				return err
+			}
+			pager.freeCount++
+			return nil
+		}
+	}
+
+	// the page becomes the first trunk of the freelist
+	trunk := make([]byte, pager.pageSize)
+	binary.BigEndian.PutUint32(trunk, uint32(pager.freelistTrunk))
+	if err := pager.write(pgno, trunk); err != nil {
+		return err
+	}
+	pager.freelistTrunk = pgno
+	pager.freeCount++
+	return nil
+}
+
+// pendingBytePage is the page of a file in the SQLite format that holds the byte at offset 1 GiB
+func pendingBytePage(pageSize int) Pgno {
+	return Pgno(0x40000000/pageSize + 1)
+}
+
+// trunkCapacity is the number of leaf page numbers a trunk page holds
+func (pager *Pager) trunkCapacity() int {
+	return (pager.pageSize - 8) / 4
+}
+
+// freePages returns every page on the freelist, trunks included
+func (pager *Pager) freePages() (error, []Pgno) {
+	var pages []Pgno
+	for trunk := pager.freelistTrunk; trunk != 0; {
+		err, page := pager.read(trunk)
+		if err != nil {
+			return err, nil
+		}
+		pages = append(pages, trunk)
+		leaves := int(binary.BigEndian.Uint32(page[4:]))
+		for i := 0; i < leaves; i++ {
+			pages = append(pages, Pgno(binary.BigEndian.Uint32(page[8+4*i:])))
+		}
+		trunk = Pgno(binary.BigEndian.Uint32(page))
+	}
+	if len(pages) != pager.freeCount {
+		return errors.New("freelist does not hold as many pages as the header says"), nil
+	}
+	return nil, pages
+}
+
+// Vacuum removes the free pages at the end of the database and truncates the file, returning the number
+// of pages it removed. Free pages followed by pages in use stay on the freelist.
+func (pager *Pager) Vacuum() (error, int) {
+	pager.mu.Lock()
+	defer pager.mu.Unlock()
+	err, pages := pager.freePages()
+	if err != nil {
+		return err, 0
+	}
+	free := make(map[Pgno]bool, len(pages))
+	for _, pgno := range pages {
+		free[pgno] = true
+	}
+	nPages := pager.nPages
+	for free[nPages] {
+		delete(free, nPages)
+		pager.cache.remove(nPages)
+		nPages--
+	}
+	removed := int(pager.nPages - nPages)
+	if removed == 0 {
+		return nil, 0
+	}
+	if err = pager.begin(); err != nil {
+		return err, 0
+	}
+
+	// the remaining free pages get a new freelist
+	pager.nPages = nPages
+	pager.freelistTrunk = 0
+	pager.freeCount = 0
+	for _, pgno := range slices.Sorted(maps.Keys(free)) {
+		if err = pager.free(pgno); err != nil {
+			return err, 0
+		}
+	}
+	if err = pager.sync(); err != nil {
+		return err, 0
+	}
+	if pager.wal == nil {
+		// in WAL mode the next checkpoint truncates the file
+		if err = pager.file.Truncate(int64(nPages) * int64(pager.pageSize)); err != nil {
+			return err, 0
+		}
+	}
+	return nil, removed
+}
+
+// Sync commits the changes since the last Sync. With the rollback journal it writes every dirty page to the
+// file, flushes it to stable storage and deletes the journal, see Journal.go. In WAL mode it appends the dirty
+// pages to the log and flushes that, see WAL.go. It waits for a transaction open on a tree of the pager.
+func (pager *Pager) Sync() error {
+	pager.txLock.enter()
+	defer pager.txLock.leave()
+	return pager.commit()
+}
+
+// commit is Sync for a transaction, which has the pager to itself already
+func (pager *Pager) commit() error {
+	pager.mu.Lock()
+	defer pager.mu.Unlock()
+	return pager.sync()
+}
+
+func (pager *Pager) sync() error {
+	err, header := pager.read(1)
+	if err != nil {
+		return err
+	}
+	updated := append([]byte(nil), header...)
+	if pager.sqlite {
+		pager.updateSQLiteHeader(updated)
+	} else {
+		binary.BigEndian.PutUint32(updated[headerPageSizeOffset:], uint32(pager.pageSize))
+		binary.BigEndian.PutUint32(updated[headerPageCountOffset:], uint32(pager.nPages))
+		binary.BigEndian.PutUint32(updated[headerFreelistTrunkOffset:], uint32(pager.freelistTrunk))
+		binary.BigEndian.PutUint32(updated[headerFreelistCountOffset:], uint32(pager.freeCount))
+		binary.BigEndian.PutUint32(updated[headerJournalModeOffset:], uint32(pager.journalMode))
+	}
+	if !bytes.Equal(updated, header) {
+		if err = pager.write(1, updated); err != nil {
+			return err
+		}
+	}
+
+	// a commit releases every savepoint
+	pager.savepoints = nil
+	pager.syncs++
+	if pager.wal != nil {
+		return pager.commitWAL()
+	}
+	if pager.journal == nil {
+		// nothing changed
+		return nil
+	}
+	for _, page := range pager.cache.dirtyPages() {
+		if err = pager.writeBack(page); err != nil {
+			return err
+		}
+	}
+	if err = pager.file.Sync(); err != nil {
+		return err
+	}
+	err = pager.journal.delete()
+	pager.journal = nil
+	return err
+}
+
+// updateSQLiteHeader sets the page count and the freelist in the SQLite header of page 1. The change counter
+// goes up when something changed, so other SQLite connections drop what they cached of the file.
+func (pager *Pager) updateSQLiteHeader(header []byte) {
+	counter := binary.BigEndian.Uint32(header[sqliteChangeCounterOffset:])
+	if pager.journal != nil {
+		counter++
+	}
+	binary.BigEndian.PutUint32(header[sqliteChangeCounterOffset:], counter)
+	binary.BigEndian.PutUint32(header[28:], uint32(pager.nPages))
+	binary.BigEndian.PutUint32(header[32:], uint32(pager.freelistTrunk))
+	binary.BigEndian.PutUint32(header[36:], uint32(pager.freeCount))
+	binary.BigEndian.PutUint32(header[sqliteVersionValidOffset:], counter)
+	binary.BigEndian.PutUint32(header[sqliteVersionOffset:], sqliteVersionNumber)
+}
+
+// Rollback discards the changes since the last Sync. Pages read before are stale afterwards and pins are
+// dropped, since the cache is emptied. It waits for a transaction open on a tree of the pager.
+func (pager *Pager) Rollback() error {
+	pager.txLock.enter()
+	defer pager.txLock.leave()
+	return pager.rollback()
+}
+
+// rollback is Rollback for a transaction
+func (pager *Pager) rollback() error {
+	pager.mu.Lock()
+	defer pager.mu.Unlock()
+	if pager.wal != nil {
+		pager.wal.rollback()
+	} else if pager.journal != nil {
+		// the pages written back to the file already are restored from the journal, as after a crash
+		pager.journal.file.Close()
+		pager.journal = nil
+		if err := playbackJournal(journalPath(pager.file.Name()), pager.file); err != nil {
+			return err
+		}
+	}
+	pager.savepoints = nil
+	pager.cache.clear()
+	err, header := pager.readFile(1)
+	if err != nil {
+		return err
+	}
+	return pager.parseHeader(header)
+}
+
+// Close syncs the pager and closes its files. It fails with ErrTxOpen while a transaction on one of its trees
+// is open, which would otherwise become durable half done; the pager stays open then.
+func (pager *Pager) Close() error {
+	if err := pager.txLock.tryEnter(); err != nil {
+		return err
+	}
+	defer pager.txLock.leave()
+	pager.mu.Lock()
+	defer pager.mu.Unlock()
+	err := pager.sync()
+	if err == nil && pager.wal != nil {
+		// every frame goes to the database file, so the log can be removed
+		if err, _, _ = pager.checkpoint(CheckpointTruncate); err == nil {
+			err = pager.closeWAL()
+		}
+	}
+	if err != nil {
+		if pager.wal != nil {
+			pager.wal.file.Close()
+		}
+		pager.file.Close()
+		return err
+	}
+	return pager.file.Close()
+}
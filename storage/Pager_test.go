@@ -0,0 +1,255 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPagerPersistsPages(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	err, pager := OpenPager(path, 512)
+	if err != nil {
+		t.Fatalf("Unexpected error opening pager: %v", err)
+	}
+
+	err, pgno := pager.Allocate()
+	if err != nil {
+		t.Fatalf("Unexpected error allocating page: %v", err)
+	}
+	if pgno != 2 {
+		t.Errorf("Expected first allocated page to be 2, got %d", pgno)
+	}
+	page := make([]byte, 512)
+	copy(page, "hello")
+	if err = pager.Write(pgno, page); err != nil {
+		t.Fatalf("Unexpected error writing page: %v", err)
+	}
+	if err = pager.Write(pgno, page[:100]); err == nil {
+		t.Error("Expected error when writing a partial page")
+	}
+	if err = pager.Close(); err != nil {
+		t.Fatalf("Unexpected error closing pager: %v", err)
+	}
+
+	info, _ := os.Stat(path)
+	if info.Size() != 2*512 {
+		t.Errorf("Expected file of 2 pages, got %d bytes", info.Size())
+	}
+
+	err, pager = OpenPager(path, 4096)
+	if err != nil {
+		t.Fatalf("Unexpected error reopening pager: %v", err)
+	}
+	defer pager.Close()
+	if pager.PageSize() != 512 {
+		t.Errorf("Expected page size from header, got %d", pager.PageSize())
+	}
+	if pager.PageCount() != 2 {
+		t.Errorf("Expected 2 pages, got %d", pager.PageCount())
+	}
+	err, page = pager.Read(2)
+	if err != nil {
+		t.Fatalf("Unexpected error reading page: %v", err)
+	}
+	if string(page[:5]) != "hello" {
+		t.Errorf("Page content was not persisted: %q", page[:5])
+	}
+	if err, _ = pager.Read(3); err == nil {
+		t.Error("Expected error reading page past the end of the database")
+	}
+}
+
+func TestPagerRejectsInvalidFiles(t *testing.T) {
+	dir := t.TempDir()
+	if err, _ := OpenPager(filepath.Join(dir, "small.db"), 100); err == nil {
+		t.Error("Expected error for invalid page size")
+	}
+
+	path := filepath.Join(dir, "garbage.db")
+	os.WriteFile(path, []byte("this is not a database file at all"), 0644)
+	if err, _ := OpenPager(path, 512); err == nil {
+		t.Error("Expected error opening a file without database header")
+	}
+}
+
+func TestBTreeOnPager(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tree.db")
+	_, pager := OpenPager(path, 512)
+	err, btree := OpenBTree[int](pager, 0)
+	if err != nil {
+		t.Fatalf("Unexpected error creating btree: %v", err)
+	}
+	root := btree.Root()
+
+	for i := 0; i < 1000; i++ {
+		if err = btree.Insert(i * 3); err != nil {
+			t.Fatalf("Unexpected error inserting key %d: %v", i*3, err)
+		}
+	}
+	for i := 0; i < 1000; i += 2 {
+		if err, _ = btree.Delete(i * 3); err != nil {
+			t.Fatalf("Unexpected error deleting key %d: %v", i*3, err)
+		}
+	}
+	if btree.Root() != root {
+		t.Error("Root page of btree moved")
+	}
+	if err = pager.Close(); err != nil {
+		t.Fatalf("Unexpected error closing pager: %v", err)
+	}
+
+	_, pager = OpenPager(path, 512)
+	defer pager.Close()
+	err, btree = OpenBTree[int](pager, root)
+	if err != nil {
+		t.Fatalf("Unexpected error opening btree: %v", err)
+	}
+	checkTree(t, btree)
+	for i := 0; i < 1000; i++ {
+		if btree.Exists(i*3) != (i%2 == 1) {
+			t.Errorf("Unexpected presence of key %d after reopening", i*3)
+		}
+	}
+}
+
+func TestLargeKeysOnPager(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tree.db")
+	_, pager := OpenPager(path, 512)
+	_, btree := OpenBTree[string](pager, 0)
+	key := func(i int) string {
+		return strconv.Itoa(i) + strings.Repeat("x", 200*(i%10))
+	}
+	for i := 0; i < 200; i++ {
+		if err := btree.Insert(key(i)); err != nil {
+			t.Fatalf("Unexpected error inserting key %d: %v", i, err)
+		}
+	}
+	for i := 0; i < 200; i += 3 {
+		if err, _ := btree.Delete(key(i)); err != nil {
+			t.Fatalf("Unexpected error deleting key %d: %v", i, err)
+		}
+	}
+	root := btree.Root()
+	pager.Close()
+
+	_, pager = OpenPager(path, 512)
+	defer pager.Close()
+	_, btree = OpenBTree[string](pager, root)
+	checkTree(t, btree)
+	for i := 0; i < 200; i++ {
+		if btree.Exists(key(i)) != (i%3 != 0) {
+			t.Errorf("Unexpected presence of key %d after reopening", i)
+		}
+	}
+}
+
+func TestPagerFreelist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	_, pager := OpenPager(path, 512)
+	for i := 0; i < 400; i++ {
+		pager.Allocate()
+	}
+	// more pages than one trunk page can list
+	for pgno := Pgno(2); pgno <= 301; pgno++ {
+		page := make([]byte, 512)
+		page[0] = 0xff
+		pager.Write(pgno, page)
+		if err := pager.Free(pgno); err != nil {
+			t.Fatalf("Unexpected error freeing page %d: %v", pgno, err)
+		}
+	}
+	if pager.FreePageCount() != 300 {
+		t.Errorf("Expected 300 free pages, got %d", pager.FreePageCount())
+	}
+	if err := pager.Free(1); err == nil {
+		t.Error("Expected error freeing the header page")
+	}
+	pager.Close()
+
+	_, pager = OpenPager(path, 512)
+	defer pager.Close()
+	if pager.FreePageCount() != 300 {
+		t.Errorf("Expected 300 free pages after reopening, got %d", pager.FreePageCount())
+	}
+	reused := make(map[Pgno]bool)
+	for i := 0; i < 300; i++ {
+		err, pgno := pager.Allocate()
+		if err != nil {
+			t.Fatalf("Unexpected error allocating page: %v", err)
+		}
+		if pgno < 2 || pgno > 301 || reused[pgno] {
+			t.Fatalf("Allocate returned page %d", pgno)
+		}
+		reused[pgno] = true
+		if _, page := pager.Read(pgno); page[0] != 0 {
+			t.Errorf("Expected reused page %d to be zeroed", pgno)
+		}
+	}
+	if _, pgno := pager.Allocate(); pgno != 402 {
+		t.Errorf("Expected a new page once the freelist is empty, got %d", pgno)
+	}
+}
+
+func TestPagerVacuum(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	_, pager := OpenPager(path, 512)
+	for i := 0; i < 20; i++ {
+		pager.Allocate()
+	}
+	for _, pgno := range []Pgno{5, 6, 18, 19, 20, 21} {
+		pager.Free(pgno)
+	}
+	err, removed := pager.Vacuum()
+	if err != nil || removed != 4 {
+		t.Fatalf("Expected 4 pages removed, got %d (%v)", removed, err)
+	}
+	if pager.PageCount() != 17 || pager.FreePageCount() != 2 {
+		t.Errorf("Unexpected page count %d and free page count %d", pager.PageCount(), pager.FreePageCount())
+	}
+	pager.Close()
+
+	if info, _ := os.Stat(path); info.Size() != 17*512 {
+		t.Errorf("Expected file of 17 pages, got %d bytes", info.Size())
+	}
+	_, pager = OpenPager(path, 512)
+	defer pager.Close()
+	_, first := pager.Allocate()
+	_, second := pager.Allocate()
+	if first+second != 11 {
+		t.Errorf("Expected pages 5 and 6 to be reused, got %d and %d", first, second)
+	}
+}
+
+func TestBTreeReusesFreedPages(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tree.db")
+	_, pager := OpenPager(path, 512)
+	defer pager.Close()
+	_, btree := OpenBTree[string](pager, 0)
+	key := func(i int) string {
+		return strconv.Itoa(i) + strings.Repeat("x", i%700)
+	}
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 500; i++ {
+			btree.Insert(key(i))
+		}
+		for i := 0; i < 500; i++ {
+			if err, _ := btree.Delete(key(i)); err != nil {
+				t.Fatalf("Unexpected error deleting key %d: %v", i, err)
+			}
+		}
+		// every page but the header and the root is free again
+		if pager.FreePageCount() != int(pager.PageCount())-2 {
+			t.Fatalf("Expected %d free pages, got %d", pager.PageCount()-2, pager.FreePageCount())
+		}
+	}
+	pages := pager.PageCount()
+	for i := 0; i < 500; i++ {
+		btree.Insert(key(i))
+	}
+	if pager.PageCount() > pages {
+		t.Errorf("Inserting after deletes grew the file from %d to %d pages", pages, pager.PageCount())
+	}
+}
@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"SqliteDBEngine-Clone/storage/record"
+	"encoding/binary"
+	"errors"
+	"math"
+	"reflect"
+)
+
+/*
+On a Pager in the SQLite format every BPlusTree is a table b-tree as SQLite lays it out (see SQLiteFile.go),
+keyed by rowid, so SQLite reads and changes the trees written here and the other way round:
+
+  - leaves are table leaf pages, a cell holds the rowid and the record. As in SQLite, the part of a record
+    that does not fit in the cell goes to overflow pages, which are laid out like those of NodeCodec.go.
+  - interior nodes are table interior pages. The divider of a cell is the largest rowid in its left child,
+    while a separator K[i] is the smallest key right of it, so K[i] - 1 is stored.
+  - the tree whose root is page 1 is the sqlite_schema table, its root starts after the database header.
+  - leaves are not linked, a BPlusCursor finds the next leaf from the root.
+
+A page holds as many cells as fit in it, so a node is split as soon as its cells no longer fit in its page,
+even when it holds fewer than m keys, and nodes are only merged when the result fits. Every node but the
+root keeps at least one key. The keys have to be integers.
+*/
+
+// sqliteStart returns the offset of the b-tree page header in page pgno
+func sqliteStart(pgno Pgno) int {
+	if pgno == 1 {
+		return sqliteHeaderSize
+	}
+	return 0
+}
+
+// sqliteCellSize returns the space cell i of node takes in a table b-tree page, including its cell pointer
+func (node *Node[T]) sqliteCellSize(i int, pageSize int) int {
+	rowid := reflect.ValueOf(node.K[i]).Int()
+	if !node.isLeaf {
+		return 2 + 4 + record.VarintLen(uint64(rowid-1))
+	}
+	size := len(node.V[i])
+	local := localPayload(pageSize, size, true)
+	n := record.VarintLen(uint64(size)) + record.VarintLen(uint64(rowid)) + local
+	if local < size {
+		n += 4
+	}
+	// SQLite never reads a cell as shorter than 4 bytes
+	return 2 + max(n, 4)
+}
+
+// sqliteFits tells whether the cells of node fit in its page
+func (node *Node[T]) sqliteFits(pageSize int) bool {
+	used := sqliteStart(node.pgno) + 8
+	if !node.isLeaf {
+		used += 4
+	}
+	for i := 0; i < node.n && used <= pageSize; i++ {
+		used += node.sqliteCellSize(i, pageSize)
+	}
+	return used <= pageSize
+}
+
+// encodeSQLite lays the node out as a table b-tree page of pageSize bytes. Page 1 is left blank before
+// the b-tree page header, for the database header. spill writes the part of a record that does not fit
+// in its cell to overflow pages and returns the first one.
+func (node *Node[T]) encodeSQLite(pageSize int, spill func(data []byte) (error, Pgno)) (error, []byte) {
+	if keyType[T]() != keyTypeInt || !node.bplus {
+		return errors.New("only BPlusTrees with integer keys are stored in the SQLite format"), nil
+	}
+	if !node.sqliteFits(pageSize) {
+		return errors.New("node does not fit in a page"), nil
+	}
+	page := make([]byte, pageSize)
+	start := sqliteStart(node.pgno)
+	headerSize := 8
+	page[start] = sqlitePageTableLeaf
+	if !node.isLeaf {
+		headerSize = 12
+		page[start] = sqlitePageTableInterior
+		binary.BigEndian.PutUint32(page[start+8:], uint32(node.C[node.n]))
+	}
+	binary.BigEndian.PutUint16(page[start+3:], uint16(node.n))
+
+	content := pageSize
+	var cell []byte
+	for i := 0; i < node.n; i++ {
+		rowid := reflect.ValueOf(node.K[i]).Int()
+		cell = cell[:0]
+		if node.isLeaf {
+			size := len(node.V[i])
+			local := localPayload(pageSize, size, true)
+			cell = record.AppendVarint(cell, uint64(size))
+			cell = record.AppendVarint(cell, uint64(rowid))
+			cell = append(cell, node.V[i][:local]...)
+			if local < size {
+				err, overflow := spill(node.V[i][local:])
+				if err != nil {
+					return err, nil
+				}
+				cell = binary.BigEndian.AppendUint32(cell, uint32(overflow))
+			}
+			for len(cell) < 4 {
+				cell = append(cell, 0)
+			}
+		} else {
+			if rowid == math.MinInt64 {
+				return errors.New("separator has no divider in the SQLite format"), nil
+			}
+			cell = binary.BigEndian.AppendUint32(cell, uint32(node.C[i]))
+			cell = record.AppendVarint(cell, uint64(rowid-1))
+		}
+
+		content -= len(cell)
+		copy(page[content:], cell)
+		binary.BigEndian.PutUint16(page[start+headerSize+2*i:], uint16(content))
+	}
+	// a content area starting at 65536 is stored as 0
+	binary.BigEndian.PutUint16(page[start+5:], uint16(content))
+	return nil, page
+}
+
+// decodeSQLiteNode reads the node of a BPlusTree of order m stored in the table b-tree page pgno, read returns
+// the overflow pages its records continue in, out of the pages of the file. A page SQLite wrote may hold
+// more than m - 1 keys, the node is split on the next insert.
+func decodeSQLiteNode[T any](data []byte, pgno Pgno, m int, pages Pgno, read func(pgno Pgno) (error, []byte)) (error, *Node[T]) {
+	if keyType[T]() != keyTypeInt {
+		return errors.New("only BPlusTrees with integer keys are stored in the SQLite format"), nil
+	}
+	err, page := parseSQLitePage(pgno, data, len(data))
+	if err != nil {
+		return err, nil
+	}
+	if !page.isTable() {
+		return errors.New("page does not hold a table b-tree"), nil
+	}
+
+	n := len(page.cells)
+	content := nodeContent[T]{K: make([]T, n), V: make([][]byte, n), C: make([]Pgno, n+1), S: make([]int, n+1)}
+	node := newNode[T](pgno, m, page.isLeaf())
+	node.bplus = true
+	// an overflow chain takes at most every page of the file
+	capacity := uint64(pages) * uint64(len(data))
+	for i := 0; i < n; i++ {
+		err, rowid, _, _ := page.cellHeader(i)
+		if err != nil {
+			return err, nil
+		}
+		if !page.isLeaf() {
+			if rowid == math.MaxInt64 {
+				return errors.New("divider out of range"), nil
+			}
+			rowid++
+			content.C[i] = page.child(i)
+		}
+		key := reflect.ValueOf(&content.K[i]).Elem()
+		if key.OverflowInt(rowid) {
+			return errors.New("rowid out of range of the key type"), nil
+		}
+		key.SetInt(rowid)
+		if page.isLeaf() {
+			err, payload, overflow := readSQLitePayload(page, i, len(data), capacity, read)
+			if err != nil {
+				return err, nil
+			}
+			if len(payload) > 0 {
+				content.V[i] = payload
+			}
+			node.overflow = append(node.overflow, overflow...)
+		}
+	}
+	content.C[n] = page.right
+	node.setContent(content)
+	return nil, node
+}
+
+// writeTreePage is Write for a page encodeSQLite laid out, page 1 keeps the database header it has
+func (pager *Pager) writeTreePage(pgno Pgno, data []byte) error {
+	pager.mu.Lock()
+	defer pager.mu.Unlock()
+	if pgno == 1 {
+		err, header := pager.read(1)
+		if err != nil {
+			return err
+		}
+		copy(data[:sqliteHeaderSize], header)
+	}
+	return pager.write(pgno, data)
+}
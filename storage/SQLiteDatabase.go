@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"SqliteDBEngine-Clone/storage/record"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"sync"
+)
+
+/*
+A SQLiteDatabase is a database file in the SQLite 3 format, written through a Pager in that format (see
+Pager.go and SQLiteCodec.go). The sqlite3 CLI and every other SQLite tool open the file once the database
+is closed, and files SQLite wrote can be opened and changed. Its tables are Tables whose rows are records
+encoded with the record package.
+
+The sqlite_schema table on page 1 lists the tables, CreateTable adds a row ("table", name, name, root page,
+sql) to it. Indexes are not kept up to date, so tables that have some cannot be opened, and neither can
+tables with AUTOINCREMENT, whose row in sqlite_sequence is not kept. Triggers are not run.
+Changes are committed by Sync and Close of the Pager, or by the transactions of the tables.
+*/
+
+type SQLiteDatabase struct {
+	pager  *Pager
+	schema *Table
+	mu     sync.Mutex // held while the schema changes
+}
+
+// OpenSQLiteDatabase opens the database at path, creating it with pages of pageSize bytes when it does not exist
+func OpenSQLiteDatabase(path string, pageSize int) (error, *SQLiteDatabase) {
+	err, pager := OpenSQLitePager(path, pageSize)
+	if err != nil {
+		return err, nil
+	}
+	if !pager.sqlite {
+		pager.Close()
+		return errors.New("file is not in the SQLite format"), nil
+	}
+	err, schema := OpenTable(pager, 1, false)
+	if err != nil {
+		pager.Close()
+		return err, nil
+	}
+	return nil, &SQLiteDatabase{pager: pager, schema: schema}
+}
+
+func (db *SQLiteDatabase) Pager() *Pager {
+	return db.pager
+}
+
+// Schema returns the rows of the sqlite_schema table
+func (db *SQLiteDatabase) Schema() (error, []SchemaEntry) {
+	var entries []SchemaEntry
+	for _, row := range db.schema.Rows() {
+		err, values := record.Decode(row)
+		if err != nil {
+			return err, nil
+		}
+		err, entry := schemaEntry(values)
+		if err != nil {
+			return err, nil
+		}
+		entries = append(entries, entry)
+	}
+	if err := db.schema.Err(); err != nil {
+		return err, nil
+	}
+	return nil, entries
+}
+
+// CreateTable adds an empty table, sql is the CREATE TABLE statement SQLite shows for it.
+// Names are compared without regard to case, like SQLite does.
+func (db *SQLiteDatabase) CreateTable(name string, sql string) (error, *Table) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.pager.txLock.enter()
+	defer db.pager.txLock.leave()
+	err, entries := db.Schema()
+	if err != nil {
+		return err, nil
+	}
+	for _, entry := range entries {
+		if strings.EqualFold(entry.Name, name) {
+			return errors.New(entry.Type + " " + entry.Name + " already exists"), nil
+		}
+	}
+
+	err, table := OpenTable(db.pager, 0, false)
+	if err != nil {
+		return err, nil
+	}
+	err, row := record.Encode([]any{"table", name, name, int64(table.Root()), sql})
+	if err != nil {
+		return err, nil
+	}
+	if err, _ = db.schema.Insert(row); err != nil {
+		return err, nil
+	}
+	if err = db.pager.changeSchema(); err != nil {
+		return err, nil
+	}
+	return nil, table
+}
+
+// Table opens the table name, see SQLiteDatabase for the tables that cannot be opened
+func (db *SQLiteDatabase) Table(name string) (error, *Table) {
+	err, entries := db.Schema()
+	if err != nil {
+		return err, nil
+	}
+	var table *SchemaEntry
+	for i, entry := range entries {
+		switch {
+		case entry.Type == "index" && strings.EqualFold(entry.TableName, name):
+			return errors.New("table " + name + " has indexes, which are not kept up to date"), nil
+		case entry.Type == "table" && strings.EqualFold(entry.Name, name):
+			table = &entries[i]
+		}
+	}
+	switch {
+	case table == nil:
+		return errors.New("no such table: " + name), nil
+	case strings.Contains(strings.ToUpper(table.SQL), "AUTOINCREMENT"):
+		return errors.New("table " + name + " uses AUTOINCREMENT, whose sequence is not kept"), nil
+	case table.RootPage == 0:
+		return errors.New("table " + name + " is a virtual table"), nil
+	}
+	return OpenTable(db.pager, table.RootPage, false)
+}
+
+// Close commits the changes and closes the file
+func (db *SQLiteDatabase) Close() error {
+	return db.pager.Close()
+}
+
+// changeSchema bumps the schema cookie in the header, which makes SQLite read the schema again
+func (pager *Pager) changeSchema() error {
+	pager.mu.Lock()
+	defer pager.mu.Unlock()
+	err, header := pager.read(1)
+	if err != nil {
+		return err
+	}
+	updated := append([]byte(nil), header...)
+	cookie := binary.BigEndian.Uint32(updated[sqliteSchemaCookieOffset:])
+	binary.BigEndian.PutUint32(updated[sqliteSchemaCookieOffset:], cookie+1)
+	return pager.write(1, updated)
+}
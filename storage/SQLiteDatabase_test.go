@@ -0,0 +1,348 @@
+package storage
+
+import (
+	"SqliteDBEngine-Clone/storage/record"
+	"bytes"
+	"math/rand"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// document is the record of row i of the docs table, the longer ones spill to overflow pages
+func document(i int) []byte {
+	_, payload := record.Encode([]any{strings.Repeat(string(rune('a'+i%26)), 100*i*i)})
+	return payload
+}
+
+// writeSQLiteFixture writes a file with an empty table, a table of small rows spanning several levels, of which
+// the even ones are deleted again, and a table whose rows spill to overflow pages
+func writeSQLiteFixture(t *testing.T, pageSize int) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "written.db")
+	err, db := OpenSQLiteDatabase(path, pageSize)
+	if err != nil {
+		t.Fatalf("Unexpected error creating file: %v", err)
+	}
+	db.CreateTable("empty", "CREATE TABLE empty(x)")
+	_, numbers := db.CreateTable("numbers", "CREATE TABLE numbers(n INTEGER PRIMARY KEY, square INTEGER, name TEXT)")
+	for i := int64(1); i <= 6000; i++ {
+		_, payload := record.Encode([]any{nil, i * i, "number " + strconv.FormatInt(i, 10)})
+		if err = numbers.InsertWithRowid(i, payload); err != nil {
+			t.Fatalf("Unexpected error inserting row %d: %v", i, err)
+		}
+	}
+	for i := int64(2); i <= 6000; i += 2 {
+		if err, _ = numbers.Delete(i); err != nil {
+			t.Fatalf("Unexpected error deleting row %d: %v", i, err)
+		}
+	}
+	_, docs := db.CreateTable("docs", "CREATE TABLE docs(body TEXT)")
+	for i := 0; i < 20; i++ {
+		docs.Insert(document(i))
+	}
+	// a document that grows moves more of itself to overflow pages, one that shrinks frees them
+	docs.Update(3, document(25))
+	docs.Update(20, document(1))
+	if err = db.Close(); err != nil {
+		t.Fatalf("Unexpected error closing file: %v", err)
+	}
+	return path
+}
+
+func TestSQLiteDatabaseReadBack(t *testing.T) {
+	for _, pageSize := range []int{512, 4096, 65536} {
+		path := writeSQLiteFixture(t, pageSize)
+		err, file := OpenSQLiteFile(path)
+		if err != nil {
+			t.Fatalf("Unexpected error opening written file: %v", err)
+		}
+		defer file.Close()
+		if file.PageSize != pageSize {
+			t.Errorf("Expected page size %d, got %d", pageSize, file.PageSize)
+		}
+		if err, _ := file.FreelistPages(); err != nil {
+			t.Errorf("Freelist is inconsistent: %v", err)
+		}
+
+		err, schema := file.Schema()
+		if err != nil || len(schema) != 3 || schema[1].Name != "numbers" {
+			t.Fatalf("Unexpected schema %v (%v)", schema, err)
+		}
+		cursor := file.Cursor(schema[1].RootPage)
+		count := int64(0)
+		for cursor.First(); cursor.Valid(); cursor.Next() {
+			_, values := cursor.Values()
+			if rowid := cursor.Rowid(); rowid != 2*count+1 || values[1] != rowid*rowid {
+				t.Fatalf("Unexpected row %d: %v", rowid, values)
+			}
+			count++
+		}
+		if count != 3000 {
+			t.Errorf("Expected 3000 rows, got %d", count)
+		}
+
+		cursor = file.Cursor(schema[2].RootPage)
+		i := 1
+		for cursor.First(); cursor.Valid(); cursor.Next() {
+			_, payload := cursor.Payload()
+			expected := document(i - 1)
+			switch i {
+			case 3:
+				expected = document(25)
+			case 20:
+				expected = document(1)
+			}
+			if !bytes.Equal(payload, expected) {
+				t.Fatalf("Unexpected document %d", i)
+			}
+			i++
+		}
+		if cursor = file.Cursor(schema[0].RootPage); cursor.First() != nil || cursor.Valid() {
+			t.Error("Expected empty table")
+		}
+	}
+}
+
+// runSQLite runs statements on the database at path with the sqlite3 CLI, skipping the test without it
+func runSQLite(t *testing.T, path string, statements string) string {
+	t.Helper()
+	sqlite3, err := exec.LookPath("sqlite3")
+	if err != nil {
+		t.Skip("sqlite3 CLI is not installed")
+	}
+	out, err := exec.Command(sqlite3, path, statements).CombinedOutput()
+	if err != nil {
+		t.Fatalf("sqlite3 failed: %v\n%s", err, out)
+	}
+	return string(out)
+}
+
+func TestSQLiteDatabaseIntegrityCheck(t *testing.T) {
+	for _, pageSize := range []int{512, 1024, 65536} {
+		path := writeSQLiteFixture(t, pageSize)
+		out := runSQLite(t, path, "PRAGMA integrity_check; SELECT count(*), sum(square) FROM numbers; "+
+			"SELECT length(body) FROM docs WHERE rowid IN (3, 20);")
+		if expected := "ok\n3000|35999999000\n62500\n100\n"; out != expected {
+			t.Errorf("Expected sqlite3 output %q, got %q", expected, out)
+		}
+
+		// the file stays valid after SQLite changed it and it was changed again here
+		runSQLite(t, path, "INSERT INTO numbers SELECT value, value * value, 'sqlite' FROM generate_series(6001, 7000); "+
+			"DELETE FROM docs WHERE rowid > 10;")
+		err, db := OpenSQLiteDatabase(path, 0)
+		if err != nil {
+			t.Fatalf("Unexpected error reopening file: %v", err)
+		}
+		_, numbers := db.Table("numbers")
+		for i := int64(1); i <= 7000; i += 2 {
+			numbers.Delete(i)
+		}
+		if err = db.Close(); err != nil {
+			t.Fatalf("Unexpected error closing file: %v", err)
+		}
+		out = runSQLite(t, path, "PRAGMA integrity_check; SELECT count(*), min(n), max(n) FROM numbers; SELECT count(*) FROM docs;")
+		if expected := "ok\n500|6002|7000\n10\n"; out != expected {
+			t.Errorf("Expected sqlite3 output %q, got %q", expected, out)
+		}
+	}
+}
+
+func TestSQLiteDatabaseOpensSQLiteFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sqlite.db")
+	runSQLite(t, path, "PRAGMA page_size = 1024; "+
+		"CREATE TABLE t(a INTEGER PRIMARY KEY, b TEXT); "+
+		"INSERT INTO t SELECT value, substr(printf('%.*c', 300, 'x'), 1, value % 300) FROM generate_series(1, 3000); "+
+		"CREATE TABLE indexed(x); CREATE INDEX by_x ON indexed(x); "+
+		"CREATE TABLE counter(id INTEGER PRIMARY KEY AUTOINCREMENT);")
+
+	err, db := OpenSQLiteDatabase(path, 4096)
+	if err != nil {
+		t.Fatalf("Unexpected error opening file: %v", err)
+	}
+	if db.Pager().PageSize() != 1024 {
+		t.Errorf("Expected the page size of the file, got %d", db.Pager().PageSize())
+	}
+	if err, _ = db.Table("indexed"); err == nil {
+		t.Error("Expected error opening a table with an index")
+	}
+	if err, _ = db.Table("counter"); err == nil {
+		t.Error("Expected error opening a table with AUTOINCREMENT")
+	}
+	if err, _ = db.CreateTable("T", "CREATE TABLE T(x)"); err == nil {
+		t.Error("Expected error creating a table whose name differs only in case")
+	}
+
+	err, table := db.Table("t")
+	if err != nil {
+		t.Fatalf("Unexpected error opening table: %v", err)
+	}
+	count := 0
+	for rowid, row := range table.Rows() {
+		_, values := record.Decode(row)
+		if count++; rowid != int64(count) || len(values[1].(string)) != count%300 {
+			t.Fatalf("Unexpected row %d: %v", rowid, values)
+		}
+	}
+	if count != 3000 {
+		t.Errorf("Expected 3000 rows, got %d", count)
+	}
+	for i := int64(1); i <= 3000; i += 3 {
+		table.Delete(i)
+	}
+	_, payload := record.Encode([]any{nil, strings.Repeat("y", 5000)})
+	if err, rowid := table.Insert(payload); err != nil || rowid != 3001 {
+		t.Errorf("Expected rowid 3001, got %d (%v)", rowid, err)
+	}
+	_, tx := table.Begin()
+	for i := int64(2); i <= 3000; i += 3 {
+		tx.Delete(i)
+	}
+	tx.Rollback()
+	_, created := db.CreateTable("created", "CREATE TABLE created(x)")
+	_, payload = record.Encode([]any{strings.Repeat("z", 3000)})
+	created.Insert(payload)
+	if err = db.Close(); err != nil {
+		t.Fatalf("Unexpected error closing file: %v", err)
+	}
+
+	out := runSQLite(t, path, "PRAGMA integrity_check; SELECT count(*), max(a), sum(length(b)) FROM t; "+
+		"SELECT length(x) FROM created; SELECT count(*) FROM indexed;")
+	if expected := "ok\n2001|3001|304000\n3000\n0\n"; out != expected {
+		t.Errorf("Expected sqlite3 output %q, got %q", expected, out)
+	}
+}
+
+func TestSQLitePagerRejects(t *testing.T) {
+	err, db := OpenSQLiteDatabase(filepath.Join(t.TempDir(), "written.db"), 1024)
+	if err != nil {
+		t.Fatalf("Unexpected error creating file: %v", err)
+	}
+	defer db.Close()
+	db.CreateTable("t", "CREATE TABLE t(x)")
+	if err, _ := db.CreateTable("t", "CREATE TABLE t(x)"); err == nil {
+		t.Error("Expected error creating a table twice")
+	}
+	if err := db.Pager().SetJournalMode(JournalWAL); err == nil {
+		t.Error("Expected error switching a file in the SQLite format to WAL mode")
+	}
+	if err, _ := OpenBTree[int](db.Pager(), 0); err == nil {
+		t.Error("Expected error opening a BTree in a file in the SQLite format")
+	}
+	if err, _ := OpenBPlusTree[string](db.Pager(), 0); err == nil {
+		t.Error("Expected error opening a BPlusTree with string keys in a file in the SQLite format")
+	}
+	if err, _ := OpenTable(db.Pager(), 0, true); err == nil {
+		t.Error("Expected error opening an autoincrement table in a file in the SQLite format")
+	}
+}
+
+func TestSQLiteDatabaseCursorWithoutLinks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "written.db")
+	_, db := OpenSQLiteDatabase(path, 512)
+	// the row of a table with a long statement does not fit in page 1 after the header
+	sql := "CREATE TABLE t(x, y DEFAULT '" + strings.Repeat("d", 380) + "')"
+	_, table := db.CreateTable("t", sql)
+	for i := int64(1); i <= 2000; i++ {
+		_, payload := record.Encode([]any{strings.Repeat("r", int(i%50))})
+		table.InsertWithRowid(i*7%2003, payload)
+	}
+	for i := int64(0); i < 2003; i += 5 {
+		table.Delete(i)
+	}
+
+	var forward, backward []int64
+	cursor := table.tree.Cursor()
+	for cursor.First(); cursor.Valid(); cursor.Next() {
+		forward = append(forward, cursor.Key())
+	}
+	for cursor.Last(); cursor.Valid(); cursor.Prev() {
+		backward = append(backward, cursor.Key())
+	}
+	if len(forward) != 1600 || len(backward) != len(forward) {
+		t.Fatalf("Expected 1600 rows both ways, got %d and %d", len(forward), len(backward))
+	}
+	for i, rowid := range forward {
+		if i > 0 && rowid <= forward[i-1] || backward[len(backward)-1-i] != rowid {
+			t.Fatalf("Unexpected order at row %d", rowid)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Unexpected error closing file: %v", err)
+	}
+
+	out := runSQLite(t, path, "PRAGMA integrity_check; SELECT count(*) FROM t; SELECT length(sql) FROM sqlite_schema;")
+	if expected := "ok\n1600\n" + strconv.Itoa(len(sql)) + "\n"; out != expected {
+		t.Errorf("Expected sqlite3 output %q, got %q", expected, out)
+	}
+}
+
+func TestSQLiteDatabaseMixedRecordSizes(t *testing.T) {
+	for _, pageSize := range []int{512, 1024, 4096} {
+		path := filepath.Join(t.TempDir(), "written.db")
+		rng := rand.New(rand.NewSource(int64(pageSize)))
+		rows := map[int64]int{}
+		for round := 0; round < 4; round++ {
+			err, db := OpenSQLiteDatabase(path, pageSize)
+			if err != nil {
+				t.Fatalf("Unexpected error opening file: %v", err)
+			}
+			var table *Table
+			if round == 0 {
+				err, table = db.CreateTable("t", "CREATE TABLE t(x)")
+			} else {
+				err, table = db.Table("t")
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error opening table: %v", err)
+			}
+			// records of a few hundred to 1500 bytes between small ones split leaves in three, which pushes the
+			// root of a table this small past m keys
+			for i := 0; i < 2000; i++ {
+				rowid := rng.Int63n(150) + 1
+				size := rng.Intn(20)
+				if rng.Intn(3) == 0 {
+					size = 200 + rng.Intn(1300)
+				}
+				_, payload := record.Encode([]any{strings.Repeat("x", size)})
+				if _, ok := rows[rowid]; ok && rng.Intn(2) == 0 {
+					err, _ = table.Delete(rowid)
+					delete(rows, rowid)
+				} else if ok {
+					err = table.Update(rowid, payload)
+					rows[rowid] = size
+				} else {
+					err = table.InsertWithRowid(rowid, payload)
+					rows[rowid] = size
+				}
+				if err != nil {
+					t.Fatalf("Unexpected error changing row %d: %v", rowid, err)
+				}
+			}
+			if err = db.Close(); err != nil {
+				t.Fatalf("Unexpected error closing file: %v", err)
+			}
+		}
+
+		_, db := OpenSQLiteDatabase(path, 0)
+		_, table := db.Table("t")
+		count := 0
+		for rowid, row := range table.Rows() {
+			_, values := record.Decode(row)
+			if size, ok := rows[rowid]; !ok || len(values[0].(string)) != size {
+				t.Fatalf("Unexpected row %d", rowid)
+			}
+			count++
+		}
+		db.Close()
+		if count != len(rows) {
+			t.Errorf("Expected %d rows, got %d", len(rows), count)
+		}
+		out := runSQLite(t, path, "PRAGMA integrity_check; SELECT count(*) FROM t;")
+		if expected := "ok\n" + strconv.Itoa(len(rows)) + "\n"; out != expected {
+			t.Errorf("Expected sqlite3 output %q, got %q", expected, out)
+		}
+	}
+}
@@ -0,0 +1,558 @@
+package storage
+
+import (
+	"SqliteDBEngine-Clone/storage/record"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+)
+
+/*
+SQLiteFile reads database files written by SQLite 3. The file starts with a 100-byte header:
+
+	offset  size  description
+	0       16    magic string "SQLite format 3\000"
+	16      2     page size in bytes, 1 means 65536
+	20      1     bytes reserved at the end of every page
+	21      3     payload fractions, always 64, 32 and 32
+	24      4     file change counter
+	28      4     number of pages, only valid when the change counter equals the one at offset 92
+	32      4     first freelist trunk page
+	36      4     number of freelist pages
+	40      4     schema cookie
+	44      4     schema format number
+	56      4     text encoding, 1 UTF-8, 2 UTF-16le, 3 UTF-16be
+	60      4     user version
+	92      4     change counter the page count is valid for
+
+All integers are big-endian. Every page holds one b-tree node, page 1 after the header. A node starts with
+a header of 8 bytes for leaves and 12 for interior pages:
+
+	offset  size  description
+	0       1     page type: 0x02 index interior, 0x05 table interior, 0x0a index leaf, 0x0d table leaf
+	1       2     first freeblock
+	3       2     number of cells
+	5       2     start of the cell content area
+	7       1     fragmented free bytes
+	8       4     right-most child, interior pages only
+
+The header is followed by an array of 2-byte offsets of the cells, in key order. Cells are laid out as:
+
+	table interior  4-byte left child, varint rowid
+	table leaf      varint payload size, varint rowid, payload
+	index interior  4-byte left child, varint payload size, payload
+	index leaf      varint payload size, payload
+
+A payload that does not fit in its page keeps its first bytes in the cell followed by the 4-byte number
+of its first overflow page. An overflow page starts with the number of the next one and holds the rest.
+Table b-trees are B+trees keyed by rowid with records in their leaves. Index b-trees are B-trees whose
+keys are records, with the rowid of the indexed row as their last value.
+*/
+
+const sqliteMagic = "SQLite format 3\x00"
+
+const (
+	sqliteHeaderSize = 100
+
+	sqlitePageIndexInterior = 0x02
+	sqlitePageTableInterior = 0x05
+	sqlitePageIndexLeaf     = 0x0a
+	sqlitePageTableLeaf     = 0x0d
+)
+
+type SQLiteFile struct {
+	file  *os.File
+	cache *pageCache // the pages read last, they are never dirty
+
+	PageSize      int
+	ReservedSize  int // bytes at the end of every page that b-tree pages do not use
+	PageCount     Pgno
+	FreelistTrunk Pgno // first freelist trunk page, 0 when the freelist is empty
+	FreelistCount int
+	SchemaCookie  uint32
+	SchemaFormat  uint32
+	TextEncoding  uint32 // always 1, files in UTF-16 are not read
+	UserVersion   uint32
+}
+
+// SchemaEntry is a row of the sqlite_schema table on page 1
+type SchemaEntry struct {
+	Type      string // "table", "index", "view" or "trigger"
+	Name      string
+	TableName string
+	RootPage  Pgno // 0 for views and triggers
+	SQL       string
+}
+
+// OpenSQLiteFile opens the SQLite 3 database at path for reading
+func OpenSQLiteFile(path string) (error, *SQLiteFile) {
+	file, err := os.Open(path)
+	if err != nil {
+		return err, nil
+	}
+	db := &SQLiteFile{file: file, cache: newPageCache(DefaultCacheSize)}
+	if err = db.readHeader(); err != nil {
+		file.Close()
+		return err, nil
+	}
+	return nil, db
+}
+
+func (db *SQLiteFile) readHeader() error {
+	header := make([]byte, sqliteHeaderSize)
+	if _, err := db.file.ReadAt(header, 0); err != nil {
+		if err == io.EOF {
+			return errors.New("file is not a database: header is truncated")
+		}
+		return err
+	}
+	if string(header[:len(sqliteMagic)]) != sqliteMagic {
+		return errors.New("file is not a database: bad magic string")
+	}
+
+	db.PageSize = int(binary.BigEndian.Uint16(header[16:]))
+	if db.PageSize == 1 {
+		db.PageSize = maxPageSize
+	}
+	if !validPageSize(db.PageSize) {
+		return errors.New("file is not a database: invalid page size")
+	}
+	db.ReservedSize = int(header[20])
+	if db.PageSize-db.ReservedSize < 480 {
+		return errors.New("file is not a database: too many reserved bytes")
+	}
+	if header[21] != 64 || header[22] != 32 || header[23] != 32 {
+		return errors.New("file is not a database: invalid payload fractions")
+	}
+
+	db.PageCount = Pgno(binary.BigEndian.Uint32(header[28:]))
+	if db.PageCount == 0 || binary.BigEndian.Uint32(header[24:]) != binary.BigEndian.Uint32(header[92:]) {
+		// files of old versions may not keep the page count up to date
+		info, err := db.file.Stat()
+		if err != nil {
+			return err
+		}
+		db.PageCount = Pgno(info.Size() / int64(db.PageSize))
+	}
+	db.FreelistTrunk = Pgno(binary.BigEndian.Uint32(header[32:]))
+	db.FreelistCount = int(binary.BigEndian.Uint32(header[36:]))
+	db.SchemaCookie = binary.BigEndian.Uint32(header[40:])
+	db.SchemaFormat = binary.BigEndian.Uint32(header[44:])
+	db.TextEncoding = binary.BigEndian.Uint32(header[56:])
+	if db.TextEncoding != 1 {
+		return errors.New("unsupported text encoding, only UTF-8 databases can be read")
+	}
+	db.UserVersion = binary.BigEndian.Uint32(header[60:])
+	return nil
+}
+
+func (db *SQLiteFile) Close() error {
+	return db.file.Close()
+}
+
+// ReadPage returns the content of page pgno. The returned slice must not be modified.
+func (db *SQLiteFile) ReadPage(pgno Pgno) (error, []byte) {
+	if pgno == 0 || pgno > db.PageCount {
+		return errors.New("page number out of range"), nil
+	}
+	if page := db.cache.get(pgno); page != nil {
+		return nil, page.data
+	}
+	data := make([]byte, db.PageSize)
+	if _, err := db.file.ReadAt(data, int64(pgno-1)*int64(db.PageSize)); err != nil {
+		return err, nil
+	}
+	err, page := db.cache.add(pgno, data, false, nil)
+	if err != nil {
+		return err, nil
+	}
+	return nil, page.data
+}
+
+// FreelistPages returns the trunk and leaf pages of the freelist
+func (db *SQLiteFile) FreelistPages() (error, []Pgno) {
+	var pages []Pgno
+	for trunk := db.FreelistTrunk; trunk != 0; {
+		if len(pages) >= db.FreelistCount {
+			return errors.New("freelist is longer than the header says"), nil
+		}
+		err, page := db.ReadPage(trunk)
+		if err != nil {
+			return err, nil
+		}
+		pages = append(pages, trunk)
+		count := int(binary.BigEndian.Uint32(page[4:]))
+		if 8+4*count > db.PageSize-db.ReservedSize {
+			return errors.New("freelist trunk page holds too many leaves"), nil
+		}
+		for i := 0; i < count; i++ {
+			pages = append(pages, Pgno(binary.BigEndian.Uint32(page[8+4*i:])))
+		}
+		trunk = Pgno(binary.BigEndian.Uint32(page))
+	}
+	if len(pages) != db.FreelistCount {
+		return errors.New("freelist does not hold as many pages as the header says"), nil
+	}
+	return nil, pages
+}
+
+// Schema returns the rows of the sqlite_schema table
+func (db *SQLiteFile) Schema() (error, []SchemaEntry) {
+	var entries []SchemaEntry
+	cursor := db.Cursor(1)
+	var err error
+	for err = cursor.First(); err == nil && cursor.Valid(); err = cursor.Next() {
+		err, values := cursor.Values()
+		if err != nil {
+			return err, nil
+		}
+		err, entry := schemaEntry(values)
+		if err != nil {
+			return err, nil
+		}
+		entries = append(entries, entry)
+	}
+	if err != nil {
+		return err, nil
+	}
+	return nil, entries
+}
+
+// schemaEntry returns the entry the values of a sqlite_schema row describe
+func schemaEntry(values []any) (error, SchemaEntry) {
+	var entry SchemaEntry
+	if len(values) != 5 {
+		return errors.New("malformed sqlite_schema row"), entry
+	}
+	entry.Type, _ = values[0].(string)
+	entry.Name, _ = values[1].(string)
+	entry.TableName, _ = values[2].(string)
+	if root, ok := values[3].(int64); ok {
+		entry.RootPage = Pgno(root)
+	}
+	entry.SQL, _ = values[4].(string)
+	return nil, entry
+}
+
+// sqlitePage is a parsed b-tree page
+type sqlitePage struct {
+	pgno  Pgno
+	data  []byte
+	kind  byte
+	cells []int // offsets of the cells in data
+	right Pgno  // right-most child of an interior page
+}
+
+func (db *SQLiteFile) readNode(pgno Pgno) (error, *sqlitePage) {
+	err, data := db.ReadPage(pgno)
+	if err != nil {
+		return err, nil
+	}
+	return parseSQLitePage(pgno, data, db.PageSize-db.ReservedSize)
+}
+
+// parseSQLitePage parses the b-tree page pgno held in data, usable is the page size without the reserved bytes
+func parseSQLitePage(pgno Pgno, data []byte, usable int) (error, *sqlitePage) {
+	start := 0
+	if pgno == 1 {
+		start = sqliteHeaderSize
+	}
+	page := &sqlitePage{pgno: pgno, data: data, kind: data[start]}
+	headerSize := 12
+	switch page.kind {
+	case sqlitePageIndexLeaf, sqlitePageTableLeaf:
+		headerSize = 8
+	case sqlitePageIndexInterior, sqlitePageTableInterior:
+		page.right = Pgno(binary.BigEndian.Uint32(data[start+8:]))
+	default:
+		return errors.New("page is not a b-tree page"), nil
+	}
+
+	n := int(binary.BigEndian.Uint16(data[start+3:]))
+	if start+headerSize+2*n > usable {
+		return errors.New("b-tree page holds too many cells"), nil
+	}
+	// a cell of an interior page starts with its 4-byte left child
+	last := usable - 1
+	if !page.isLeaf() {
+		last = usable - 4
+	}
+	page.cells = make([]int, n)
+	for i := range page.cells {
+		offset := int(binary.BigEndian.Uint16(data[start+headerSize+2*i:]))
+		if offset < start+headerSize+2*n || offset > last {
+			return errors.New("cell offset out of range"), nil
+		}
+		page.cells[i] = offset
+	}
+	return nil, page
+}
+
+func (page *sqlitePage) isLeaf() bool {
+	return page.kind == sqlitePageIndexLeaf || page.kind == sqlitePageTableLeaf
+}
+
+func (page *sqlitePage) isTable() bool {
+	return page.kind == sqlitePageTableInterior || page.kind == sqlitePageTableLeaf
+}
+
+// child returns the page number of child i, child len(cells) is the right-most one
+func (page *sqlitePage) child(i int) Pgno {
+	if i == len(page.cells) {
+		return page.right
+	}
+	return Pgno(binary.BigEndian.Uint32(page.data[page.cells[i]:]))
+}
+
+// localPayload returns how many bytes of a payload of the given size are kept in the cell,
+// usable is the page size without the reserved bytes
+func localPayload(usable int, size int, table bool) int {
+	maxLocal := (usable-12)*64/255 - 23
+	if table {
+		maxLocal = usable - 35
+	}
+	if size <= maxLocal {
+		return size
+	}
+	minLocal := (usable-12)*32/255 - 23
+	local := minLocal + (size-minLocal)%(usable-4)
+	if local > maxLocal {
+		local = minLocal
+	}
+	return local
+}
+
+// cellHeader returns the rowid and payload size of cell i and the bytes that follow them.
+// Index cells have no rowid, table interior cells no payload.
+func (page *sqlitePage) cellHeader(i int) (error, int64, uint64, []byte) {
+	buf := page.data[page.cells[i]:]
+	if !page.isLeaf() {
+		buf = buf[4:]
+	}
+	var size, rowid uint64
+	var n int
+	var err error
+	if page.kind != sqlitePageTableInterior {
+		err, size, n = record.ReadVarint(buf)
+		if err != nil {
+			return err, 0, 0, nil
+		}
+		buf = buf[n:]
+	}
+	if page.isTable() {
+		err, rowid, n = record.ReadVarint(buf)
+		if err != nil {
+			return err, 0, 0, nil
+		}
+		buf = buf[n:]
+	}
+	return nil, int64(rowid), size, buf
+}
+
+// payload returns the payload of cell i, reassembled from its overflow pages when it spilled
+func (db *SQLiteFile) payload(page *sqlitePage, i int) (error, []byte) {
+	// a corrupt size is not trusted with the allocation, no payload is longer than the file
+	capacity := uint64(db.PageSize) * uint64(db.PageCount)
+	err, payload, _ := readSQLitePayload(page, i, db.PageSize-db.ReservedSize, capacity, db.ReadPage)
+	return err, payload
+}
+
+// readSQLitePayload returns the payload of cell i and the overflow pages read returned the rest of it from.
+// Payloads longer than capacity are rejected.
+func readSQLitePayload(page *sqlitePage, i int, usable int, capacity uint64, read func(pgno Pgno) (error, []byte)) (error, []byte, []Pgno) {
+	err, _, length, buf := page.cellHeader(i)
+	if err != nil {
+		return err, nil, nil
+	}
+	if length > capacity {
+		return errors.New("cell payload is longer than the database"), nil, nil
+	}
+	size := int(length)
+	local := localPayload(usable, size, page.isTable())
+	if local > len(buf) || local < size && local+4 > len(buf) {
+		return errors.New("cell payload exceeds its page"), nil, nil
+	}
+	payload := make([]byte, 0, size)
+	payload = append(payload, buf[:local]...)
+	overflow := Pgno(0)
+	if local < size {
+		overflow = Pgno(binary.BigEndian.Uint32(buf[local:]))
+	}
+	var pages []Pgno
+	for len(payload) < size {
+		if overflow == 0 {
+			return errors.New("overflow chain ends before the payload"), nil, nil
+		}
+		err, data := read(overflow)
+		if err != nil {
+			return err, nil, nil
+		}
+		pages = append(pages, overflow)
+		chunk := min(size-len(payload), usable-4)
+		payload = append(payload, data[4:4+chunk]...)
+		overflow = Pgno(binary.BigEndian.Uint32(data))
+	}
+	return nil, payload, pages
+}
+
+// SQLiteCursor walks the entries of a table or index b-tree of a SQLiteFile in key order:
+// the rows of a table in rowid order or the keys of an index in index order.
+type SQLiteCursor struct {
+	db    *SQLiteFile
+	root  Pgno
+	stack []sqliteFrame // path from the root to the current entry
+}
+
+// sqliteFrame is a page on the path of a SQLiteCursor, i is the index of the current cell in the last frame
+// and of the child the path continues in everywhere else
+type sqliteFrame struct {
+	page *sqlitePage
+	i    int
+}
+
+// Cursor returns a cursor over the b-tree whose root is in page root, it is not positioned yet
+func (db *SQLiteFile) Cursor(root Pgno) *SQLiteCursor {
+	return &SQLiteCursor{db: db, root: root}
+}
+
+func (cursor *SQLiteCursor) Valid() bool {
+	return len(cursor.stack) > 0
+}
+
+// First positions the cursor on the first entry
+func (cursor *SQLiteCursor) First() error {
+	cursor.stack = cursor.stack[:0]
+	return cursor.descendLeft(cursor.root)
+}
+
+// SeekRowid positions the cursor on the first row of a table whose rowid is greater than or equal to rowid
+func (cursor *SQLiteCursor) SeekRowid(rowid int64) error {
+	cursor.stack = cursor.stack[:0]
+	pgno := cursor.root
+	for {
+		err, page := cursor.push(pgno)
+		if err != nil {
+			return err
+		}
+		if !page.isTable() {
+			cursor.stack = cursor.stack[:0]
+			return errors.New("only table b-trees can be searched by rowid")
+		}
+		// the left child of a table interior cell holds the rowids less than or equal to the cell's rowid
+		i := 0
+		for ; i < len(page.cells); i++ {
+			err, key, _, _ := page.cellHeader(i)
+			if err != nil {
+				cursor.stack = cursor.stack[:0]
+				return err
+			}
+			if key >= rowid {
+				break
+			}
+		}
+		cursor.stack[len(cursor.stack)-1].i = i
+		if page.isLeaf() {
+			if i == len(page.cells) {
+				return cursor.ascendNext()
+			}
+			return nil
+		}
+		pgno = page.child(i)
+	}
+}
+
+// Next moves the cursor to the next entry, the cursor becomes invalid after the last one
+func (cursor *SQLiteCursor) Next() error {
+	if !cursor.Valid() {
+		return nil
+	}
+	top := &cursor.stack[len(cursor.stack)-1]
+	top.i++
+	if !top.page.isLeaf() {
+		// the cursor was on an index interior cell, the entries after it start in the next child
+		return cursor.descendLeft(top.page.child(top.i))
+	}
+	if top.i == len(top.page.cells) {
+		return cursor.ascendNext()
+	}
+	return nil
+}
+
+func (cursor *SQLiteCursor) descendLeft(pgno Pgno) error {
+	for {
+		err, page := cursor.push(pgno)
+		if err != nil {
+			return err
+		}
+		if page.isLeaf() {
+			if len(page.cells) == 0 {
+				// only the root of an empty tree is a leaf without cells
+				return cursor.ascendNext()
+			}
+			return nil
+		}
+		pgno = page.child(0)
+	}
+}
+
+// push reads page pgno and appends it to the path. A page that is on the path already would make the cursor
+// descend forever, the b-tree is corrupt then. On an error the cursor becomes invalid.
+func (cursor *SQLiteCursor) push(pgno Pgno) (error, *sqlitePage) {
+	for _, frame := range cursor.stack {
+		if frame.page.pgno == pgno {
+			cursor.stack = cursor.stack[:0]
+			return errors.New("b-tree page is its own descendant"), nil
+		}
+	}
+	err, page := cursor.db.readNode(pgno)
+	if err != nil {
+		cursor.stack = cursor.stack[:0]
+		return err, nil
+	}
+	cursor.stack = append(cursor.stack, sqliteFrame{page, 0})
+	return nil, page
+}
+
+// ascendNext leaves a subtree whose entries have all been visited: an index cursor moves to the interior
+// cell after it, a table cursor continues in the next child of the first page that has one
+func (cursor *SQLiteCursor) ascendNext() error {
+	cursor.stack = cursor.stack[:len(cursor.stack)-1]
+	for len(cursor.stack) > 0 {
+		top := &cursor.stack[len(cursor.stack)-1]
+		if !top.page.isTable() && top.i < len(top.page.cells) {
+			return nil
+		}
+		if top.page.isTable() && top.i < len(top.page.cells) {
+			top.i++
+			return cursor.descendLeft(top.page.child(top.i))
+		}
+		cursor.stack = cursor.stack[:len(cursor.stack)-1]
+	}
+	return nil
+}
+
+// Rowid returns the rowid of the current row of a table cursor. It must only be called when Valid is true.
+func (cursor *SQLiteCursor) Rowid() int64 {
+	top := cursor.stack[len(cursor.stack)-1]
+	_, rowid, _, _ := top.page.cellHeader(top.i)
+	return rowid
+}
+
+// Payload returns the record of the current entry, read from its overflow pages when needed
+func (cursor *SQLiteCursor) Payload() (error, []byte) {
+	if !cursor.Valid() {
+		return errors.New("cursor is not positioned on an entry"), nil
+	}
+	top := cursor.stack[len(cursor.stack)-1]
+	return cursor.db.payload(top.page, top.i)
+}
+
+// Values returns the decoded record of the current entry
+func (cursor *SQLiteCursor) Values() (error, []any) {
+	err, payload := cursor.Payload()
+	if err != nil {
+		return err, nil
+	}
+	return record.Decode(payload)
+}
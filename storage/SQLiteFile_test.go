@@ -0,0 +1,221 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// testdata/fixture.db was written by the sqlite3 CLI:
+//
+//	PRAGMA page_size=1024;
+//	CREATE TABLE people(id INTEGER PRIMARY KEY, name TEXT, age INTEGER, bio BLOB);
+//	CREATE INDEX people_name ON people(name);
+//	-- rows 1 to 500: ('person ' || i, i % 90), every 50th with a 3000-byte bio of char(65 + i % 26)
+//	CREATE TABLE scratch(x); -- filled with 200 rows and dropped again, leaving 24 free pages
+func openFixture(t *testing.T) *SQLiteFile {
+	t.Helper()
+	err, db := OpenSQLiteFile(filepath.Join("testdata", "fixture.db"))
+	if err != nil {
+		t.Fatalf("Unexpected error opening fixture: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestSQLiteFileHeader(t *testing.T) {
+	db := openFixture(t)
+	if db.PageSize != 1024 || db.PageCount != 77 || db.ReservedSize != 0 {
+		t.Errorf("Unexpected page size %d, page count %d, reserved size %d", db.PageSize, db.PageCount, db.ReservedSize)
+	}
+	if db.SchemaCookie != 4 || db.SchemaFormat != 4 || db.TextEncoding != 1 {
+		t.Errorf("Unexpected schema cookie %d, schema format %d, text encoding %d", db.SchemaCookie, db.SchemaFormat, db.TextEncoding)
+	}
+	err, free := db.FreelistPages()
+	if err != nil {
+		t.Fatalf("Unexpected error reading freelist: %v", err)
+	}
+	if len(free) != 24 || db.FreelistCount != 24 || free[0] != db.FreelistTrunk {
+		t.Errorf("Unexpected freelist %v", free)
+	}
+}
+
+func TestSQLiteFileSchema(t *testing.T) {
+	db := openFixture(t)
+	err, schema := db.Schema()
+	if err != nil {
+		t.Fatalf("Unexpected error reading schema: %v", err)
+	}
+	if len(schema) != 2 {
+		t.Fatalf("Expected 2 schema entries, got %v", schema)
+	}
+	if schema[0].Type != "table" || schema[0].Name != "people" || schema[0].RootPage != 2 {
+		t.Errorf("Unexpected table entry %+v", schema[0])
+	}
+	if schema[1].Type != "index" || schema[1].Name != "people_name" || schema[1].TableName != "people" || schema[1].RootPage != 3 {
+		t.Errorf("Unexpected index entry %+v", schema[1])
+	}
+}
+
+func TestSQLiteFileTableCursor(t *testing.T) {
+	db := openFixture(t)
+	cursor := db.Cursor(2)
+	expected := int64(1)
+	var err error
+	for err = cursor.First(); err == nil && cursor.Valid(); err = cursor.Next() {
+		if cursor.Rowid() != expected {
+			t.Fatalf("Expected rowid %d, got %d", expected, cursor.Rowid())
+		}
+		err, values := cursor.Values()
+		if err != nil {
+			t.Fatalf("Unexpected error decoding row %d: %v", expected, err)
+		}
+		// the INTEGER PRIMARY KEY column is stored as NULL, its value is the rowid
+		if values[0] != nil || values[1] != "person "+strconv.FormatInt(expected, 10) || values[2] != expected%90 {
+			t.Errorf("Unexpected row %d: %v", expected, values[:3])
+		}
+		if expected%50 == 0 {
+			// bios spill to overflow pages
+			bio := bytes.Repeat([]byte{byte(65 + expected%26)}, 3000)
+			if !bytes.Equal(values[3].([]byte), bio) {
+				t.Errorf("Unexpected bio of row %d", expected)
+			}
+		} else if values[3] != nil {
+			t.Errorf("Expected no bio in row %d", expected)
+		}
+		expected++
+	}
+	if err != nil {
+		t.Fatalf("Unexpected error scanning table: %v", err)
+	}
+	if expected != 501 {
+		t.Errorf("Scan stopped at row %d", expected)
+	}
+
+	if err = cursor.SeekRowid(250); err != nil || !cursor.Valid() || cursor.Rowid() != 250 {
+		t.Errorf("Expected seek to find row 250")
+	}
+	if cursor.SeekRowid(501); cursor.Valid() {
+		t.Errorf("Expected invalid cursor after seeking past the last row")
+	}
+}
+
+func TestSQLiteFileIndexCursor(t *testing.T) {
+	db := openFixture(t)
+	cursor := db.Cursor(3)
+	var last string
+	seen := make(map[int64]bool)
+	var err error
+	for err = cursor.First(); err == nil && cursor.Valid(); err = cursor.Next() {
+		err, values := cursor.Values()
+		if err != nil {
+			t.Fatalf("Unexpected error decoding index key: %v", err)
+		}
+		name, rowid := values[0].(string), values[1].(int64)
+		if name < last {
+			t.Fatalf("Index keys not in order: %q after %q", name, last)
+		}
+		if name != "person "+strconv.FormatInt(rowid, 10) {
+			t.Errorf("Index key %q points to row %d", name, rowid)
+		}
+		last = name
+		seen[rowid] = true
+	}
+	if err != nil {
+		t.Fatalf("Unexpected error scanning index: %v", err)
+	}
+	if len(seen) != 500 {
+		t.Errorf("Expected 500 index keys, got %d", len(seen))
+	}
+	if err = cursor.SeekRowid(1); err == nil {
+		t.Error("Expected error seeking a rowid in an index")
+	}
+}
+
+func TestSQLiteFileRejectsOtherFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clone.db")
+	_, pager := OpenPager(path, 1024)
+	pager.Close()
+	if err, _ := OpenSQLiteFile(path); err == nil {
+		t.Error("Expected error opening a file that is not a SQLite database")
+	}
+}
+
+// corruptFixture returns the path of a copy of the fixture that corrupt changed
+func corruptFixture(t *testing.T, corrupt func(data []byte)) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "fixture.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt(data)
+	path := filepath.Join(t.TempDir(), "corrupt.db")
+	if err = os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSQLiteFileRejectsCorruptFiles(t *testing.T) {
+	path := corruptFixture(t, func(data []byte) { binary.BigEndian.PutUint32(data[56:], 2) })
+	if err, _ := OpenSQLiteFile(path); err == nil {
+		t.Error("Expected error opening a UTF-16 database")
+	}
+
+	// the first cell of the table root, an interior page, points at the last 2 bytes of the page
+	path = corruptFixture(t, func(data []byte) { binary.BigEndian.PutUint16(data[1024+12:], 1022) })
+	_, db := OpenSQLiteFile(path)
+	if err := db.Cursor(2).First(); err == nil {
+		t.Error("Expected error reading a cell that runs past its page")
+	}
+	db.Close()
+
+	// the first child of the table root is the root itself
+	path = corruptFixture(t, func(data []byte) {
+		cell := 1024 + int(binary.BigEndian.Uint16(data[1024+12:]))
+		binary.BigEndian.PutUint32(data[cell:], 2)
+	})
+	_, db = OpenSQLiteFile(path)
+	cursor := db.Cursor(2)
+	if err := cursor.First(); err == nil || cursor.Valid() {
+		t.Error("Expected error descending into a page that is its own child")
+	}
+	if err := cursor.SeekRowid(1); err == nil || cursor.Valid() {
+		t.Error("Expected error seeking through a page that is its own child")
+	}
+	db.Close()
+
+	// the payload size of the first row is the largest varint there is
+	db = openFixture(t)
+	cursor = db.Cursor(2)
+	cursor.First()
+	leaf := cursor.stack[len(cursor.stack)-1].page
+	offset := int(leaf.pgno-1)*db.PageSize + leaf.cells[0]
+	path = corruptFixture(t, func(data []byte) { copy(data[offset:], bytes.Repeat([]byte{0xff}, 9)) })
+	_, db = OpenSQLiteFile(path)
+	defer db.Close()
+	cursor = db.Cursor(2)
+	if err := cursor.First(); err != nil {
+		t.Fatalf("Unexpected error positioning the cursor: %v", err)
+	}
+	if err, _ := cursor.Values(); err == nil {
+		t.Error("Expected error reading a payload longer than the database")
+	}
+}
+
+func TestSQLiteFileCacheIsBounded(t *testing.T) {
+	db := openFixture(t)
+	db.cache = newPageCache(4)
+	for pgno := Pgno(1); pgno <= db.PageCount; pgno++ {
+		db.ReadPage(pgno)
+	}
+	if len(db.cache.entries) != 4 {
+		t.Errorf("Expected 4 cached pages after reading %d, got %d", db.PageCount, len(db.cache.entries))
+	}
+	if err, _ := db.Schema(); err != nil {
+		t.Errorf("Unexpected error reading the schema through a small cache: %v", err)
+	}
+}
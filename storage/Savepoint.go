@@ -0,0 +1,112 @@
+package storage
+
+import "errors"
+
+/*
+Savepoints mark states inside the changes since the last Sync that the pager can go back to. They nest:
+every savepoint keeps, in memory, the content a page had when the savepoint was made, for the pages that
+changed after it and before the next savepoint. Going back to savepoint k restores the pages of the
+savepoints made after it, newest first, and then the ones of k itself. Pages appended after the savepoint
+are cut off again.
+
+Releasing savepoint k hands the pages it and the later ones saved to the savepoint before it, which keeps
+the first content it sees of a page. A Sync or Rollback releases every savepoint.
+*/
+
+type savepoint struct {
+	pages         map[Pgno][]byte // content of the pages when the savepoint was made
+	nPages        Pgno
+	freelistTrunk Pgno
+	freeCount     int
+}
+
+func (pager *Pager) syncCount() uint64 {
+	pager.mu.Lock()
+	defer pager.mu.Unlock()
+	return pager.syncs
+}
+
+// savepoint opens a savepoint and returns its level, the number of open savepoints
+func (pager *Pager) savepoint() int {
+	pager.mu.Lock()
+	defer pager.mu.Unlock()
+	pager.savepoints = append(pager.savepoints, &savepoint{
+		pages:         make(map[Pgno][]byte),
+		nPages:        pager.nPages,
+		freelistTrunk: pager.freelistTrunk,
+		freeCount:     pager.freeCount,
+	})
+	return len(pager.savepoints)
+}
+
+// rememberPage saves the content of page pgno in the newest savepoint before it changes
+func (pager *Pager) rememberPage(pgno Pgno) error {
+	if len(pager.savepoints) == 0 {
+		return nil
+	}
+	top := pager.savepoints[len(pager.savepoints)-1]
+	if _, ok := top.pages[pgno]; ok || pgno > top.nPages {
+		return nil
+	}
+	if page := pager.cache.peek(pgno); page != nil {
+		top.pages[pgno] = append([]byte(nil), page.data...)
+		return nil
+	}
+	err, data := pager.readFile(pgno)
+	if err != nil {
+		return err
+	}
+	top.pages[pgno] = data
+	return nil
+}
+
+// releaseSavepoint closes the savepoint at level and the ones after it, keeping their changes
+func (pager *Pager) releaseSavepoint(level int) error {
+	pager.mu.Lock()
+	defer pager.mu.Unlock()
+	if level < 1 || level > len(pager.savepoints) {
+		return errors.New("savepoint does not exist")
+	}
+	if level > 1 {
+		parent := pager.savepoints[level-2]
+		for _, released := range pager.savepoints[level-1:] {
+			for pgno, data := range released.pages {
+				if _, ok := parent.pages[pgno]; !ok && pgno <= parent.nPages {
+					parent.pages[pgno] = data
+				}
+			}
+		}
+	}
+	pager.savepoints = pager.savepoints[:level-1]
+	return nil
+}
+
+// rollbackToSavepoint undoes the changes since the savepoint at level was made. That savepoint stays open,
+// the ones after it are closed.
+func (pager *Pager) rollbackToSavepoint(level int) error {
+	pager.mu.Lock()
+	defer pager.mu.Unlock()
+	if level < 1 || level > len(pager.savepoints) {
+		return errors.New("savepoint does not exist")
+	}
+	target := pager.savepoints[level-1]
+	for i := len(pager.savepoints) - 1; i >= level-1; i-- {
+		for pgno, data := range pager.savepoints[i].pages {
+			if pgno > target.nPages {
+				continue
+			}
+			if err := pager.put(pgno, data); err != nil {
+				return err
+			}
+		}
+	}
+	for pgno := target.nPages + 1; pgno <= pager.nPages; pgno++ {
+		pager.cache.remove(pgno)
+	}
+	pager.nPages = target.nPages
+	pager.freelistTrunk = target.freelistTrunk
+	pager.freeCount = target.freeCount
+	target.pages = make(map[Pgno][]byte)
+	pager.savepoints = pager.savepoints[:level]
+	return nil
+}
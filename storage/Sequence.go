@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+/*
+The sequence table keeps the sequences of the autoincrement tables of a database file, like the
+sqlite_sequence table of SQLite. It is a BPlusTree keyed by the root page of a table, whose record is the
+8-byte big-endian sequence. Its own root page is kept in the database header and the tree is created when
+the first sequence is set. Since it is written through the pager, its changes are committed and rolled back
+with the rest of the file.
+
+The tree is opened again on every use rather than kept open, so it never holds nodes a rollback has undone.
+*/
+
+// sequence returns the sequence of the autoincrement table whose root is in page table, 0 when it has none
+func (pager *Pager) sequence(table Pgno) (error, int64) {
+	pager.sequenceMu.Lock()
+	defer pager.sequenceMu.Unlock()
+	err, root := pager.sequenceRoot()
+	if err != nil || root == 0 {
+		return err, 0
+	}
+	err, sequences := OpenBPlusTree[int64](pager, root)
+	if err != nil {
+		return err, 0
+	}
+	record, ok := sequences.Get(int64(table))
+	if !ok {
+		return nil, 0
+	}
+	if len(record) != 8 {
+		return errors.New("malformed record in the sequence table"), 0
+	}
+	return nil, int64(binary.BigEndian.Uint64(record))
+}
+
+// setSequence stores the sequence of the autoincrement table whose root is in page table.
+// The caller holds the transaction lock of the pager, see Tx.go.
+func (pager *Pager) setSequence(table Pgno, sequence int64) error {
+	pager.sequenceMu.Lock()
+	defer pager.sequenceMu.Unlock()
+	err, root := pager.sequenceRoot()
+	if err != nil {
+		return err
+	}
+	err, sequences := OpenBPlusTree[int64](pager, root)
+	if err != nil {
+		return err
+	}
+	if root == 0 {
+		if err = pager.setSequenceRoot(sequences.Root()); err != nil {
+			return err
+		}
+	}
+	return sequences.insert(int64(table), binary.BigEndian.AppendUint64(nil, uint64(sequence)), true)
+}
+
+func (pager *Pager) sequenceRoot() (error, Pgno) {
+	pager.mu.Lock()
+	defer pager.mu.Unlock()
+	err, header := pager.read(1)
+	if err != nil {
+		return err, 0
+	}
+	return nil, Pgno(binary.BigEndian.Uint32(header[headerSequenceRootOffset:]))
+}
+
+func (pager *Pager) setSequenceRoot(root Pgno) error {
+	pager.mu.Lock()
+	defer pager.mu.Unlock()
+	err, header := pager.read(1)
+	if err != nil {
+		return err
+	}
+	updated := append([]byte(nil), header...)
+	binary.BigEndian.PutUint32(updated[headerSequenceRootOffset:], uint32(root))
+	return pager.write(1, updated)
+}
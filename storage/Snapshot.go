@@ -0,0 +1,354 @@
+package storage
+
+import (
+	"errors"
+	"iter"
+	"sync"
+)
+
+/*
+A Snapshot reads the tree as it was at the last commit when the snapshot was taken, without taking the lock
+of the tree, so readers of a snapshot never wait for writers and never see half of a change. Every change
+made outside a transaction is committed when it returns, the changes of a Tx when it commits.
+
+The tree keeps the versions of its nodes. Before a writer changes a node for the first time after the last
+commit, the node as it was is kept as an old version, tagged with the commit that replaces it. A snapshot
+of commit s reads the oldest version that was replaced after s, or the current node when there is none.
+Nodes of an in-memory tree are changed in place, so the writer gets a copy of the node and the old one stays
+untouched for the readers that still hold it. Old versions no snapshot can read anymore are dropped.
+*/
+
+var ErrSnapshotReleased = errors.New("snapshot has been released")
+
+// versionedNodes is the nodeStore of a BTree, keeping old versions of the nodes of its inner store
+type versionedNodes[T any] struct {
+	nodeStore[T]
+	mem  *memNodes[T] // the inner store of an in-memory tree, nil on a pager
+	root Pgno
+
+	mu        sync.Mutex // guards the fields below and the inner store against readers of snapshots
+	committed uint64     // the last commit, the next one is committed+1
+	writing   bool       // a change since the last commit has begun
+	old       map[Pgno][]nodeVersion[T]
+	snapshots map[uint64]int // number of live snapshots of each commit
+}
+
+// nodeVersion is a node as it was until commit until replaced it, nil when the node did not exist yet
+type nodeVersion[T any] struct {
+	node  *Node[T]
+	until uint64
+}
+
+func newVersionedNodes[T any](inner nodeStore[T]) *versionedNodes[T] {
+	versions := &versionedNodes[T]{
+		nodeStore: inner,
+		old:       make(map[Pgno][]nodeVersion[T]),
+		snapshots: make(map[uint64]int),
+	}
+	versions.mem, _ = inner.(*memNodes[T])
+	return versions
+}
+
+// capture keeps node as an old version before the writer changes it for the first time since the last
+// commit, and returns the node the writer may change
+func (versions *versionedNodes[T]) capture(node *Node[T]) *Node[T] {
+	pending := versions.committed + 1
+	kept := versions.old[node.pgno]
+	if len(kept) > 0 && kept[len(kept)-1].until == pending {
+		return node
+	}
+	if versions.mem == nil {
+		// nodes loaded from a pager are copies already
+		versions.old[node.pgno] = append(kept, nodeVersion[T]{node.clone(), pending})
+		return node
+	}
+	versions.old[node.pgno] = append(kept, nodeVersion[T]{node, pending})
+	working := node.clone()
+	versions.mem.nodes[node.pgno] = working
+	return working
+}
+
+func (versions *versionedNodes[T]) load(pgno Pgno) (error, *Node[T]) {
+	versions.mu.Lock()
+	defer versions.mu.Unlock()
+	err, node := versions.nodeStore.load(pgno)
+	if err != nil || !versions.writing {
+		return err, node
+	}
+	return nil, versions.capture(node)
+}
+
+func (versions *versionedNodes[T]) alloc(leaf bool) (error, *Node[T]) {
+	versions.mu.Lock()
+	defer versions.mu.Unlock()
+	err, node := versions.nodeStore.alloc(leaf)
+	if err == nil && versions.writing {
+		// snapshots cannot reach the new node, this only keeps it from being captured
+		pending := versions.committed + 1
+		versions.old[node.pgno] = append(versions.old[node.pgno], nodeVersion[T]{nil, pending})
+	}
+	return err, node
+}
+
+func (versions *versionedNodes[T]) free(node *Node[T]) error {
+	versions.mu.Lock()
+	defer versions.mu.Unlock()
+	return versions.nodeStore.free(node)
+}
+
+func (versions *versionedNodes[T]) begin(root *Node[T]) error {
+	versions.mu.Lock()
+	defer versions.mu.Unlock()
+	return versions.nodeStore.begin(root)
+}
+
+func (versions *versionedNodes[T]) commit() error {
+	versions.mu.Lock()
+	defer versions.mu.Unlock()
+	if err := versions.nodeStore.commit(); err != nil {
+		return err
+	}
+	versions.publish()
+	return nil
+}
+
+// rollback undoes the changes since the last commit, the old versions they made are dropped
+func (versions *versionedNodes[T]) rollback() error {
+	versions.mu.Lock()
+	defer versions.mu.Unlock()
+	err := versions.nodeStore.rollback()
+	pending := versions.committed + 1
+	for pgno, kept := range versions.old {
+		for len(kept) > 0 && kept[len(kept)-1].until == pending {
+			kept = kept[:len(kept)-1]
+		}
+		if len(kept) == 0 {
+			delete(versions.old, pgno)
+		} else {
+			versions.old[pgno] = kept
+		}
+	}
+	versions.writing = false
+	return err
+}
+
+func (versions *versionedNodes[T]) savepoint(root *Node[T]) (error, int) {
+	versions.mu.Lock()
+	defer versions.mu.Unlock()
+	return versions.nodeStore.savepoint(root)
+}
+
+func (versions *versionedNodes[T]) release(level int) error {
+	versions.mu.Lock()
+	defer versions.mu.Unlock()
+	return versions.nodeStore.release(level)
+}
+
+func (versions *versionedNodes[T]) rollbackTo(level int) error {
+	versions.mu.Lock()
+	defer versions.mu.Unlock()
+	return versions.nodeStore.rollbackTo(level)
+}
+
+// startWrite is called before a change touches the tree, it returns the root the writer may change
+func (versions *versionedNodes[T]) startWrite(root *Node[T]) *Node[T] {
+	versions.mu.Lock()
+	defer versions.mu.Unlock()
+	versions.writing = true
+	return versions.capture(root)
+}
+
+// publish makes the changes since the last commit the newest commit. mu must be held.
+func (versions *versionedNodes[T]) publish() {
+	if !versions.writing {
+		return
+	}
+	versions.committed++
+	versions.writing = false
+	versions.collect()
+}
+
+// collect drops the old versions that no live snapshot reads anymore. mu must be held.
+func (versions *versionedNodes[T]) collect() {
+	oldest := versions.committed
+	for version := range versions.snapshots {
+		oldest = min(oldest, version)
+	}
+	for pgno, kept := range versions.old {
+		i := 0
+		for i < len(kept) && kept[i].until <= oldest {
+			i++
+		}
+		if i == len(kept) {
+			delete(versions.old, pgno)
+		} else {
+			versions.old[pgno] = kept[i:]
+		}
+	}
+}
+
+// read returns node pgno as it was at commit version
+func (versions *versionedNodes[T]) read(pgno Pgno, version uint64) (error, *Node[T]) {
+	versions.mu.Lock()
+	defer versions.mu.Unlock()
+	return versions.readVersion(pgno, version)
+}
+
+// lastCommit returns the last commit
+func (versions *versionedNodes[T]) lastCommit() uint64 {
+	versions.mu.Lock()
+	defer versions.mu.Unlock()
+	return versions.committed
+}
+
+// pin keeps the old versions of the nodes that the last commit reads until unpin, and returns the commit
+func (versions *versionedNodes[T]) pin() uint64 {
+	versions.mu.Lock()
+	defer versions.mu.Unlock()
+	versions.snapshots[versions.committed]++
+	return versions.committed
+}
+
+func (versions *versionedNodes[T]) unpin(version uint64) {
+	versions.mu.Lock()
+	defer versions.mu.Unlock()
+	versions.unpinLocked(version)
+}
+
+// unpinLocked is unpin for a caller that holds mu
+func (versions *versionedNodes[T]) unpinLocked(version uint64) {
+	if versions.snapshots[version]--; versions.snapshots[version] == 0 {
+		delete(versions.snapshots, version)
+	}
+	versions.collect()
+}
+
+// committedRoot returns root, the current root node, as it was at the last commit
+func (versions *versionedNodes[T]) committedRoot(root *Node[T]) *Node[T] {
+	versions.mu.Lock()
+	defer versions.mu.Unlock()
+	for _, kept := range versions.old[root.pgno] {
+		if kept.until > versions.committed {
+			return kept.node
+		}
+	}
+	return root
+}
+
+// readVersion is read for a caller that holds mu
+func (versions *versionedNodes[T]) readVersion(pgno Pgno, version uint64) (error, *Node[T]) {
+	for _, kept := range versions.old[pgno] {
+		if kept.until > version {
+			if kept.node == nil {
+				return errors.New("node does not exist in snapshot"), nil
+			}
+			return nil, kept.node
+		}
+	}
+	if versions.mem != nil {
+		// the undo log of a transaction must not see the readers
+		node, ok := versions.mem.nodes[pgno]
+		if !ok {
+			return errors.New("node does not exist"), nil
+		}
+		return nil, node
+	}
+	return versions.nodeStore.load(pgno)
+}
+
+// versionNodes is the store of a view of a tree at commit version, see BTree.reader and BPlusTree.snapshot.
+// A view is only read, so load is the only method called.
+type versionNodes[T any] struct {
+	nodeStore[T]
+	versions *versionedNodes[T]
+	version  uint64
+}
+
+func (store versionNodes[T]) load(pgno Pgno) (error, *Node[T]) {
+	return store.versions.read(pgno, store.version)
+}
+
+// beginWrite is called by every change to the tree before it touches a node
+func (btree *BTree[T]) beginWrite() {
+	btree.root = btree.versions.startWrite(btree.root)
+}
+
+// endWrite commits a change that does not belong to a transaction
+func (btree *BTree[T]) endWrite() {
+	if btree.tx == nil {
+		btree.versions.mu.Lock()
+		btree.versions.publish()
+		btree.versions.mu.Unlock()
+	}
+}
+
+// Snapshot is a read-only view of a BTree at one commit. It has to be released when it is not needed anymore,
+// so the tree can drop the old versions of its nodes.
+type Snapshot[T any] struct {
+	btree      *BTree[T]
+	version    uint64
+	released   bool
+	iterations iterationErr
+}
+
+// Snapshot returns a view of the tree at its last commit. It does not wait for a writer.
+func (btree *BTree[T]) Snapshot() *Snapshot[T] {
+	return &Snapshot[T]{btree: btree, version: btree.versions.pin()}
+}
+
+// Release ends the snapshot, later reads fail with ErrSnapshotReleased
+func (snapshot *Snapshot[T]) Release() {
+	versions := snapshot.btree.versions
+	versions.mu.Lock()
+	defer versions.mu.Unlock()
+	if snapshot.released {
+		return
+	}
+	snapshot.released = true
+	versions.unpinLocked(snapshot.version)
+}
+
+func (snapshot *Snapshot[T]) load(pgno Pgno) (error, *Node[T]) {
+	if snapshot.released {
+		return ErrSnapshotReleased, nil
+	}
+	return snapshot.btree.versions.read(pgno, snapshot.version)
+}
+
+// Cursor returns a cursor over the snapshot that is not positioned yet
+func (snapshot *Snapshot[T]) Cursor() *Cursor[T] {
+	return &Cursor[T]{btree: snapshot.btree, snapshot: snapshot}
+}
+
+func (snapshot *Snapshot[T]) Exists(key T) bool {
+	err, count := snapshot.Count(key)
+	return err == nil && count > 0
+}
+
+// Count returns the number of copies of key
+func (snapshot *Snapshot[T]) Count(key T) (error, int) {
+	return countKey(snapshot.Cursor(), key)
+}
+
+// The iterators of a snapshot work like those of a BTree, see Iterators.go
+
+func (snapshot *Snapshot[T]) All() iter.Seq[T] {
+	return checkedKeys(allKeys(snapshot.Cursor), &snapshot.iterations)
+}
+
+func (snapshot *Snapshot[T]) Ascend(from T) iter.Seq[T] {
+	return checkedKeys(ascendKeys(snapshot.Cursor, from), &snapshot.iterations)
+}
+
+func (snapshot *Snapshot[T]) Descend(from T) iter.Seq[T] {
+	return checkedKeys(descendKeys(snapshot.Cursor, from), &snapshot.iterations)
+}
+
+func (snapshot *Snapshot[T]) Range(lo T, hi T) iter.Seq[T] {
+	return checkedKeys(rangeKeys(snapshot.Cursor, lo, hi), &snapshot.iterations)
+}
+
+// Err returns the error that ended the last loop over an iterator of the snapshot early, see BTree.Err
+func (snapshot *Snapshot[T]) Err() error {
+	return snapshot.iterations.get()
+}
@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// checkSnapshots changes an empty btree while snapshots of it are open, the snapshots have to keep
+// seeing the tree as it was when they were taken
+func checkSnapshots(t *testing.T, btree *BTree[int]) {
+	t.Helper()
+	for i := 0; i < 200; i++ {
+		btree.Insert(i)
+	}
+	before := btree.Snapshot()
+	for i := 200; i < 1000; i++ {
+		btree.Insert(i)
+	}
+	for i := 0; i < 100; i++ {
+		btree.Delete(i)
+	}
+	if keys := collectKeys(t, before, before.All()); len(keys) != 200 || keys[0] != 0 || keys[199] != 199 {
+		t.Fatalf("Expected the snapshot to hold the keys 0 to 199, got %d keys", len(keys))
+	}
+	if !before.Exists(50) || before.Exists(500) {
+		t.Error("Expected the snapshot to hold 50 but not 500")
+	}
+	if keys := collectKeys(t, before, before.Descend(10)); len(keys) != 11 || keys[0] != 10 {
+		t.Errorf("Expected to descend from 10 in the snapshot, got %v", keys)
+	}
+
+	// the changes of an open transaction stay hidden until it commits
+	_, tx := btree.Begin()
+	for i := 1000; i < 1500; i++ {
+		tx.Insert(i)
+	}
+	during := btree.Snapshot()
+	if keys := collectKeys(t, during, during.Range(0, 2000)); len(keys) != 900 || keys[899] != 999 {
+		t.Errorf("Expected the keys 100 to 999 during the transaction, got %d keys", len(keys))
+	}
+	tx.Rollback()
+	_, tx = btree.Begin()
+	tx.Insert(2000)
+	if during.Exists(2000) {
+		t.Error("Expected the snapshot not to see an uncommitted key")
+	}
+	tx.Commit()
+	if during.Exists(2000) || !btree.Snapshot().Exists(2000) {
+		t.Error("Expected a committed key in new snapshots only")
+	}
+	if keys := collectKeys(t, before, before.All()); len(keys) != 200 {
+		t.Errorf("Expected the first snapshot to still hold 200 keys, got %d", len(keys))
+	}
+	if keys := checkTree(t, btree); len(keys) != 901 {
+		t.Errorf("Expected 901 keys in the tree, got %d", len(keys))
+	}
+
+	before.Release()
+	if err := before.Cursor().First(); err != ErrSnapshotReleased {
+		t.Errorf("Expected ErrSnapshotReleased, got %v", err)
+	}
+	during.Release()
+}
+
+func TestSnapshotInMemory(t *testing.T) {
+	_, btree := NewBTree[int](pageSize(4))
+	checkSnapshots(t, btree)
+}
+
+func TestSnapshotOnPager(t *testing.T) {
+	for _, mode := range []JournalMode{JournalRollback, JournalWAL} {
+		_, pager := OpenPager(filepath.Join(t.TempDir(), "tree.db"), 512)
+		pager.SetJournalMode(mode)
+		pager.SetCacheSize(5)
+		_, btree := OpenBTree[int](pager, 0)
+		checkSnapshots(t, btree)
+		pager.Close()
+	}
+}
+
+func TestSnapshotReleaseDropsVersions(t *testing.T) {
+	_, btree := NewBTree[int](pageSize(4))
+	for i := 0; i < 500; i++ {
+		btree.Insert(i)
+	}
+	if len(btree.versions.old) != 0 {
+		t.Errorf("Expected no old versions without snapshots, got %d", len(btree.versions.old))
+	}
+	snapshot := btree.Snapshot()
+	for i := 0; i < 500; i += 2 {
+		btree.Delete(i)
+	}
+	if len(btree.versions.old) == 0 {
+		t.Error("Expected old versions to be kept for the snapshot")
+	}
+	snapshot.Release()
+	snapshot.Release()
+	if len(btree.versions.old) != 0 {
+		t.Errorf("Expected the old versions to be dropped on release, %d are left", len(btree.versions.old))
+	}
+}
+
+func TestSnapshotConcurrentReaders(t *testing.T) {
+	_, memTree := NewBTree[int](pageSize(4))
+	_, pager := OpenPager(filepath.Join(t.TempDir(), "tree.db"), 512)
+	defer pager.Close()
+	pager.SetCacheSize(10)
+	_, pagerTree := OpenBTree[int](pager, 0)
+
+	for _, btree := range []*BTree[int]{memTree, pagerTree} {
+		// the writer only adds keys in batches of ten, so every snapshot holds a multiple of ten keys
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 2000; i += 10 {
+				_, tx := btree.Begin()
+				for j := i; j < i+10; j++ {
+					tx.Insert(j)
+				}
+				tx.Commit()
+			}
+		}()
+		for r := 0; r < 4; r++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for k := 0; k < 50; k++ {
+					snapshot := btree.Snapshot()
+					keys := collectKeys(t, snapshot, snapshot.All())
+					if len(keys)%10 != 0 || !slices.IsSorted(keys) {
+						t.Errorf("Snapshot holds %d keys", len(keys))
+					}
+					snapshot.Release()
+				}
+			}()
+		}
+		wg.Wait()
+		if keys := checkTree(t, btree); len(keys) != 2000 {
+			t.Errorf("Expected 2000 keys, got %d", len(keys))
+		}
+		if len(btree.versions.old) != 0 {
+			t.Errorf("Expected no old versions once every snapshot is released, got %d", len(btree.versions.old))
+		}
+	}
+}
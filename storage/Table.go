@@ -0,0 +1,289 @@
+package storage
+
+import (
+	"errors"
+	"iter"
+	"math"
+	"math/rand"
+	"sync"
+)
+
+/*
+Table stores rows in a BPlusTree keyed by their rowid, the way SQLite stores a table with an
+INTEGER PRIMARY KEY. Every row is an encoded record, the table does not look inside it.
+
+Insert picks the rowid of a new row like SQLite does: one more than the largest rowid in the table,
+or 1 for an empty table. Once the largest rowid is math.MaxInt64, unused rowids are picked at random.
+Rowids of deleted rows can therefore be reused. A table created with autoincrement never reuses a rowid:
+it remembers the largest rowid it ever held in its sequence and fails with ErrTableFull once that
+sequence reaches math.MaxInt64. The sequence of a table in a file is kept in the sequence table of its
+pager, see Sequence.go.
+
+A Table is safe for concurrent use. Every change holds the lock of the table, so two inserts never pick the
+same rowid, and the sequence always moves together with the rows. Reads only take the lock of the tree.
+
+Begin starts a transaction on the table, its rows and its sequence. Like the one of a BTree, it holds back the
+changes to the table from outside it, and on a Pager those to every tree of the pager, see Tx.go.
+*/
+
+var ErrTableFull = errors.New("table is full: no unused rowid left")
+
+// rowidAttempts is the number of random rowids tried before a table counts as full
+const rowidAttempts = 100
+
+type Table struct {
+	tree          *BPlusTree[int64]
+	pager         *Pager // nil for a table kept in memory
+	autoincrement bool
+	sequence      int64      // largest rowid ever inserted, only kept with autoincrement in memory
+	tx            *TableTx   // open transaction, nil when there is none
+	mu            sync.Mutex // held by every change and by Sequence
+}
+
+// TableTx groups changes to a Table, so that either all of them or none become permanent
+type TableTx struct {
+	table    *Table
+	sequence int64 // sequence of a table in memory when the transaction began
+	done     bool
+}
+
+func NewTable(pageSize int, autoincrement bool) (error, *Table) {
+	err, tree := NewBPlusTree[int64](pageSize)
+	if err != nil {
+		return err, nil
+	}
+	return nil, &Table{tree: tree, autoincrement: autoincrement}
+}
+
+// OpenTable opens the table whose root node is stored in page root of pager, a root of 0 creates a new table.
+// A file in the SQLite format has no sequence table, so its tables cannot use autoincrement.
+func OpenTable(pager *Pager, root Pgno, autoincrement bool) (error, *Table) {
+	if autoincrement && pager.sqlite {
+		return errors.New("tables in a file in the SQLite format cannot use autoincrement"), nil
+	}
+	err, tree := OpenBPlusTree[int64](pager, root)
+	if err != nil {
+		return err, nil
+	}
+	return nil, &Table{tree: tree, pager: pager, autoincrement: autoincrement}
+}
+
+// Root returns the page number of the root node
+func (table *Table) Root() Pgno {
+	return table.tree.Root()
+}
+
+// Sequence returns the largest rowid the table ever held, it is 0 without autoincrement
+func (table *Table) Sequence() (error, int64) {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	return table.currentSequence()
+}
+
+func (table *Table) currentSequence() (error, int64) {
+	if !table.autoincrement || table.pager == nil {
+		return nil, table.sequence
+	}
+	return table.pager.sequence(table.Root())
+}
+
+func (table *Table) setSequence(sequence int64) error {
+	if table.pager == nil {
+		table.sequence = sequence
+		return nil
+	}
+	return table.pager.setSequence(table.Root(), sequence)
+}
+
+// write makes change to the table as a write of tx, or outside a transaction when tx is nil
+func (table *Table) write(tx *TableTx, change func() error) error {
+	if tx == nil {
+		table.tree.txLock.enter()
+		defer table.tree.txLock.leave()
+	}
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	if tx != nil && table.tx != tx {
+		return ErrTxDone
+	}
+	return change()
+}
+
+// Insert adds a row and returns the rowid it was given
+func (table *Table) Insert(record []byte) (error, int64) {
+	return table.insertRow(nil, record)
+}
+
+func (table *Table) insertRow(tx *TableTx, record []byte) (error, int64) {
+	var rowid int64
+	err := table.write(tx, func() error {
+		var err error
+		if err, rowid = table.nextRowid(); err != nil {
+			return err
+		}
+		return table.insert(rowid, record)
+	})
+	return err, rowid
+}
+
+// InsertWithRowid adds a row with the given rowid, failing with ErrDuplicateKey when the rowid is taken
+func (table *Table) InsertWithRowid(rowid int64, record []byte) error {
+	return table.write(nil, func() error { return table.insert(rowid, record) })
+}
+
+func (table *Table) insert(rowid int64, record []byte) error {
+	if err := table.tree.insert(rowid, record, false); err != nil {
+		return err
+	}
+	if !table.autoincrement {
+		return nil
+	}
+	err, sequence := table.currentSequence()
+	if err != nil || rowid <= sequence {
+		return err
+	}
+	return table.setSequence(rowid)
+}
+
+func (table *Table) nextRowid() (error, int64) {
+	err, largest := table.maxRowid()
+	if err != nil {
+		return err, 0
+	}
+	if table.autoincrement {
+		err, sequence := table.currentSequence()
+		if err != nil {
+			return err, 0
+		}
+		largest = max(largest, sequence)
+		if largest == math.MaxInt64 {
+			return ErrTableFull, 0
+		}
+		return nil, largest + 1
+	}
+	if largest < math.MaxInt64 {
+		return nil, largest + 1
+	}
+	for i := 0; i < rowidAttempts; i++ {
+		rowid := rand.Int63n(math.MaxInt64) + 1
+		if !table.tree.Exists(rowid) {
+			return nil, rowid
+		}
+	}
+	return ErrTableFull, 0
+}
+
+// maxRowid returns the largest rowid in the table, or 0 when it is empty
+func (table *Table) maxRowid() (error, int64) {
+	cursor := table.tree.Cursor()
+	if err := cursor.Last(); err != nil {
+		return err, 0
+	}
+	if !cursor.Valid() {
+		return nil, 0
+	}
+	return nil, cursor.Key()
+}
+
+// Get returns the record of the row with the given rowid
+func (table *Table) Get(rowid int64) ([]byte, bool) {
+	return table.tree.Get(rowid)
+}
+
+// Update replaces the record of an existing row
+func (table *Table) Update(rowid int64, record []byte) error {
+	return table.write(nil, func() error { return table.update(rowid, record) })
+}
+
+func (table *Table) update(rowid int64, record []byte) error {
+	if !table.tree.Exists(rowid) {
+		return ErrKeyNotFound
+	}
+	return table.tree.insert(rowid, record, true)
+}
+
+// Delete removes the row with the given rowid and returns its record
+func (table *Table) Delete(rowid int64) (error, []byte) {
+	return table.deleteRow(nil, rowid)
+}
+
+func (table *Table) deleteRow(tx *TableTx, rowid int64) (error, []byte) {
+	var record []byte
+	err := table.write(tx, func() error {
+		var err error
+		err, record = table.tree.remove(rowid)
+		return err
+	})
+	return err, record
+}
+
+// Rows returns every row in rowid order, as the table was when the loop started
+func (table *Table) Rows() iter.Seq2[int64, []byte] {
+	return table.tree.All()
+}
+
+// Err returns the error that ended the last loop over Rows early, see BTree.Err
+func (table *Table) Err() error {
+	return table.tree.Err()
+}
+
+// Begin starts a transaction, once the changes to the table going on are done.
+// It fails while another transaction is open on the table, or on its Pager.
+func (table *Table) Begin() (error, *TableTx) {
+	if err := table.tree.txLock.begin(); err != nil {
+		return err, nil
+	}
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	if err := table.tree.begin(); err != nil {
+		table.tree.txLock.end()
+		return err, nil
+	}
+	table.tx = &TableTx{table: table, sequence: table.sequence}
+	return nil, table.tx
+}
+
+func (tx *TableTx) Insert(record []byte) (error, int64) {
+	return tx.table.insertRow(tx, record)
+}
+
+func (tx *TableTx) InsertWithRowid(rowid int64, record []byte) error {
+	return tx.table.write(tx, func() error { return tx.table.insert(rowid, record) })
+}
+
+func (tx *TableTx) Update(rowid int64, record []byte) error {
+	return tx.table.write(tx, func() error { return tx.table.update(rowid, record) })
+}
+
+func (tx *TableTx) Delete(rowid int64) (error, []byte) {
+	return tx.table.deleteRow(tx, rowid)
+}
+
+// Commit makes the changes of the transaction permanent
+func (tx *TableTx) Commit() error {
+	table := tx.table
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+	table.tx = nil
+	defer table.tree.txLock.end()
+	return table.tree.commit()
+}
+
+// Rollback undoes the changes of the transaction, the sequence included
+func (tx *TableTx) Rollback() error {
+	table := tx.table
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+	table.tx = nil
+	defer table.tree.txLock.end()
+	table.sequence = tx.sequence
+	return table.tree.rollback()
+}
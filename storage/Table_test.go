@@ -0,0 +1,251 @@
+package storage
+
+import (
+	"math"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestTableAssignsRowids(t *testing.T) {
+	_, table := NewTable(pageSize(4), false)
+	for i := 1; i <= 100; i++ {
+		err, rowid := table.Insert([]byte("row " + strconv.Itoa(i)))
+		if err != nil {
+			t.Fatalf("Unexpected error inserting row %d: %v", i, err)
+		}
+		if rowid != int64(i) {
+			t.Errorf("Expected rowid %d, got %d", i, rowid)
+		}
+	}
+	if record, ok := table.Get(42); !ok || string(record) != "row 42" {
+		t.Errorf("Expected record of row 42, got %q", record)
+	}
+
+	// without autoincrement the rowid of the last row is reused once it is deleted
+	table.Delete(100)
+	if _, rowid := table.Insert(nil); rowid != 100 {
+		t.Errorf("Expected rowid 100 to be reused, got %d", rowid)
+	}
+
+	if err := table.InsertWithRowid(500, []byte("explicit")); err != nil {
+		t.Fatalf("Unexpected error inserting rowid 500: %v", err)
+	}
+	if _, rowid := table.Insert(nil); rowid != 501 {
+		t.Errorf("Expected rowid 501 after explicit rowid, got %d", rowid)
+	}
+	if err := table.InsertWithRowid(500, nil); err != ErrDuplicateKey {
+		t.Errorf("Expected ErrDuplicateKey, got %v", err)
+	}
+}
+
+func TestTableAutoincrement(t *testing.T) {
+	_, table := NewTable(pageSize(4), true)
+	for i := 0; i < 10; i++ {
+		table.Insert(nil)
+	}
+	table.Delete(10)
+	table.Delete(9)
+	if _, rowid := table.Insert(nil); rowid != 11 {
+		t.Errorf("Expected rowid 11, got %d", rowid)
+	}
+	if _, sequence := table.Sequence(); sequence != 11 {
+		t.Errorf("Expected sequence 11, got %d", sequence)
+	}
+
+	table.InsertWithRowid(math.MaxInt64, nil)
+	table.Delete(math.MaxInt64)
+	if err, _ := table.Insert(nil); err != ErrTableFull {
+		t.Errorf("Expected ErrTableFull, got %v", err)
+	}
+}
+
+func TestTableRandomRowidAfterMax(t *testing.T) {
+	_, table := NewTable(pageSize(4), false)
+	table.InsertWithRowid(math.MaxInt64, nil)
+	for i := 0; i < 20; i++ {
+		err, rowid := table.Insert([]byte{byte(i)})
+		if err != nil {
+			t.Fatalf("Unexpected error inserting row: %v", err)
+		}
+		if rowid <= 0 || rowid == math.MaxInt64 {
+			t.Errorf("Unexpected random rowid %d", rowid)
+		}
+	}
+	count := 0
+	for range table.Rows() {
+		count++
+	}
+	if count != 21 {
+		t.Errorf("Expected 21 rows, got %d", count)
+	}
+}
+
+func TestTableUpdateAndDelete(t *testing.T) {
+	_, table := NewTable(pageSize(3), false)
+	for i := 0; i < 50; i++ {
+		table.Insert([]byte{byte(i)})
+	}
+	if err := table.Update(7, []byte("seven")); err != nil {
+		t.Fatalf("Unexpected error updating row: %v", err)
+	}
+	if record, _ := table.Get(7); string(record) != "seven" {
+		t.Errorf("Expected updated record, got %q", record)
+	}
+	if err := table.Update(99, nil); err != ErrKeyNotFound {
+		t.Errorf("Expected ErrKeyNotFound updating a missing row, got %v", err)
+	}
+
+	err, record := table.Delete(7)
+	if err != nil || string(record) != "seven" {
+		t.Errorf("Expected deleted record, got %q, %v", record, err)
+	}
+	expected := int64(1)
+	for rowid := range table.Rows() {
+		if rowid == 7 {
+			t.Error("Deleted row is still returned")
+		}
+		if rowid < expected {
+			t.Errorf("Rows not in rowid order")
+		}
+		expected = rowid + 1
+	}
+}
+
+func TestTableOnPager(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "table.db")
+	_, pager := OpenPager(path, 512)
+	_, table := OpenTable(pager, 0, true)
+	_, other := OpenTable(pager, 0, true)
+	for i := 0; i < 300; i++ {
+		table.Insert([]byte("row " + strconv.Itoa(i)))
+	}
+	other.InsertWithRowid(1000, nil)
+	table.Delete(300)
+	other.Delete(1000)
+	root, otherRoot := table.Root(), other.Root()
+	pager.Close()
+
+	// the sequences are read back from the file, every table has its own
+	_, pager = OpenPager(path, 512)
+	defer pager.Close()
+	err, table := OpenTable(pager, root, true)
+	if err != nil {
+		t.Fatalf("Unexpected error opening table: %v", err)
+	}
+	if record, ok := table.Get(150); !ok || string(record) != "row 149" {
+		t.Errorf("Expected record of row 150 after reopening, got %q", record)
+	}
+	if _, rowid := table.Insert(nil); rowid != 301 {
+		t.Errorf("Expected rowid 301 after reopening, got %d", rowid)
+	}
+	_, other = OpenTable(pager, otherRoot, true)
+	if _, rowid := other.Insert(nil); rowid != 1001 {
+		t.Errorf("Expected rowid 1001 in the other table after reopening, got %d", rowid)
+	}
+
+	// a rolled back insert does not advance the sequence
+	pager.Sync()
+	table.Insert(nil)
+	pager.Rollback()
+	_, table = OpenTable(pager, root, true)
+	if err, sequence := table.Sequence(); err != nil || sequence != 301 {
+		t.Errorf("Expected sequence 301 after rollback, got %d (%v)", sequence, err)
+	}
+}
+
+func TestTableTx(t *testing.T) {
+	_, pager := OpenPager(filepath.Join(t.TempDir(), "table.db"), 512)
+	defer pager.Close()
+	_, onPager := OpenTable(pager, 0, true)
+	_, inMemory := NewTable(pageSize(4), true)
+
+	for _, table := range []*Table{inMemory, onPager} {
+		for i := 0; i < 50; i++ {
+			table.Insert([]byte("row"))
+		}
+		_, tx := table.Begin()
+		for i := 0; i < 200; i++ {
+			tx.Insert([]byte("new"))
+		}
+		tx.Update(1, []byte("changed"))
+		tx.Delete(2)
+		if err := tx.Rollback(); err != nil {
+			t.Fatalf("Unexpected error rolling back: %v", err)
+		}
+		if err, _ := tx.Insert(nil); err != ErrTxDone {
+			t.Errorf("Expected ErrTxDone after rollback, got %v", err)
+		}
+		count := 0
+		for range table.Rows() {
+			count++
+		}
+		if record, _ := table.Get(1); count != 50 || string(record) != "row" || !table.tree.Exists(2) {
+			t.Errorf("Expected the 50 rows as they were after rollback, got %d rows", count)
+		}
+		if err, sequence := table.Sequence(); err != nil || sequence != 50 {
+			t.Errorf("Expected sequence 50 after rollback, got %d (%v)", sequence, err)
+		}
+
+		_, tx = table.Begin()
+		if err, rowid := tx.Insert([]byte("committed")); err != nil || rowid != 51 {
+			t.Errorf("Expected rowid 51, got %d (%v)", rowid, err)
+		}
+		tx.Commit()
+		if record, _ := table.Get(51); string(record) != "committed" {
+			t.Errorf("Expected the committed row, got %q", record)
+		}
+	}
+}
+
+func TestTableConcurrentAccess(t *testing.T) {
+	_, memTable := NewTable(pageSize(4), true)
+	_, pager := OpenPager(filepath.Join(t.TempDir(), "table.db"), 512)
+	defer pager.Close()
+	pager.SetCacheSize(10)
+	_, pagerTable := OpenTable(pager, 0, true)
+
+	for _, table := range []*Table{memTable, pagerTable} {
+		var wg sync.WaitGroup
+		rowids := make([][]int64, 4)
+		for w := 0; w < 4; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 300; i++ {
+					err, rowid := table.Insert([]byte("row " + strconv.Itoa(w)))
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					rowids[w] = append(rowids[w], rowid)
+				}
+			}()
+		}
+		for r := 0; r < 4; r++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := int64(1); i <= 1200; i++ {
+					table.Get(i)
+				}
+				for range table.Rows() {
+				}
+			}()
+		}
+		wg.Wait()
+
+		// every insert got a rowid of its own and its row
+		for w, inserted := range rowids {
+			for _, rowid := range inserted {
+				if record, ok := table.Get(rowid); !ok || string(record) != "row "+strconv.Itoa(w) {
+					t.Fatalf("Expected the row of writer %d at rowid %d, got %q", w, rowid, record)
+				}
+			}
+		}
+		if err, sequence := table.Sequence(); err != nil || sequence != 1200 {
+			t.Errorf("Expected sequence 1200, got %d (%v)", sequence, err)
+		}
+	}
+}
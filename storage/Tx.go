@@ -0,0 +1,311 @@
+package storage
+
+import (
+	"errors"
+	"sync"
+)
+
+/*
+A transaction has the trees it writes to to itself until it commits or rolls back: writes to them from outside
+the transaction wait for it to end, so Commit and Rollback only ever see its own changes. An in-memory tree is
+locked on its own. The trees of a Pager share the lock of the pager, together with its Sync and Rollback, since
+a transaction on a pager commits and rolls back the pager as a whole: Begin syncs what the other trees changed
+before, Commit syncs the pager again and Rollback discards every change since Begin. As no other tree changes
+meanwhile, the nodes they hold stay valid. Pages written through the Pager directly are not held back.
+
+A goroutine with an open transaction has to write through it: a write of its own outside of it would wait for
+the transaction, which then never ends. Closing the pager does not wait, it fails with ErrTxOpen.
+
+Reads see either all changes of a transaction or none. While a transaction is open, the reads outside of it
+look at the tree as it was at the last commit, which the old versions of its nodes keep (see Snapshot.go).
+The read methods and iterators of a Tx look at what the transaction changed.
+*/
+
+var ErrTxDone = errors.New("transaction has already been committed or rolled back")
+var ErrTxOpen = errors.New("a transaction is open")
+
+// Tx groups changes to a BTree, so that either all of them or none become permanent.
+// An in-memory tree keeps an undo log of the nodes the transaction touches, a tree on a Pager commits
+// through the pager. Cursors and iterators opened before a Rollback must not be used afterwards.
+//
+// Savepoints work like SQLite's SAVEPOINT, RELEASE and ROLLBACK TO. They nest, and a name may be used
+// more than once: Release and RollbackTo refer to the newest savepoint with that name.
+type Tx[T any] struct {
+	btree      *BTree[T]
+	height     int
+	savepoints []txSavepoint // the newest last
+	changes    int           // changes to the tree so far, its iterators position their cursors again after one
+	iterations iterationErr
+	done       bool
+}
+
+type txSavepoint struct {
+	name   string
+	level  int // level of the savepoint in the node store
+	height int
+}
+
+// txLock makes the writes outside a transaction wait while one is open,
+// and a transaction wait for the writes going on when it begins
+type txLock struct {
+	mu      sync.Mutex
+	changed *sync.Cond
+	open    bool // a transaction is open
+	writers int  // writes outside a transaction going on
+}
+
+func newTxLock() *txLock {
+	lock := &txLock{}
+	lock.changed = sync.NewCond(&lock.mu)
+	return lock
+}
+
+// begin opens a transaction once the writes going on are done, it fails while another one is open
+func (lock *txLock) begin() error {
+	lock.mu.Lock()
+	defer lock.mu.Unlock()
+	for !lock.open && lock.writers > 0 {
+		lock.changed.Wait()
+	}
+	if lock.open {
+		return errors.New("a transaction is already open")
+	}
+	lock.open = true
+	return nil
+}
+
+func (lock *txLock) end() {
+	lock.mu.Lock()
+	defer lock.mu.Unlock()
+	lock.open = false
+	lock.changed.Broadcast()
+}
+
+// enter starts a write outside a transaction, waiting for the open one to end
+func (lock *txLock) enter() {
+	lock.mu.Lock()
+	defer lock.mu.Unlock()
+	for lock.open {
+		lock.changed.Wait()
+	}
+	lock.writers++
+}
+
+// tryEnter is enter for a write that cannot wait, it fails with ErrTxOpen while a transaction is open
+func (lock *txLock) tryEnter() error {
+	lock.mu.Lock()
+	defer lock.mu.Unlock()
+	if lock.open {
+		return ErrTxOpen
+	}
+	lock.writers++
+	return nil
+}
+
+func (lock *txLock) leave() {
+	lock.mu.Lock()
+	defer lock.mu.Unlock()
+	lock.writers--
+	if lock.writers == 0 {
+		lock.changed.Broadcast()
+	}
+}
+
+// write makes change to the tree as a write of tx, or outside a transaction when tx is nil
+func (btree *BTree[T]) write(tx *Tx[T], change func() error) error {
+	if tx == nil {
+		btree.txLock.enter()
+		defer btree.txLock.leave()
+	}
+	btree.mu.Lock()
+	defer btree.mu.Unlock()
+	if tx != nil && btree.tx != tx {
+		return ErrTxDone
+	}
+	btree.beginWrite()
+	defer btree.endWrite()
+	if tx != nil {
+		tx.changes++
+	}
+	return change()
+}
+
+// reader returns the tree the reads of tx look at, tx is nil for the reads outside of a transaction: the tree
+// itself, or while another transaction is open a view of the tree at the last commit. mu must be held for reading.
+func (btree *BTree[T]) reader(tx *Tx[T]) *BTree[T] {
+	if btree.tx == nil || btree.tx == tx {
+		return btree
+	}
+	return &BTree[T]{
+		root:       btree.versions.committedRoot(btree.root),
+		m:          btree.m,
+		height:     btree.tx.height,
+		nodes:      versionNodes[T]{versions: btree.versions, version: btree.versions.lastCommit()},
+		versions:   btree.versions,
+		compare:    btree.compare,
+		duplicates: btree.duplicates,
+		txLock:     btree.txLock,
+	}
+}
+
+// Begin starts a transaction, once the writes to the tree going on are done.
+// It fails while another transaction is open on the tree, or on the Pager of the tree.
+func (btree *BTree[T]) Begin() (error, *Tx[T]) {
+	if err := btree.txLock.begin(); err != nil {
+		return err, nil
+	}
+	btree.mu.Lock()
+	defer btree.mu.Unlock()
+	if err := btree.nodes.begin(btree.root); err != nil {
+		btree.txLock.end()
+		return err, nil
+	}
+	btree.tx = &Tx[T]{btree: btree, height: btree.height}
+	return nil, btree.tx
+}
+
+func (tx *Tx[T]) Insert(key T) error {
+	return tx.btree.write(tx, func() error { return tx.btree.add(key) })
+}
+
+func (tx *Tx[T]) Delete(key T) (error, bool) {
+	err := tx.btree.write(tx, func() error {
+		err, _ := tx.btree.remove(key)
+		return err
+	})
+	return err, err == nil
+}
+
+// The reads of a transaction below see its own changes
+
+func (tx *Tx[T]) Exists(key T) bool {
+	tx.btree.mu.RLock()
+	defer tx.btree.mu.RUnlock()
+	return tx.btree.reader(tx).exists(key)
+}
+
+// Count returns the number of copies of key
+func (tx *Tx[T]) Count(key T) (error, int) {
+	tx.btree.mu.RLock()
+	defer tx.btree.mu.RUnlock()
+	return countKey(tx.Cursor(), key)
+}
+
+// Len returns the number of keys in the tree
+func (tx *Tx[T]) Len() int {
+	tx.btree.mu.RLock()
+	defer tx.btree.mu.RUnlock()
+	return tx.btree.reader(tx).root.size()
+}
+
+// Cursor returns a cursor over the tree as the transaction changed it, which is not positioned yet
+func (tx *Tx[T]) Cursor() *Cursor[T] {
+	return &Cursor[T]{btree: tx.btree, tx: tx}
+}
+
+func (tx *Tx[T]) changeCount() int {
+	tx.btree.mu.RLock()
+	defer tx.btree.mu.RUnlock()
+	return tx.changes
+}
+
+// Commit makes the changes of the transaction permanent
+func (tx *Tx[T]) Commit() error {
+	tx.btree.mu.Lock()
+	defer tx.btree.mu.Unlock()
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+	tx.btree.tx = nil
+	defer tx.btree.txLock.end()
+	return tx.btree.nodes.commit()
+}
+
+// Rollback undoes the changes of the transaction
+func (tx *Tx[T]) Rollback() error {
+	tx.btree.mu.Lock()
+	defer tx.btree.mu.Unlock()
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+	tx.changes++
+	tx.btree.tx = nil
+	defer tx.btree.txLock.end()
+	if err := tx.btree.nodes.rollback(); err != nil {
+		return err
+	}
+	return tx.reload(tx.height)
+}
+
+// reload loads the root again after a rollback, the root page never moves
+func (tx *Tx[T]) reload(height int) error {
+	err, root := tx.btree.nodes.load(tx.btree.root.pgno)
+	if err != nil {
+		return err
+	}
+	tx.btree.root = root
+	tx.btree.height = height
+	return nil
+}
+
+// Savepoint marks the current state of the transaction under name
+func (tx *Tx[T]) Savepoint(name string) error {
+	tx.btree.mu.Lock()
+	defer tx.btree.mu.Unlock()
+	if tx.done {
+		return ErrTxDone
+	}
+	err, level := tx.btree.nodes.savepoint(tx.btree.root)
+	if err != nil {
+		return err
+	}
+	tx.savepoints = append(tx.savepoints, txSavepoint{name: name, level: level, height: tx.btree.height})
+	return nil
+}
+
+// Release forgets the savepoint name and the ones made after it. Their changes stay in the transaction.
+func (tx *Tx[T]) Release(name string) error {
+	tx.btree.mu.Lock()
+	defer tx.btree.mu.Unlock()
+	err, i := tx.findSavepoint(name)
+	if err != nil {
+		return err
+	}
+	if err = tx.btree.nodes.release(tx.savepoints[i].level); err != nil {
+		return err
+	}
+	tx.savepoints = tx.savepoints[:i]
+	return nil
+}
+
+// RollbackTo undoes the changes made since the savepoint name. The savepoint stays, so it can be rolled back
+// to again, the ones made after it are released.
+func (tx *Tx[T]) RollbackTo(name string) error {
+	tx.btree.mu.Lock()
+	defer tx.btree.mu.Unlock()
+	err, i := tx.findSavepoint(name)
+	if err != nil {
+		return err
+	}
+	if err = tx.btree.nodes.rollbackTo(tx.savepoints[i].level); err != nil {
+		return err
+	}
+	tx.savepoints = tx.savepoints[:i+1]
+	tx.changes++
+	return tx.reload(tx.savepoints[i].height)
+}
+
+// findSavepoint returns the index of the newest savepoint called name
+func (tx *Tx[T]) findSavepoint(name string) (error, int) {
+	if tx.done {
+		return ErrTxDone, 0
+	}
+	for i := len(tx.savepoints) - 1; i >= 0; i-- {
+		if tx.savepoints[i].name == name {
+			return nil, i
+		}
+	}
+	return errors.New("no such savepoint: " + name), 0
+}
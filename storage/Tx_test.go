@@ -0,0 +1,469 @@
+package storage
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// checkTxRollback fills btree with 0 to 199, rolls back a transaction that changes most of the tree and
+// commits one that adds 200 to 299
+func checkTxRollback(t *testing.T, btree *BTree[int]) {
+	t.Helper()
+	for i := 0; i < 200; i++ {
+		btree.Insert(i)
+	}
+	height := btree.height
+
+	err, tx := btree.Begin()
+	if err != nil {
+		t.Fatalf("Unexpected error beginning: %v", err)
+	}
+	if err, _ = btree.Begin(); err == nil {
+		t.Error("Expected error beginning a second transaction")
+	}
+	for i := 200; i < 1200; i++ {
+		tx.Insert(i)
+	}
+	for i := 0; i < 150; i++ {
+		tx.Delete(i)
+	}
+	if err = tx.Rollback(); err != nil {
+		t.Fatalf("Unexpected error rolling back: %v", err)
+	}
+	if err = tx.Insert(1); err != ErrTxDone {
+		t.Errorf("Expected ErrTxDone after rollback, got %v", err)
+	}
+	keys := checkTree(t, btree)
+	if len(keys) != 200 || keys[0] != 0 || keys[199] != 199 {
+		t.Fatalf("Expected the keys 0 to 199 after rollback, got %d keys", len(keys))
+	}
+	if btree.height != height {
+		t.Errorf("Expected height %d after rollback, got %d", height, btree.height)
+	}
+
+	_, tx = btree.Begin()
+	for i := 200; i < 300; i++ {
+		tx.Insert(i)
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatalf("Unexpected error committing: %v", err)
+	}
+	if err = tx.Commit(); err != ErrTxDone {
+		t.Errorf("Expected ErrTxDone after commit, got %v", err)
+	}
+	if keys = checkTree(t, btree); len(keys) != 300 {
+		t.Errorf("Expected 300 keys after commit, got %d", len(keys))
+	}
+}
+
+func TestTxInMemory(t *testing.T) {
+	_, btree := NewBTree[int](pageSize(4))
+	checkTxRollback(t, btree)
+}
+
+func TestTxOnPager(t *testing.T) {
+	for _, mode := range []JournalMode{JournalRollback, JournalWAL} {
+		path := filepath.Join(t.TempDir(), "tree.db")
+		_, pager := OpenPager(path, 512)
+		pager.SetJournalMode(mode)
+		pager.SetCacheSize(5)
+		_, btree := OpenBTree[int](pager, 0)
+		checkTxRollback(t, btree)
+		if pager.CacheStats().WriteBacks == 0 {
+			t.Errorf("Expected changed pages to be written back in mode %d", mode)
+		}
+		root := btree.Root()
+		pages := pager.PageCount()
+		pager.Close()
+
+		_, pager = OpenPager(path, 512)
+		if pager.PageCount() != pages {
+			t.Errorf("Expected %d pages after reopening, got %d", pages, pager.PageCount())
+		}
+		_, btree = OpenBTree[int](pager, root)
+		if keys := checkTree(t, btree); len(keys) != 300 {
+			t.Errorf("Expected 300 keys after reopening in mode %d, got %d", mode, len(keys))
+		}
+		pager.Close()
+	}
+}
+
+// checkTxIsolation checks that the reads outside of a transaction see none of its changes until it commits,
+// while the reads of the transaction see all of them
+func checkTxIsolation(t *testing.T, btree *BTree[int]) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		btree.Insert(i)
+	}
+	outside := func() []int {
+		var keys []int
+		cursor := btree.Cursor()
+		for cursor.First(); cursor.Valid(); cursor.Next() {
+			keys = append(keys, cursor.Key())
+		}
+		return keys
+	}
+
+	for _, commit := range []bool{false, true} {
+		_, tx := btree.Begin()
+		for i := 100; i < 600; i++ {
+			tx.Insert(i)
+		}
+		for i := 0; i < 50; i++ {
+			tx.Delete(i)
+		}
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			if btree.Exists(300) || !btree.Exists(10) || btree.Len() != 100 {
+				t.Errorf("Expected the reads outside of the transaction to see the last commit, got %d keys", btree.Len())
+			}
+			if _, count := btree.Count(10); count != 1 {
+				t.Errorf("Expected key 10 to be counted outside of the transaction, got %d", count)
+			}
+			if keys := outside(); !slices.Equal(keys, seq(100)) {
+				t.Errorf("Expected a cursor outside of the transaction to see the last commit, got %d keys", len(keys))
+			}
+			if max, _ := btree.Max(); max != 99 {
+				t.Errorf("Expected the largest committed key, got %d", max)
+			}
+			if err, rank := btree.Rank(99); err != nil || rank != 99 {
+				t.Errorf("Expected rank 99 outside of the transaction, got %d (%v)", rank, err)
+			}
+		}()
+		<-done
+
+		if !tx.Exists(300) || tx.Exists(10) || tx.Len() != 550 {
+			t.Errorf("Expected the transaction to see its changes, got %d keys", tx.Len())
+		}
+		if keys := collectKeys(t, tx, tx.Range(40, 110)); !slices.Equal(keys, []int{50, 51, 52, 53, 54, 55, 56, 57, 58, 59,
+			60, 61, 62, 63, 64, 65, 66, 67, 68, 69, 70, 71, 72, 73, 74, 75, 76, 77, 78, 79, 80, 81, 82, 83, 84, 85, 86, 87,
+			88, 89, 90, 91, 92, 93, 94, 95, 96, 97, 98, 99, 100, 101, 102, 103, 104, 105, 106, 107, 108, 109}) {
+			t.Errorf("Unexpected keys of the transaction: %v", keys)
+		}
+		if keys := collectKeys(t, btree, btree.All()); !slices.Equal(keys, seq(100)) {
+			t.Errorf("Expected the iterators of the tree to see the last commit, got %d keys", len(keys))
+		}
+
+		if commit {
+			tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+		if btree.Exists(300) != commit || btree.Exists(10) == commit {
+			t.Errorf("Expected the changes to be seen after the transaction ends only when it committed (%v)", commit)
+		}
+	}
+}
+
+func TestTxIsolationInMemory(t *testing.T) {
+	_, btree := NewBTree[int](pageSize(4))
+	checkTxIsolation(t, btree)
+}
+
+func TestTxIsolationOnPager(t *testing.T) {
+	_, pager := OpenPager(filepath.Join(t.TempDir(), "tree.db"), 512)
+	defer pager.Close()
+	pager.SetCacheSize(5)
+	_, btree := OpenBTree[int](pager, 0)
+	checkTxIsolation(t, btree)
+}
+
+func TestTxIteratorsWhileChanging(t *testing.T) {
+	_, btree := NewBTree[int](pageSize(3))
+	for i := 0; i < 100; i++ {
+		btree.Insert(2 * i)
+	}
+	_, tx := btree.Begin()
+	defer tx.Rollback()
+	// the loop goes on after the key it got, over the tree as the transaction changed it
+	var keys []int
+	for key := range tx.All() {
+		keys = append(keys, key)
+		if key%4 == 0 {
+			tx.Delete(key)
+			tx.Insert(key + 1)
+		}
+	}
+	if len(keys) != 150 || !slices.IsSorted(keys) {
+		t.Errorf("Expected every key and the ones inserted ahead of the loop, got %d keys", len(keys))
+	}
+	keys = keys[:0]
+	for key := range tx.Descend(50) {
+		keys = append(keys, key)
+		tx.Delete(key)
+	}
+	if len(keys) != 26 || keys[0] != 50 || keys[25] != 1 || tx.Len() != 74 {
+		t.Errorf("Expected to delete the 26 keys up to 50 in the loop, got %v, %d left", keys, tx.Len())
+	}
+}
+
+// checkWaits fails unless write is still waiting after a while, it returns a channel closed once write is done
+func checkWaits(t *testing.T, write func()) chan struct{} {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		write()
+	}()
+	select {
+	case <-done:
+		t.Fatal("Expected a write from outside the transaction to wait for it")
+	case <-time.After(50 * time.Millisecond):
+	}
+	return done
+}
+
+func TestTxHoldsBackOtherWrites(t *testing.T) {
+	_, btree := NewBTree[int](pageSize(4))
+	for i := 0; i < 100; i++ {
+		btree.Insert(i)
+	}
+	_, tx := btree.Begin()
+	for i := 100; i < 200; i++ {
+		tx.Insert(i)
+	}
+	done := checkWaits(t, func() { btree.Insert(1000) })
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("Unexpected error rolling back: %v", err)
+	}
+	<-done
+	// the write waited, so the rollback left it alone
+	if keys := checkTree(t, btree); !slices.Equal(keys, append(seq(100), 1000)) {
+		t.Errorf("Expected the keys 0 to 99 and 1000, got %d keys", len(keys))
+	}
+}
+
+func TestTxOnPagerHoldsBackOtherTrees(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tree.db")
+	_, pager := OpenPager(path, 512)
+	pager.SetCacheSize(5)
+	_, btree := OpenBTree[int](pager, 0)
+	_, other := OpenBTree[int](pager, 0)
+	for i := 0; i < 100; i++ {
+		other.Insert(i)
+	}
+
+	_, tx := btree.Begin()
+	if err, _ := other.Begin(); err == nil {
+		t.Error("Expected error beginning a transaction on another tree of the pager")
+	}
+	for i := 0; i < 300; i++ {
+		tx.Insert(i)
+	}
+	inserted := checkWaits(t, func() {
+		for i := 100; i < 200; i++ {
+			other.Insert(i)
+		}
+	})
+	synced := checkWaits(t, func() { pager.Sync() })
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("Unexpected error rolling back: %v", err)
+	}
+	<-inserted
+	<-synced
+	if keys := checkTree(t, btree); len(keys) != 0 {
+		t.Errorf("Expected the rolled back tree to be empty, got %d keys", len(keys))
+	}
+	if keys := checkTree(t, other); !slices.Equal(keys, seq(200)) {
+		t.Errorf("Expected the other tree to hold 0 to 199, got %d keys", len(keys))
+	}
+
+	_, tx = btree.Begin()
+	tx.Insert(1)
+	tx.Commit()
+	root, otherRoot := btree.Root(), other.Root()
+	pager.Close()
+
+	_, pager = OpenPager(path, 512)
+	defer pager.Close()
+	_, btree = OpenBTree[int](pager, root)
+	_, other = OpenBTree[int](pager, otherRoot)
+	if keys := checkTree(t, btree); !slices.Equal(keys, []int{1}) {
+		t.Errorf("Expected the committed key after reopening, got %v", keys)
+	}
+	if keys := checkTree(t, other); !slices.Equal(keys, seq(200)) {
+		t.Errorf("Expected the other tree to hold 0 to 199 after reopening, got %d keys", len(keys))
+	}
+}
+
+func TestPagerCloseWithOpenTx(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tree.db")
+	_, pager := OpenPager(path, 512)
+	_, btree := OpenBTree[int](pager, 0)
+	_, table := OpenTable(pager, 0, false)
+	for i := 0; i < 100; i++ {
+		btree.Insert(i)
+	}
+	pager.Sync()
+	root, tableRoot := btree.Root(), table.Root()
+
+	_, tx := btree.Begin()
+	for i := 100; i < 300; i++ {
+		tx.Insert(i)
+	}
+	if err := pager.Close(); err != ErrTxOpen {
+		t.Errorf("Expected ErrTxOpen closing the pager with a transaction open, got %v", err)
+	}
+	tx.Rollback()
+
+	_, tableTx := table.Begin()
+	tableTx.Insert([]byte("half done"))
+	if err := pager.Close(); err != ErrTxOpen {
+		t.Errorf("Expected ErrTxOpen closing the pager with a table transaction open, got %v", err)
+	}
+	tableTx.Rollback()
+	if err := pager.Close(); err != nil {
+		t.Fatalf("Unexpected error closing the pager: %v", err)
+	}
+
+	// none of the changes of the transactions became durable
+	_, pager = OpenPager(path, 512)
+	defer pager.Close()
+	_, btree = OpenBTree[int](pager, root)
+	if keys := checkTree(t, btree); !slices.Equal(keys, seq(100)) {
+		t.Errorf("Expected the keys 0 to 99 after reopening, got %d keys", len(keys))
+	}
+	_, table = OpenTable(pager, tableRoot, false)
+	if _, ok := table.Get(1); ok {
+		t.Error("Expected the table to be empty after reopening")
+	}
+}
+
+// checkSavepoints runs nested savepoints on an empty btree, leaving the keys 0 to 199 and 300 committed
+func checkSavepoints(t *testing.T, btree *BTree[int]) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		btree.Insert(i)
+	}
+	_, tx := btree.Begin()
+	for i := 100; i < 200; i++ {
+		tx.Insert(i)
+	}
+	if err := tx.Savepoint("a"); err != nil {
+		t.Fatalf("Unexpected error making savepoint: %v", err)
+	}
+	for i := 200; i < 1000; i++ {
+		tx.Insert(i)
+	}
+	tx.Savepoint("b")
+	for i := 0; i < 150; i++ {
+		tx.Delete(i)
+	}
+	if err := tx.RollbackTo("b"); err != nil {
+		t.Fatalf("Unexpected error rolling back to savepoint: %v", err)
+	}
+	if keys := checkTree(t, btree); len(keys) != 1000 {
+		t.Errorf("Expected 1000 keys after rolling back to b, got %d", len(keys))
+	}
+
+	if err := tx.RollbackTo("a"); err != nil {
+		t.Fatalf("Unexpected error rolling back to savepoint: %v", err)
+	}
+	if keys := checkTree(t, btree); len(keys) != 200 || keys[199] != 199 {
+		t.Errorf("Expected the keys 0 to 199 after rolling back to a, got %d keys", len(keys))
+	}
+	if err := tx.RollbackTo("b"); err == nil {
+		t.Error("Expected savepoint b to be released by rolling back to a")
+	}
+
+	// a stays after the rollback and can be rolled back to again
+	for i := 2000; i < 2500; i++ {
+		tx.Insert(i)
+	}
+	tx.RollbackTo("a")
+	tx.Savepoint("c")
+	tx.Insert(300)
+	if err := tx.Release("c"); err != nil {
+		t.Fatalf("Unexpected error releasing savepoint: %v", err)
+	}
+	tx.Release("a")
+	if err := tx.RollbackTo("a"); err == nil {
+		t.Error("Expected error rolling back to a released savepoint")
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Unexpected error committing: %v", err)
+	}
+	if keys := checkTree(t, btree); len(keys) != 201 || keys[200] != 300 {
+		t.Errorf("Expected the keys 0 to 199 and 300, got %d keys", len(keys))
+	}
+}
+
+func TestSavepointsInMemory(t *testing.T) {
+	_, btree := NewBTree[int](pageSize(4))
+	checkSavepoints(t, btree)
+}
+
+func TestSavepointsOnPager(t *testing.T) {
+	for _, mode := range []JournalMode{JournalRollback, JournalWAL} {
+		path := filepath.Join(t.TempDir(), "tree.db")
+		_, pager := OpenPager(path, 512)
+		pager.SetJournalMode(mode)
+		pager.SetCacheSize(5)
+		_, btree := OpenBTree[int](pager, 0)
+		checkSavepoints(t, btree)
+		if err, _ := pager.freePages(); err != nil {
+			t.Errorf("Freelist is inconsistent in mode %d: %v", mode, err)
+		}
+		root := btree.Root()
+		pager.Close()
+
+		_, pager = OpenPager(path, 512)
+		_, btree = OpenBTree[int](pager, root)
+		if keys := checkTree(t, btree); len(keys) != 201 {
+			t.Errorf("Expected 201 keys after reopening in mode %d, got %d", mode, len(keys))
+		}
+		pager.Close()
+	}
+}
+
+func TestSavepointsOfTwoTreesOnPager(t *testing.T) {
+	_, pager := OpenPager(filepath.Join(t.TempDir(), "tree.db"), 512)
+	defer pager.Close()
+	pager.SetCacheSize(5)
+	_, first := OpenBTree[int](pager, 0)
+	_, second := OpenBTree[int](pager, 0)
+
+	// the savepoints of a transaction end with it, the next one on the pager starts from level 1 again
+	_, tx := first.Begin()
+	tx.Savepoint("a")
+	tx.Savepoint("b")
+	for i := 0; i < 100; i++ {
+		tx.Insert(i)
+	}
+	tx.Release("a")
+	tx.Commit()
+
+	_, tx = second.Begin()
+	for i := 0; i < 100; i++ {
+		tx.Insert(i)
+	}
+	tx.Savepoint("a")
+	for i := 100; i < 300; i++ {
+		tx.Insert(i)
+	}
+	synced := checkWaits(t, func() { pager.Sync() })
+	if err := tx.RollbackTo("a"); err != nil {
+		t.Fatalf("Unexpected error rolling back to savepoint: %v", err)
+	}
+	if keys := checkTree(t, second); !slices.Equal(keys, seq(100)) {
+		t.Errorf("Expected the keys 0 to 99 after rolling back to a, got %d keys", len(keys))
+	}
+
+	// a Sync that does not wait for the transaction drops its savepoints
+	tx.Savepoint("b")
+	pager.commit()
+	if err := tx.RollbackTo("b"); err == nil {
+		t.Error("Expected error rolling back to a savepoint of a synced pager")
+	}
+	if err := tx.Savepoint("c"); err == nil {
+		t.Error("Expected error making a savepoint on a synced pager")
+	}
+	tx.Commit()
+	<-synced
+	if keys := checkTree(t, first); !slices.Equal(keys, seq(100)) {
+		t.Errorf("Expected the first tree to hold 0 to 99, got %d keys", len(keys))
+	}
+}
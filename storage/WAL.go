@@ -0,0 +1,374 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"math/rand"
+	"os"
+)
+
+/*
+In WAL mode the database file is not changed by a commit. Sync appends the changed pages to the write-ahead
+log, a file next to the database named path + "-wal", and the last frame of a commit records the size of
+the database after it. The commit is done once that frame is flushed to stable storage.
+
+The wal-index tells which frames hold the newest version of a page. It is kept in memory and rebuilt from
+the log when the database is opened, a page that is not in it is read from the database file. Frames after
+the last commit frame were never committed and are left out of the wal-index.
+
+Checkpoint copies the newest committed version of every page in the log back into the database file. Once
+every frame is copied, the next commit starts writing the log from its beginning again.
+
+Unlike SQLite, the log keeps no read marks: a reader of the pager gets no snapshot bounded by the last commit
+frame, and page reads wait on the lock of the pager while a commit or a checkpoint holds it. The log only
+changes how a commit reaches the disk, not who waits for whom. Readers that must not wait for a writer read a
+Snapshot of their tree, which keeps the old versions of its nodes in memory (see Snapshot.go). As no reader
+needs an older version of a page, every checkpoint can copy every committed frame, so CheckpointPassive does
+what CheckpointFull does.
+
+The log starts with a header:
+
+	offset  size  description
+	0       8     magic string "SDBCwal\000"
+	8       4     page size in bytes (big-endian)
+	12      4     salt, a random number that changes every time the log restarts (big-endian)
+	16      4     CRC-32 of the first 16 bytes (big-endian)
+
+followed by frames of a page each:
+
+	offset  size      description
+	0       4         page number (big-endian)
+	4       4         number of pages in the database after the commit, 0 when this is not a commit frame
+	8       4         salt, the same as in the header (big-endian)
+	12      4         checksum (big-endian)
+	16      pageSize  content of the page
+
+The checksum of a frame is the CRC-32 of its first 12 bytes and its content, continuing from the checksum of
+the frame before, or from the one in the header for the first frame. A frame is only valid when it has the
+salt of the header and the right checksum, and so are all frames before it: frames left over from before
+the log restarted, and frames torn by a crash, end the log.
+*/
+
+const walMagic = "SDBCwal\x00"
+
+const (
+	walHeaderSize      = 20
+	walFrameHeaderSize = 16
+
+	// walAutoCheckpoint is the number of frames in the log after which a commit runs a passive checkpoint
+	walAutoCheckpoint = 1000
+)
+
+// CheckpointMode decides how much work Checkpoint does
+type CheckpointMode int
+
+const (
+	// CheckpointPassive copies the committed frames that it can copy without waiting, which are all of them here
+	CheckpointPassive CheckpointMode = iota
+	// CheckpointFull copies every committed frame
+	CheckpointFull
+	// CheckpointRestart does what CheckpointFull does, then lets the next commit write the log from its beginning
+	CheckpointRestart
+	// CheckpointTruncate does what CheckpointRestart does and truncates the log to zero bytes
+	CheckpointTruncate
+)
+
+type wal struct {
+	file          *os.File
+	pageSize      int
+	salt          uint32
+	checksum      uint32            // checksum of the last frame, or of the header when there is none
+	commitSum     uint32            // checksum of the last commit frame, or of the header when there is none
+	headerWritten bool              // false after the log was truncated
+	frames        map[Pgno][]uint32 // wal-index: the frames holding a page, oldest first
+	nFrames       uint32            // frames in the log, the ones of an uncommitted transaction included
+	mxFrame       uint32            // last frame of the last commit
+	nBackfill     uint32            // frames copied to the database by checkpoints
+	commitSize    Pgno              // number of pages in the database after the last commit
+}
+
+// openWAL opens the log at path and rebuilds the wal-index from it, creating the log when it does not exist
+func openWAL(path string, pageSize int) (error, *wal) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err, nil
+	}
+	log := &wal{file: file, pageSize: pageSize}
+	if err = log.recover(); err != nil {
+		file.Close()
+		return err, nil
+	}
+	return nil, log
+}
+
+// recover rebuilds the wal-index from the frames up to the last valid commit frame
+func (log *wal) recover() error {
+	log.reset()
+	info, err := log.file.Stat()
+	if err != nil {
+		return err
+	}
+	header := make([]byte, walHeaderSize)
+	if _, err = log.file.ReadAt(header, 0); err != nil || string(header[:len(walMagic)]) != walMagic ||
+		crc32.ChecksumIEEE(header[:16]) != binary.BigEndian.Uint32(header[16:]) {
+		// an empty or torn log holds nothing committed
+		return nil
+	}
+	if int(binary.BigEndian.Uint32(header[8:])) != log.pageSize {
+		return errors.New("write-ahead log does not have the page size of the database")
+	}
+	log.salt = binary.BigEndian.Uint32(header[12:])
+	log.checksum = binary.BigEndian.Uint32(header[16:])
+	log.commitSum = log.checksum
+	log.headerWritten = true
+
+	frame := make([]byte, walFrameHeaderSize+log.pageSize)
+	checksum := log.checksum
+	for log.frameOffset(log.nFrames+1)+int64(len(frame)) <= info.Size() {
+		if _, err = log.file.ReadAt(frame, log.frameOffset(log.nFrames+1)); err != nil {
+			return err
+		}
+		if binary.BigEndian.Uint32(frame[8:]) != log.salt {
+			break
+		}
+		checksum = frameChecksum(checksum, frame)
+		if checksum != binary.BigEndian.Uint32(frame[12:]) {
+			break
+		}
+		log.nFrames++
+		pgno := Pgno(binary.BigEndian.Uint32(frame))
+		log.frames[pgno] = append(log.frames[pgno], log.nFrames)
+		if commitSize := Pgno(binary.BigEndian.Uint32(frame[4:])); commitSize != 0 {
+			log.mxFrame = log.nFrames
+			log.commitSize = commitSize
+			log.commitSum = checksum
+		}
+	}
+	log.rollback()
+	return nil
+}
+
+// reset empties the wal-index and picks a new salt, so the frames already in the file become invalid
+func (log *wal) reset() {
+	log.salt = rand.Uint32()
+	log.headerWritten = false
+	log.frames = make(map[Pgno][]uint32)
+	log.nFrames = 0
+	log.mxFrame = 0
+	log.nBackfill = 0
+	log.commitSize = 0
+}
+
+// rollback drops the frames of the uncommitted transaction from the wal-index, later frames overwrite them
+func (log *wal) rollback() {
+	for pgno, frames := range log.frames {
+		for len(frames) > 0 && frames[len(frames)-1] > log.mxFrame {
+			frames = frames[:len(frames)-1]
+		}
+		if len(frames) == 0 {
+			delete(log.frames, pgno)
+		} else {
+			log.frames[pgno] = frames
+		}
+	}
+	log.nFrames = log.mxFrame
+	log.checksum = log.commitSum
+}
+
+func (log *wal) writeHeader() error {
+	header := make([]byte, walHeaderSize)
+	copy(header, walMagic)
+	binary.BigEndian.PutUint32(header[8:], uint32(log.pageSize))
+	binary.BigEndian.PutUint32(header[12:], log.salt)
+	log.checksum = crc32.ChecksumIEEE(header[:16])
+	log.commitSum = log.checksum
+	binary.BigEndian.PutUint32(header[16:], log.checksum)
+	if _, err := log.file.WriteAt(header, 0); err != nil {
+		return err
+	}
+	log.headerWritten = true
+	return nil
+}
+
+func frameChecksum(checksum uint32, frame []byte) uint32 {
+	checksum = crc32.Update(checksum, crc32.IEEETable, frame[:12])
+	return crc32.Update(checksum, crc32.IEEETable, frame[walFrameHeaderSize:])
+}
+
+// frameOffset returns the file offset of frame, frames are numbered from 1
+func (log *wal) frameOffset(frame uint32) int64 {
+	return walHeaderSize + int64(frame-1)*int64(walFrameHeaderSize+log.pageSize)
+}
+
+// find returns the newest frame up to frame limit that holds page pgno, 0 when there is none
+func (log *wal) find(pgno Pgno, limit uint32) uint32 {
+	frames := log.frames[pgno]
+	for i := len(frames) - 1; i >= 0; i-- {
+		if frames[i] <= limit {
+			return frames[i]
+		}
+	}
+	return 0
+}
+
+// read returns the page content in frame
+func (log *wal) read(frame uint32) (error, []byte) {
+	data := make([]byte, log.pageSize)
+	if _, err := log.file.ReadAt(data, log.frameOffset(frame)+walFrameHeaderSize); err != nil {
+		return err, nil
+	}
+	return nil, data
+}
+
+// append adds a frame holding data as page pgno. commitSize is the number of pages in the database for
+// the last frame of a commit and 0 for the others.
+func (log *wal) append(pgno Pgno, data []byte, commitSize Pgno) error {
+	if log.nFrames > 0 && log.nBackfill == log.nFrames {
+		// every frame is in the database already, the log starts over
+		log.reset()
+	}
+	if !log.headerWritten {
+		if err := log.writeHeader(); err != nil {
+			return err
+		}
+	}
+	frame := make([]byte, walFrameHeaderSize, walFrameHeaderSize+log.pageSize)
+	binary.BigEndian.PutUint32(frame, uint32(pgno))
+	binary.BigEndian.PutUint32(frame[4:], uint32(commitSize))
+	binary.BigEndian.PutUint32(frame[8:], log.salt)
+	frame = append(frame, data...)
+	checksum := frameChecksum(log.checksum, frame)
+	binary.BigEndian.PutUint32(frame[12:], checksum)
+	if _, err := log.file.WriteAt(frame, log.frameOffset(log.nFrames+1)); err != nil {
+		return err
+	}
+	log.checksum = checksum
+	log.nFrames++
+	log.frames[pgno] = append(log.frames[pgno], log.nFrames)
+	return nil
+}
+
+// commit flushes the log, which commits every frame appended since the last commit.
+// The last one has to be a commit frame for a database of commitSize pages.
+func (log *wal) commit(commitSize Pgno) error {
+	if err := log.file.Sync(); err != nil {
+		return err
+	}
+	log.mxFrame = log.nFrames
+	log.commitSize = commitSize
+	log.commitSum = log.checksum
+	return nil
+}
+
+// delete closes the log and removes its file
+func (log *wal) delete() error {
+	err := log.file.Close()
+	if removeErr := os.Remove(log.file.Name()); removeErr != nil {
+		return removeErr
+	}
+	return err
+}
+
+// Checkpoint commits the changes since the last Sync and copies the committed frames of the log to the
+// database file. It returns the number of frames in the log and how many of them are in the database file.
+// Like Sync, it waits for an open transaction to end. No mode waits for readers, see above.
+func (pager *Pager) Checkpoint(mode CheckpointMode) (error, int, int) {
+	pager.txLock.enter()
+	defer pager.txLock.leave()
+	pager.mu.Lock()
+	defer pager.mu.Unlock()
+	if pager.wal == nil {
+		return errors.New("database is not in WAL mode"), 0, 0
+	}
+	if err := pager.sync(); err != nil {
+		return err, 0, 0
+	}
+	return pager.checkpoint(mode)
+}
+
+func (pager *Pager) checkpoint(mode CheckpointMode) (error, int, int) {
+	log := pager.wal
+	if log.nBackfill < log.mxFrame {
+		for pgno := range log.frames {
+			frame := log.find(pgno, log.mxFrame)
+			if pgno > log.commitSize || frame <= log.nBackfill {
+				continue
+			}
+			err, data := log.read(frame)
+			if err != nil {
+				return err, 0, 0
+			}
+			if _, err = pager.file.WriteAt(data, int64(pgno-1)*int64(pager.pageSize)); err != nil {
+				return err, 0, 0
+			}
+		}
+		if err := pager.file.Truncate(int64(log.commitSize) * int64(pager.pageSize)); err != nil {
+			return err, 0, 0
+		}
+		if err := pager.file.Sync(); err != nil {
+			return err, 0, 0
+		}
+		log.nBackfill = log.mxFrame
+	}
+
+	frames, backfilled := int(log.nFrames), int(log.nBackfill)
+	if mode >= CheckpointRestart && log.nFrames == log.mxFrame {
+		log.reset()
+		if mode == CheckpointTruncate {
+			if err := log.file.Truncate(0); err != nil {
+				return err, 0, 0
+			}
+		} else if err := log.writeHeader(); err != nil {
+			return err, 0, 0
+		}
+	}
+	return nil, frames, backfilled
+}
+
+// commitWAL appends the dirty pages to the log and commits them, with the header page as the commit frame
+func (pager *Pager) commitWAL() error {
+	log := pager.wal
+	pages := pager.cache.dirtyPages()
+	if len(pages) == 0 && log.nFrames == log.mxFrame {
+		return nil
+	}
+	var header *cachedPage
+	for _, page := range pages {
+		if page.pgno == 1 {
+			header = page
+			continue
+		}
+		if err := log.append(page.pgno, page.data, 0); err != nil {
+			return err
+		}
+		page.dirty = false
+	}
+	if header == nil {
+		err, page := pager.load(1)
+		if err != nil {
+			return err
+		}
+		header = page
+	}
+	if err := log.append(1, header.data, pager.nPages); err != nil {
+		return err
+	}
+	header.dirty = false
+	if err := log.commit(pager.nPages); err != nil {
+		return err
+	}
+
+	if log.nFrames-log.nBackfill >= walAutoCheckpoint {
+		err, _, _ := pager.checkpoint(CheckpointPassive)
+		return err
+	}
+	return nil
+}
+
+// closeWAL closes the log and deletes its file
+func (pager *Pager) closeWAL() error {
+	err := pager.wal.delete()
+	pager.wal = nil
+	return err
+}
@@ -0,0 +1,206 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// openWALTree creates a database in WAL mode holding a tree with the keys 0 to n-1, committed
+func openWALTree(t *testing.T, path string, n int) (*Pager, *BTree[int]) {
+	t.Helper()
+	_, pager := OpenPager(path, 512)
+	if err := pager.SetJournalMode(JournalWAL); err != nil {
+		t.Fatalf("Unexpected error switching to WAL mode: %v", err)
+	}
+	_, btree := OpenBTree[int](pager, 0)
+	for i := 0; i < n; i++ {
+		btree.Insert(i)
+	}
+	if err := pager.Sync(); err != nil {
+		t.Fatalf("Unexpected error committing: %v", err)
+	}
+	return pager, btree
+}
+
+func crashWAL(pager *Pager) {
+	pager.wal.file.Close()
+	crash(pager)
+}
+
+func TestWALCommitsToLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tree.db")
+	pager, btree := openWALTree(t, path, 500)
+	root := btree.Root()
+	if info, _ := os.Stat(path); info.Size() != 512 {
+		t.Errorf("Expected only the header page in the database file, got %d bytes", info.Size())
+	}
+	if _, err := os.Stat(journalPath(path)); !os.IsNotExist(err) {
+		t.Error("Expected no rollback journal in WAL mode")
+	}
+	crashWAL(pager)
+
+	err, pager := OpenPager(path, 512)
+	if err != nil {
+		t.Fatalf("Unexpected error reopening: %v", err)
+	}
+	if pager.JournalMode() != JournalWAL {
+		t.Errorf("Expected the journal mode to be stored, got %d", pager.JournalMode())
+	}
+	_, btree = OpenBTree[int](pager, root)
+	if keys := checkTree(t, btree); len(keys) != 500 {
+		t.Errorf("Expected 500 keys from the log, got %d", len(keys))
+	}
+	if err = pager.Close(); err != nil {
+		t.Fatalf("Unexpected error closing: %v", err)
+	}
+	if _, err = os.Stat(walPath(path)); !os.IsNotExist(err) {
+		t.Error("Expected Close to checkpoint and remove the log")
+	}
+
+	_, pager = OpenPager(path, 512)
+	defer pager.Close()
+	_, btree = OpenBTree[int](pager, root)
+	if keys := checkTree(t, btree); len(keys) != 500 {
+		t.Errorf("Expected 500 keys in the database file, got %d", len(keys))
+	}
+}
+
+func TestWALDropsUncommittedFrames(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tree.db")
+	pager, btree := openWALTree(t, path, 200)
+	root := btree.Root()
+	pager.SetCacheSize(3)
+	for i := 200; i < 1000; i++ {
+		btree.Insert(i)
+	}
+	if pager.CacheStats().WriteBacks == 0 {
+		t.Fatal("Expected changed pages to reach the log before the crash")
+	}
+	crashWAL(pager)
+
+	_, pager = OpenPager(path, 512)
+	defer pager.Close()
+	_, btree = OpenBTree[int](pager, root)
+	if keys := checkTree(t, btree); len(keys) != 200 {
+		t.Errorf("Expected the 200 committed keys, got %d", len(keys))
+	}
+}
+
+func TestWALIgnoresTornFrame(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tree.db")
+	pager, btree := openWALTree(t, path, 100)
+	root := btree.Root()
+	crashWAL(pager)
+	log, _ := os.OpenFile(walPath(path), os.O_WRONLY|os.O_APPEND, 0644)
+	log.Write(make([]byte, walFrameHeaderSize+300))
+	log.Close()
+
+	err, pager := OpenPager(path, 512)
+	if err != nil {
+		t.Fatalf("Unexpected error reopening: %v", err)
+	}
+	defer pager.Close()
+	_, btree = OpenBTree[int](pager, root)
+	if keys := checkTree(t, btree); len(keys) != 100 {
+		t.Errorf("Expected 100 keys, got %d", len(keys))
+	}
+}
+
+func TestCheckpointModes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tree.db")
+	pager, btree := openWALTree(t, path, 300)
+	root := btree.Root()
+
+	err, frames, backfilled := pager.Checkpoint(CheckpointPassive)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if frames == 0 || backfilled != frames {
+		t.Errorf("Expected every frame to be copied, got %d of %d", backfilled, frames)
+	}
+	logInfo, _ := os.Stat(walPath(path))
+	size := logInfo.Size()
+
+	// the log starts over after a full checkpoint
+	btree.Insert(300)
+	pager.Sync()
+	if _, after, _ := pager.Checkpoint(CheckpointFull); after >= frames {
+		t.Errorf("Expected the log to restart, got %d frames after %d", after, frames)
+	}
+	if logInfo, _ = os.Stat(walPath(path)); logInfo.Size() != size {
+		t.Errorf("Expected the log file to keep its size %d, got %d", size, logInfo.Size())
+	}
+
+	btree.Insert(301)
+	if err, _, _ = pager.Checkpoint(CheckpointRestart); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if pager.wal.nFrames != 0 {
+		t.Errorf("Expected an empty log after a restart, got %d frames", pager.wal.nFrames)
+	}
+	btree.Insert(302)
+	if err, _, _ = pager.Checkpoint(CheckpointTruncate); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if logInfo, _ = os.Stat(walPath(path)); logInfo.Size() != 0 {
+		t.Errorf("Expected a truncated log, got %d bytes", logInfo.Size())
+	}
+	crashWAL(pager)
+
+	// everything is in the database file now
+	_, pager = OpenPager(path, 512)
+	defer pager.Close()
+	_, btree = OpenBTree[int](pager, root)
+	if keys := checkTree(t, btree); len(keys) != 303 {
+		t.Errorf("Expected 303 keys, got %d", len(keys))
+	}
+}
+
+func TestCheckpointWaitsForTx(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tree.db")
+	pager, btree := openWALTree(t, path, 100)
+	root := btree.Root()
+	_, tx := btree.Begin()
+	for i := 100; i < 300; i++ {
+		tx.Insert(i)
+	}
+	checkpointed := checkWaits(t, func() { pager.Checkpoint(CheckpointTruncate) })
+	tx.Rollback()
+	<-checkpointed
+	crashWAL(pager)
+
+	// the rolled back keys never reached the database file
+	_, pager = OpenPager(path, 512)
+	defer pager.Close()
+	_, btree = OpenBTree[int](pager, root)
+	if keys := checkTree(t, btree); len(keys) != 100 {
+		t.Errorf("Expected 100 keys, got %d", len(keys))
+	}
+}
+
+func TestLeaveWALMode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tree.db")
+	pager, btree := openWALTree(t, path, 100)
+	root := btree.Root()
+	if err := pager.SetJournalMode(JournalRollback); err != nil {
+		t.Fatalf("Unexpected error leaving WAL mode: %v", err)
+	}
+	if _, err := os.Stat(walPath(path)); !os.IsNotExist(err) {
+		t.Error("Expected the log to be removed")
+	}
+	if err, _, _ := pager.Checkpoint(CheckpointPassive); err == nil {
+		t.Error("Expected Checkpoint to fail outside WAL mode")
+	}
+	pager.Close()
+
+	_, pager = OpenPager(path, 512)
+	defer pager.Close()
+	if pager.JournalMode() != JournalRollback {
+		t.Errorf("Expected rollback journal mode, got %d", pager.JournalMode())
+	}
+	_, btree = OpenBTree[int](pager, root)
+	if keys := checkTree(t, btree); len(keys) != 100 {
+		t.Errorf("Expected 100 keys, got %d", len(keys))
+	}
+}
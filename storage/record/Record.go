@@ -0,0 +1,229 @@
+package record
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"reflect"
+)
+
+/*
+A record is a row encoded in SQLite's record format: a header followed by the body.
+The header starts with its own length in bytes as a varint, then holds the serial type of every value
+as a varint. The body holds the values one after the other, each taking the size its serial type gives:
+
+	serial type     size       value
+	0               0          NULL
+	1, 2, 3, 4      1-4        big-endian two's complement integer of 1, 2, 3 or 4 bytes
+	5, 6            6, 8       big-endian two's complement integer of 6 or 8 bytes
+	7               8          big-endian IEEE 754 binary64 float
+	8, 9            0          the integer 0 or 1
+	10, 11          -          reserved, never used in a record
+	N >= 12, even   (N-12)/2   BLOB
+	N >= 13, odd    (N-13)/2   TEXT
+
+Values are nil for NULL, int64, float64, string for TEXT and []byte for BLOB. Encode also accepts the other
+integer kinds and float32, Decode always returns one of the types above.
+Integers take the smallest serial type that holds them, so equal rows encode to equal bytes like in SQLite.
+*/
+
+const (
+	SerialNull    = 0
+	SerialFloat   = 7
+	SerialZero    = 8
+	SerialOne     = 9
+	SerialBlobMin = 12
+	SerialTextMin = 13
+)
+
+// serialIntSizes are the sizes of serial types 1 to 6
+var serialIntSizes = [...]int{1, 2, 3, 4, 6, 8}
+
+// SerialType returns the serial type value is stored with
+func SerialType(value any) (error, uint64) {
+	if value == nil {
+		return nil, SerialNull
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return nil, intSerialType(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if v.Uint() > math.MaxInt64 {
+			return errors.New("integer does not fit in 64-bit two's complement"), 0
+		}
+		return nil, intSerialType(int64(v.Uint()))
+	case reflect.Float32, reflect.Float64:
+		return nil, SerialFloat
+	case reflect.String:
+		return nil, uint64(v.Len())*2 + SerialTextMin
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return nil, uint64(v.Len())*2 + SerialBlobMin
+		}
+	}
+	return errors.New("unsupported value type: " + v.Type().String()), 0
+}
+
+func intSerialType(i int64) uint64 {
+	switch {
+	case i == 0:
+		return SerialZero
+	case i == 1:
+		return SerialOne
+	}
+	for t, size := range serialIntSizes {
+		bits := uint(8*size - 1)
+		if i >= -1<<bits && i < 1<<bits {
+			return uint64(t + 1)
+		}
+	}
+	return 6
+}
+
+// SerialTypeLen returns the number of body bytes a value of serial type t takes, or -1 when that does not
+// fit in an int
+func SerialTypeLen(t uint64) int {
+	size := serialTypeSize(t)
+	if size > math.MaxInt {
+		return -1
+	}
+	return int(size)
+}
+
+func serialTypeSize(t uint64) uint64 {
+	switch {
+	case t >= SerialBlobMin:
+		return (t - SerialBlobMin) / 2
+	case t >= 1 && t <= 6:
+		return uint64(serialIntSizes[t-1])
+	case t == SerialFloat:
+		return 8
+	}
+	return 0
+}
+
+// Encode returns the record holding values
+func Encode(values []any) (error, []byte) {
+	types := make([]uint64, len(values))
+	headerLen, bodyLen := 0, 0
+	for i, value := range values {
+		err, t := SerialType(value)
+		if err != nil {
+			return err, nil
+		}
+		types[i] = t
+		headerLen += VarintLen(t)
+		bodyLen += SerialTypeLen(t)
+	}
+	// the header length counts the varint that stores it
+	n := 1
+	for VarintLen(uint64(headerLen+n)) > n {
+		n++
+	}
+	headerLen += n
+
+	buf := make([]byte, 0, headerLen+bodyLen)
+	buf = AppendVarint(buf, uint64(headerLen))
+	for _, t := range types {
+		buf = AppendVarint(buf, t)
+	}
+	for i, value := range values {
+		buf = appendValue(buf, types[i], value)
+	}
+	return nil, buf
+}
+
+func appendValue(buf []byte, t uint64, value any) []byte {
+	if t == SerialNull || t == SerialZero || t == SerialOne {
+		return buf
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return appendInt(buf, v.Int(), SerialTypeLen(t))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return appendInt(buf, int64(v.Uint()), SerialTypeLen(t))
+	case reflect.Float32, reflect.Float64:
+		return binary.BigEndian.AppendUint64(buf, math.Float64bits(v.Float()))
+	case reflect.String:
+		return append(buf, v.String()...)
+	default:
+		return append(buf, v.Bytes()...)
+	}
+}
+
+func appendInt(buf []byte, i int64, size int) []byte {
+	for shift := 8 * (size - 1); shift >= 0; shift -= 8 {
+		buf = append(buf, byte(i>>shift))
+	}
+	return buf
+}
+
+// ReadHeader returns the serial types in the header of record and the offset its body starts at
+func ReadHeader(record []byte) (error, []uint64, int) {
+	err, headerLen, n := ReadVarint(record)
+	if err != nil {
+		return err, nil, 0
+	}
+	if headerLen < uint64(n) || headerLen > uint64(len(record)) {
+		return errors.New("record header length is out of range"), nil, 0
+	}
+	var types []uint64
+	for offset := n; offset < int(headerLen); offset += n {
+		var t uint64
+		err, t, n = ReadVarint(record[offset:headerLen])
+		if err != nil {
+			return err, nil, 0
+		}
+		if t == 10 || t == 11 {
+			return errors.New("record uses a reserved serial type"), nil, 0
+		}
+		types = append(types, t)
+	}
+	return nil, types, int(headerLen)
+}
+
+// Decode returns the values stored in record
+func Decode(record []byte) (error, []any) {
+	err, types, offset := ReadHeader(record)
+	if err != nil {
+		return err, nil
+	}
+	values := make([]any, len(types))
+	for i, t := range types {
+		// compared before converting, a corrupt serial type may give a length beyond an int
+		size := serialTypeSize(t)
+		if size > uint64(len(record)-offset) {
+			return errors.New("record body is truncated"), nil
+		}
+		values[i] = DecodeValue(t, record[offset:offset+int(size)])
+		offset += int(size)
+	}
+	return nil, values
+}
+
+// DecodeValue returns the value of serial type t stored in data, which holds exactly SerialTypeLen(t) bytes
+func DecodeValue(t uint64, data []byte) any {
+	switch {
+	case t == SerialNull:
+		return nil
+	case t == SerialZero:
+		return int64(0)
+	case t == SerialOne:
+		return int64(1)
+	case t == SerialFloat:
+		return math.Float64frombits(binary.BigEndian.Uint64(data))
+	case t >= 1 && t <= 6:
+		// sign-extend from the first byte
+		i := int64(int8(data[0]))
+		for _, b := range data[1:] {
+			i = i<<8 | int64(b)
+		}
+		return i
+	case t%2 == 0:
+		return append([]byte{}, data...)
+	default:
+		return string(data)
+	}
+}
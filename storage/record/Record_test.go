@@ -0,0 +1,113 @@
+package record
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIntSerialTypes(t *testing.T) {
+	tests := []struct {
+		value      int64
+		serialType uint64
+	}{
+		{0, 8}, {1, 9}, {2, 1}, {-1, 1}, {127, 1}, {-128, 1}, {128, 2}, {-32768, 2},
+		{32768, 3}, {1<<23 - 1, 3}, {1 << 23, 4}, {-1 << 31, 4}, {1 << 31, 5},
+		{1<<47 - 1, 5}, {1 << 47, 6}, {math.MinInt64, 6}, {math.MaxInt64, 6},
+	}
+	for _, test := range tests {
+		_, serialType := SerialType(test.value)
+		if serialType != test.serialType {
+			t.Errorf("Expected serial type %d for %d, got %d", test.serialType, test.value, serialType)
+		}
+		err, values := Decode(mustEncode(t, []any{test.value}))
+		if err != nil || values[0] != test.value {
+			t.Errorf("Expected %d after round trip, got %v (%v)", test.value, values, err)
+		}
+	}
+}
+
+func mustEncode(t *testing.T, values []any) []byte {
+	t.Helper()
+	err, record := Encode(values)
+	if err != nil {
+		t.Fatalf("Unexpected error encoding %v: %v", values, err)
+	}
+	return record
+}
+
+func TestRecordLayout(t *testing.T) {
+	// the record SQLite stores for the row (NULL, 1, 300, 'hi', x'00ff', 0.5)
+	record := mustEncode(t, []any{nil, 1, 300, "hi", []byte{0x00, 0xff}, 0.5})
+	expected := []byte{
+		0x07, 0x00, 0x09, 0x02, 0x11, 0x10, 0x07,
+		0x01, 0x2c,
+		'h', 'i',
+		0x00, 0xff,
+		0x3f, 0xe0, 0, 0, 0, 0, 0, 0,
+	}
+	if !bytes.Equal(record, expected) {
+		t.Errorf("Expected record %x, got %x", expected, record)
+	}
+}
+
+func TestRecordRoundTrip(t *testing.T) {
+	values := []any{nil, int64(-5), int64(1 << 40), 3.25, "", "text", []byte{}, []byte("blob"), int64(0), int64(1)}
+	err, decoded := Decode(mustEncode(t, values))
+	if err != nil {
+		t.Fatalf("Unexpected error decoding: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, values) {
+		t.Errorf("Expected %v after round trip, got %v", values, decoded)
+	}
+
+	// other Go types are stored as their SQLite counterparts
+	err, decoded = Decode(mustEncode(t, []any{int8(-3), uint16(500), float32(1.5)}))
+	if err != nil || !reflect.DeepEqual(decoded, []any{int64(-3), int64(500), 1.5}) {
+		t.Errorf("Unexpected values %v (%v)", decoded, err)
+	}
+}
+
+func TestRecordLongHeader(t *testing.T) {
+	// 100 text values of 100 bytes need two-byte serial types, the header length takes two bytes
+	values := make([]any, 100)
+	for i := range values {
+		values[i] = strings.Repeat("x", 100)
+	}
+	record := mustEncode(t, values)
+	err, types, offset := ReadHeader(record)
+	if err != nil {
+		t.Fatalf("Unexpected error reading header: %v", err)
+	}
+	if offset != 202 || len(types) != 100 || types[0] != 213 {
+		t.Errorf("Unexpected header: offset %d, %d types", offset, len(types))
+	}
+	if len(record) != 202+100*100 {
+		t.Errorf("Unexpected record length %d", len(record))
+	}
+}
+
+func TestRecordErrors(t *testing.T) {
+	if err, _ := Encode([]any{struct{}{}}); err == nil {
+		t.Error("Expected error encoding a struct")
+	}
+	if err, _ := Encode([]any{uint64(math.MaxUint64)}); err == nil {
+		t.Error("Expected error encoding an integer beyond int64")
+	}
+	record := mustEncode(t, []any{"hello"})
+	if err, _ := Decode(record[:len(record)-1]); err == nil {
+		t.Error("Expected error decoding a truncated record")
+	}
+	if err, _ := Decode([]byte{0x02, 0x0a}); err == nil {
+		t.Error("Expected error decoding a reserved serial type")
+	}
+	huge := AppendVarint([]byte{0x0a}, math.MaxUint64)
+	if err, _ := Decode(append(huge, "text"...)); err == nil {
+		t.Error("Expected error decoding a serial type longer than the body")
+	}
+	if err, _ := Decode([]byte{0x05, 0x00}); err == nil {
+		t.Error("Expected error decoding a header longer than the record")
+	}
+}
@@ -0,0 +1,66 @@
+package record
+
+import (
+	"errors"
+)
+
+/*
+Varints are SQLite's variable-length integers: 1 to 9 bytes, big-endian. The first eight bytes carry
+7 bits each and have the high bit set when another byte follows, a ninth byte carries all 8 of its bits.
+Every uint64 fits, small values take a single byte.
+*/
+
+const MaxVarintLen = 9
+
+// VarintLen returns the number of bytes the varint of v takes
+func VarintLen(v uint64) int {
+	if v > 1<<56-1 {
+		return MaxVarintLen
+	}
+	n := 1
+	for v >>= 7; v != 0; v >>= 7 {
+		n++
+	}
+	return n
+}
+
+// AppendVarint appends the varint of v to buf
+func AppendVarint(buf []byte, v uint64) []byte {
+	if v > 1<<56-1 {
+		// the ninth byte holds the low 8 bits, the first eight bytes the 56 bits above them
+		for shift := 57; shift >= 8; shift -= 7 {
+			buf = append(buf, byte(v>>shift)|0x80)
+		}
+		return append(buf, byte(v))
+	}
+	n := VarintLen(v)
+	for i := n - 1; i > 0; i-- {
+		buf = append(buf, byte(v>>(7*i))|0x80)
+	}
+	return append(buf, byte(v)&0x7f)
+}
+
+// PutVarint writes the varint of v to the start of buf and returns the number of bytes written.
+// buf must hold at least VarintLen(v) bytes.
+func PutVarint(buf []byte, v uint64) int {
+	var tmp [MaxVarintLen]byte
+	return copy(buf, AppendVarint(tmp[:0], v))
+}
+
+// ReadVarint decodes the varint at the start of buf and returns it with the number of bytes it took
+func ReadVarint(buf []byte) (error, uint64, int) {
+	var v uint64
+	for i := 0; i < MaxVarintLen; i++ {
+		if i == len(buf) {
+			return errors.New("varint is truncated"), 0, 0
+		}
+		if i == MaxVarintLen-1 {
+			return nil, v<<8 | uint64(buf[i]), MaxVarintLen
+		}
+		v = v<<7 | uint64(buf[i]&0x7f)
+		if buf[i]&0x80 == 0 {
+			return nil, v, i + 1
+		}
+	}
+	return nil, v, MaxVarintLen
+}
@@ -0,0 +1,60 @@
+package record
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestVarintEncoding(t *testing.T) {
+	tests := []struct {
+		v       uint64
+		encoded []byte
+	}{
+		{0, []byte{0x00}},
+		{127, []byte{0x7f}},
+		{128, []byte{0x81, 0x00}},
+		{240, []byte{0x81, 0x70}},
+		{16383, []byte{0xff, 0x7f}},
+		{16384, []byte{0x81, 0x80, 0x00}},
+		{1<<56 - 1, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f}},
+		{1 << 56, []byte{0x80, 0xc0, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x00}},
+		{math.MaxUint64, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+	}
+	for _, test := range tests {
+		encoded := AppendVarint(nil, test.v)
+		if !bytes.Equal(encoded, test.encoded) {
+			t.Errorf("Expected varint of %d to be %x, got %x", test.v, test.encoded, encoded)
+		}
+		if VarintLen(test.v) != len(test.encoded) {
+			t.Errorf("Expected length %d for %d, got %d", len(test.encoded), test.v, VarintLen(test.v))
+		}
+		err, v, n := ReadVarint(append(encoded, 0xaa))
+		if err != nil || v != test.v || n != len(test.encoded) {
+			t.Errorf("Expected to read %d from %x, got %d (%d bytes, %v)", test.v, encoded, v, n, err)
+		}
+		buf := make([]byte, MaxVarintLen)
+		if n := PutVarint(buf, test.v); !bytes.Equal(buf[:n], test.encoded) {
+			t.Errorf("PutVarint wrote %x for %d", buf[:n], test.v)
+		}
+	}
+}
+
+func TestVarintRoundTrip(t *testing.T) {
+	for shift := 0; shift < 64; shift++ {
+		for _, v := range []uint64{1<<shift - 1, 1 << shift, 1<<shift + 1} {
+			err, decoded, _ := ReadVarint(AppendVarint(nil, v))
+			if err != nil || decoded != v {
+				t.Errorf("Expected %d after round trip, got %d", v, decoded)
+			}
+		}
+	}
+}
+
+func TestReadVarintTruncated(t *testing.T) {
+	for _, buf := range [][]byte{nil, {0x81}, {0xff, 0xff, 0xff}} {
+		if err, _, _ := ReadVarint(buf); err == nil {
+			t.Errorf("Expected error reading %x", buf)
+		}
+	}
+}